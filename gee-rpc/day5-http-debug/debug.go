@@ -6,10 +6,13 @@ package geerpc
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
+	"io"
 	"net/http"
 	"reflect"
+	"strings"
 )
 
 const debugText = `<html>
@@ -61,14 +64,85 @@ func (server debugHTTP) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	}
 }
 
+// RPCWeb serves the methods of a Server as JSON over HTTP, the args are
+// decoded from the first of the params and the reply is the result.
+// []byte values, of args or reply fields as well, are base64 strings like
+// encoding/json encodes them.
 type RPCWeb struct {
 	*Server
+	// MaxParams limits the number of params of a request, 0 means no limit
+	MaxParams int
+	// MaxParamsSize limits the total size of the encoded params of a request
+	// in bytes, 0 means no limit
+	MaxParamsSize int
+	// StatusCodes maps the Code of an *RPCError returned by a method to the
+	// HTTP status of the response, overriding DefaultStatusCodes.
+	// Errors without a status are 500.
+	StatusCodes map[Code]int
+	// Pprof serves the profiles of the process under /debug/pprof/ like
+	// net/http/pprof, on the mux RPCWeb is registered on, e.g. to profile
+	// a running server. It's off by default, then the path is not found.
+	Pprof bool
 }
 
+// StatusReply is a reply carrying the HTTP status of the RPCWeb response
+// along with the result, for REST-like methods, e.g.
+//
+//	func (t *T) Create(args Item, reply *geerpc.StatusReply) error {
+//		reply.Code, reply.Body = http.StatusCreated, args
+//		return nil
+//	}
+//
+// The result is Body, Code 0 means 200. Over RPC it's an ordinary reply, the
+// type of Body has to be registered by gob.Register for the gob codec.
+type StatusReply struct {
+	Code int
+	Body interface{}
+}
+
+// DefaultStatusCodes is the default HTTP status of the errors returned by methods
+var DefaultStatusCodes = map[Code]int{
+	InvalidArgument:   http.StatusBadRequest,
+	NotFound:          http.StatusNotFound,
+	Unauthenticated:   http.StatusUnauthorized,
+	PermissionDenied:  http.StatusForbidden,
+	ResourceExhausted: http.StatusTooManyRequests,
+	DeadlineExceeded:  http.StatusGatewayTimeout,
+	Unavailable:       http.StatusServiceUnavailable,
+}
+
+// statusCode returns the HTTP status of err returned by a method
+func (web *RPCWeb) statusCode(err error) int {
+	var rpcErr *RPCError
+	if !errors.As(err, &rpcErr) {
+		return http.StatusInternalServerError
+	}
+	if status, ok := web.StatusCodes[rpcErr.Code]; ok {
+		return status
+	}
+	if status, ok := DefaultStatusCodes[rpcErr.Code]; ok {
+		return status
+	}
+	return http.StatusInternalServerError
+}
+
+const (
+	defaultMaxParams     = 64
+	defaultMaxParamsSize = 1 << 20
+)
+
+var (
+	errTooManyParams   = errors.New("too many parameters")
+	errParamsTooLarge  = errors.New("parameters too large")
+	errInvalidJSONBody = errors.New("invalid request body")
+)
+
 // NewRPCWeb returns a new RPCWeb instance with the default server.
 func NewRPCWeb() *RPCWeb {
 	rpc_web := &RPCWeb{
-		Server: DefaultServer,
+		Server:        DefaultServer,
+		MaxParams:     defaultMaxParams,
+		MaxParamsSize: defaultMaxParamsSize,
 	}
 	// Register the debug HTTP handler
 	rpc_web.RegisterDebugHTTP()
@@ -81,8 +155,8 @@ func (web *RPCWeb) RegisterDebugHTTP() {
 }
 
 type RpcWebRequestBody struct {
-	Method string        `json:"method"`
-	Params []interface{} `json:"params"`
+	Method string            `json:"method"`
+	Params []json.RawMessage `json:"params"`
 }
 type RpcWebResponse struct {
 	Result interface{} `json:"result"`
@@ -90,12 +164,25 @@ type RpcWebResponse struct {
 
 // ServeHTTP implements the http.Handler interface for RPCWeb.
 func (web *RPCWeb) ServeHTTP(w http.ResponseWriter, req *http.Request) {
-	var requestBody *RpcWebRequestBody
 	defer req.Body.Close()
-	readCloser := req.Body
-
-	err := json.NewDecoder(readCloser).Decode(&requestBody)
-	if err != nil {
+	if strings.HasPrefix(req.URL.Path, pprofPath) {
+		if !web.Pprof {
+			http.NotFound(w, req)
+			return
+		}
+		servePprof(w, req)
+		return
+	}
+	requestBody, err := web.decodeRequest(req.Body)
+	switch err {
+	case nil:
+	case errTooManyParams:
+		http.Error(w, fmt.Sprintf("Too many parameters: expect at most %d", web.MaxParams), http.StatusBadRequest)
+		return
+	case errParamsTooLarge:
+		http.Error(w, fmt.Sprintf("Parameters too large: expect at most %d bytes", web.MaxParamsSize), http.StatusBadRequest)
+		return
+	default:
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
@@ -109,13 +196,11 @@ func (web *RPCWeb) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	if argv.Type().Kind() != reflect.Ptr {
 		argvi = argv.Addr().Interface()
 	}
-	// todo 将requestBody的params 转为argv
-	paramsBytes, err := json.Marshal(requestBody.Params[0])
-	if err != nil {
+	if len(requestBody.Params) == 0 {
 		http.Error(w, "Invalid parameters", http.StatusBadRequest)
 		return
 	}
-	if err := json.Unmarshal(paramsBytes, argvi); err != nil {
+	if err := json.Unmarshal(requestBody.Params[0], argvi); err != nil {
 		http.Error(w, fmt.Sprintf("Invalid parameter types: %s", err.Error()), http.StatusBadRequest)
 		return
 	}
@@ -123,17 +208,85 @@ func (web *RPCWeb) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	replyv := mtype.newReplyv()
 	err = svc.call(mtype, argv, replyv)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error calling method: %s", err.Error()), http.StatusInternalServerError)
+		http.Error(w, fmt.Sprintf("Error calling method: %s", err.Error()), web.statusCode(err))
 		return
 	}
 	response := &RpcWebResponse{
 		Result: replyv.Interface(),
 	}
+	status := http.StatusOK
+	if sr, ok := response.Result.(*StatusReply); ok {
+		response.Result = sr.Body
+		if sr.Code != 0 {
+			status = sr.Code
+		}
+	}
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
+	w.WriteHeader(status)
 	err = json.NewEncoder(w).Encode(response)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Error encoding response: %s", err.Error()), http.StatusInternalServerError)
 		return
 	}
 }
+
+// decodeRequest decodes the request body, the params are counted and
+// measured while they are decoded one by one, so that it gives up as soon
+// as MaxParams or MaxParamsSize is exceeded.
+func (web *RPCWeb) decodeRequest(r io.Reader) (*RpcWebRequestBody, error) {
+	dec := json.NewDecoder(r)
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return nil, errInvalidJSONBody
+	}
+	body := new(RpcWebRequestBody)
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		// keys are case-insensitive like json.Unmarshal
+		switch key, _ := tok.(string); {
+		case strings.EqualFold(key, "method"):
+			err = dec.Decode(&body.Method)
+		case strings.EqualFold(key, "params"):
+			body.Params, err = web.decodeParams(dec)
+		default:
+			var skip json.RawMessage
+			err = dec.Decode(&skip)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
+func (web *RPCWeb) decodeParams(dec *json.Decoder) ([]json.RawMessage, error) {
+	tok, err := dec.Token()
+	if err != nil || tok == nil { // null
+		return nil, err
+	}
+	if tok != json.Delim('[') {
+		return nil, errInvalidJSONBody
+	}
+	var params []json.RawMessage
+	size := 0
+	for dec.More() {
+		if web.MaxParams > 0 && len(params) >= web.MaxParams {
+			return nil, errTooManyParams
+		}
+		var param json.RawMessage
+		if err := dec.Decode(&param); err != nil {
+			return nil, err
+		}
+		if size += len(param); web.MaxParamsSize > 0 && size > web.MaxParamsSize {
+			return nil, errParamsTooLarge
+		}
+		params = append(params, param)
+	}
+	_, err = dec.Token()
+	return params, err
+}
@@ -0,0 +1,187 @@
+package geerpc
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func newTestRPCWeb() *RPCWeb {
+	server := NewServer()
+	var foo Foo
+	_ = server.Register(&foo)
+	return &RPCWeb{Server: server, MaxParams: 4, MaxParamsSize: 1024}
+}
+
+func TestRPCWeb_ServeHTTP(t *testing.T) {
+	web := newTestRPCWeb()
+	w := httptest.NewRecorder()
+	body := `{"method": "Foo.Sum", "params": [{"Num1": 1, "Num2": 2}]}`
+	web.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body)))
+	var resp RpcWebResponse
+	_ = json.NewDecoder(w.Body).Decode(&resp)
+	_assert(w.Code == http.StatusOK && resp.Result == float64(3), "failed to call Foo.Sum: %d %v", w.Code, resp.Result)
+}
+
+func TestRPCWeb_ParamsLimit(t *testing.T) {
+	web := newTestRPCWeb()
+	post := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		web.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body)))
+		return w
+	}
+
+	params := strings.Repeat(`{"Num1": 1, "Num2": 2}, `, 100) + `{}`
+	w := post(`{"method": "Foo.Sum", "params": [` + params + `]}`)
+	_assert(w.Code == http.StatusBadRequest && strings.Contains(w.Body.String(), "Too many parameters"),
+		"expect oversized params array rejected, got %d %s", w.Code, w.Body.String())
+
+	w = post(`{"method": "Foo.Sum", "params": [{"Num1": 1, "Num2": 2, "Pad": "` + strings.Repeat("x", 2048) + `"}]}`)
+	_assert(w.Code == http.StatusBadRequest && strings.Contains(w.Body.String(), "Parameters too large"),
+		"expect large params rejected, got %d %s", w.Code, w.Body.String())
+
+	w = post(`{"method": "Foo.Sum", "params": []}`)
+	_assert(w.Code == http.StatusBadRequest, "expect empty params rejected, got %d", w.Code)
+
+	w = post(`{"method": "Foo.Sum", "params": [{"Num1": 1, "Num2": 2}, 1, 2, 3]}`)
+	_assert(w.Code == http.StatusOK, "expect params within the limits accepted, got %d %s", w.Code, w.Body.String())
+}
+
+type Failer int
+
+func (f Failer) Fail(code Code, reply *int) error {
+	if code == Unknown {
+		return errors.New("plain error")
+	}
+	return Errorf(code, "failed with %s", code)
+}
+
+func TestRPCWeb_StatusCodes(t *testing.T) {
+	server := NewServer()
+	var f Failer
+	_ = server.Register(&f)
+	web := &RPCWeb{Server: server}
+	post := func(code Code) int {
+		w := httptest.NewRecorder()
+		body := fmt.Sprintf(`{"method": "Failer.Fail", "params": [%d]}`, code)
+		web.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body)))
+		return w.Code
+	}
+	for code, status := range map[Code]int{
+		NotFound:          http.StatusNotFound,
+		InvalidArgument:   http.StatusBadRequest,
+		Unauthenticated:   http.StatusUnauthorized,
+		ResourceExhausted: http.StatusTooManyRequests,
+		DeadlineExceeded:  http.StatusGatewayTimeout,
+		Internal:          http.StatusInternalServerError,
+		Unknown:           http.StatusInternalServerError,
+	} {
+		got := post(code)
+		_assert(got == status, "expect %s mapped to %d, got %d", code, status, got)
+	}
+
+	web.StatusCodes = map[Code]int{NotFound: http.StatusGone, Internal: http.StatusBadGateway}
+	_assert(post(NotFound) == http.StatusGone, "expect the mapping overridden")
+	_assert(post(Internal) == http.StatusBadGateway, "expect a code without default status mapped")
+	_assert(post(InvalidArgument) == http.StatusBadRequest, "expect the default for codes not overridden")
+}
+
+type Blob struct {
+	Name string
+	Data []byte
+}
+
+type Bytes int
+
+func (b Bytes) Reverse(blob Blob, reply *[]byte) error {
+	for i := len(blob.Data) - 1; i >= 0; i-- {
+		*reply = append(*reply, blob.Data[i])
+	}
+	return nil
+}
+
+func TestRPCWeb_Bytes(t *testing.T) {
+	server := NewServer()
+	var b Bytes
+	_ = server.Register(&b)
+	web := &RPCWeb{Server: server}
+	post := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		web.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body)))
+		return w
+	}
+
+	data := []byte{0x00, 0xff, 0xfe, 'g', 0x80}
+	w := post(`{"method": "Bytes.Reverse", "params": [{"Name": "x", "Data": "` + base64.StdEncoding.EncodeToString(data) + `"}]}`)
+	var resp struct{ Result []byte }
+	err := json.NewDecoder(w.Body).Decode(&resp)
+	_assert(w.Code == http.StatusOK && err == nil, "failed to call Bytes.Reverse: %d %v", w.Code, err)
+	_assert(bytes.Equal(resp.Result, []byte{0x80, 'g', 0xfe, 0xff, 0x00}), "expect the reversed bytes, got %v", resp.Result)
+
+	w = post(`{"method": "Bytes.Reverse", "params": [{"Data": "not base64!"}]}`)
+	_assert(w.Code == http.StatusBadRequest && strings.Contains(w.Body.String(), "Invalid parameter types"),
+		"expect invalid base64 rejected, got %d %s", w.Code, w.Body.String())
+}
+
+type Items int
+
+func (i Items) Create(name string, reply *StatusReply) error {
+	reply.Code, reply.Body = http.StatusCreated, Blob{Name: name}
+	return nil
+}
+
+func (i Items) Get(name string, reply *StatusReply) error {
+	reply.Body = Blob{Name: name}
+	return nil
+}
+
+func TestRPCWeb_StatusReply(t *testing.T) {
+	server := NewServer()
+	var i Items
+	_ = server.Register(&i)
+	web := &RPCWeb{Server: server}
+	post := func(method string) (int, Blob) {
+		w := httptest.NewRecorder()
+		body := `{"method": "` + method + `", "params": ["geerpc"]}`
+		web.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body)))
+		var resp struct{ Result Blob }
+		err := json.NewDecoder(w.Body).Decode(&resp)
+		_assert(err == nil, "failed to decode the response of %s: %v", method, err)
+		return w.Code, resp.Result
+	}
+
+	code, blob := post("Items.Create")
+	_assert(code == http.StatusCreated && blob.Name == "geerpc", "expect 201 with the body as result, got %d %+v", code, blob)
+	code, blob = post("Items.Get")
+	_assert(code == http.StatusOK && blob.Name == "geerpc", "expect 200 without a code, got %d %+v", code, blob)
+}
+
+func TestRPCWeb_Pprof(t *testing.T) {
+	web := newTestRPCWeb()
+	w := httptest.NewRecorder()
+	web.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/debug/pprof/", nil))
+	_assert(w.Code == http.StatusNotFound, "expect pprof not found unless enabled, got %d", w.Code)
+
+	web.Pprof = true
+	w = httptest.NewRecorder()
+	web.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/debug/pprof/", nil))
+	_assert(w.Code == http.StatusOK && strings.Contains(w.Body.String(), "goroutine"), "expect the index of profiles, got %d %s", w.Code, w.Body.String())
+	w = httptest.NewRecorder()
+	web.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/debug/pprof/goroutine?debug=1", nil))
+	_assert(w.Code == http.StatusOK && strings.Contains(w.Body.String(), "TestRPCWeb_Pprof"), "expect the goroutine profile, got %d", w.Code)
+	w = httptest.NewRecorder()
+	web.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/debug/pprof/unknown", nil))
+	_assert(w.Code == http.StatusNotFound, "expect an unknown profile not found, got %d", w.Code)
+
+	// the RPC calls are still served at the other paths
+	w = httptest.NewRecorder()
+	body := `{"method": "Foo.Sum", "params": [{"Num1": 1, "Num2": 2}]}`
+	web.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body)))
+	_assert(w.Code == http.StatusOK, "failed to call Foo.Sum with pprof enabled: %d", w.Code)
+}
@@ -0,0 +1,58 @@
+package geerpc
+
+import "fmt"
+
+// Code classifies the error returned by a method
+type Code int
+
+const (
+	Unknown Code = iota
+	InvalidArgument
+	NotFound
+	Unauthenticated
+	PermissionDenied
+	ResourceExhausted
+	DeadlineExceeded
+	Unavailable
+	Internal
+)
+
+var codeNames = map[Code]string{
+	Unknown:           "Unknown",
+	InvalidArgument:   "InvalidArgument",
+	NotFound:          "NotFound",
+	Unauthenticated:   "Unauthenticated",
+	PermissionDenied:  "PermissionDenied",
+	ResourceExhausted: "ResourceExhausted",
+	DeadlineExceeded:  "DeadlineExceeded",
+	Unavailable:       "Unavailable",
+	Internal:          "Internal",
+}
+
+func (c Code) String() string {
+	if name, ok := codeNames[c]; ok {
+		return name
+	}
+	return fmt.Sprintf("Code(%d)", int(c))
+}
+
+// RPCError is an error carrying a Code and where it's created, methods
+// return it to tell what kind of failure happens, e.g. RPCWeb maps it to
+// the HTTP status. It's the same as the RPCError of day7-registry.
+type RPCError struct {
+	Code     Code
+	Message  string
+	Location string // file:line, optional
+}
+
+func (e *RPCError) Error() string {
+	if e.Code == Unknown {
+		return e.Message
+	}
+	return e.Code.String() + ": " + e.Message
+}
+
+// Errorf returns an *RPCError with code and the formatted message
+func Errorf(code Code, format string, a ...interface{}) error {
+	return &RPCError{Code: code, Message: fmt.Sprintf(format, a...)}
+}
@@ -0,0 +1,98 @@
+package geerpc
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"os"
+	"runtime/pprof"
+	"runtime/trace"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// pprofPath is where RPCWeb serves the profiles if RPCWeb.Pprof is set.
+// The handlers are like the ones of net/http/pprof, which isn't imported
+// since it registers them on http.DefaultServeMux by itself.
+const pprofPath = "/debug/pprof/"
+
+const pprofText = `<html>
+	<body>
+	<title>GeeRPC Profiles</title>
+	<table>
+	<th align=center>Count</th><th align=center>Profile</th>
+	{{range .}}
+		<tr>
+		<td align=center>{{.Count}}</td>
+		<td align=left><a href="{{.Name}}?debug=1">{{.Name}}</a></td>
+		</tr>
+	{{end}}
+	</table>
+	<a href="profile?seconds=30">profile</a> of the CPU,
+	<a href="trace?seconds=1">trace</a> of the execution,
+	<a href="cmdline">cmdline</a>
+	</body>
+	</html>`
+
+var pprofIndex = template.Must(template.New("RPC pprof").Parse(pprofText))
+
+type pprofProfile struct {
+	Name  string
+	Count int
+}
+
+// servePprof serves the profile named by the path after pprofPath, the
+// index of the profiles if it's empty
+func servePprof(w http.ResponseWriter, req *http.Request) {
+	switch name := strings.TrimPrefix(req.URL.Path, pprofPath); name {
+	case "":
+		var profiles []pprofProfile
+		for _, p := range pprof.Profiles() {
+			profiles = append(profiles, pprofProfile{Name: p.Name(), Count: p.Count()})
+		}
+		sort.Slice(profiles, func(i, j int) bool { return profiles[i].Name < profiles[j].Name })
+		if err := pprofIndex.Execute(w, profiles); err != nil {
+			_, _ = fmt.Fprintln(w, "rpc: error executing template:", err.Error())
+		}
+	case "cmdline":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_, _ = fmt.Fprint(w, strings.Join(os.Args, "\x00"))
+	case "profile", "trace":
+		seconds, err := strconv.ParseFloat(req.FormValue("seconds"), 64)
+		if err != nil || seconds <= 0 {
+			seconds = 30
+			if name == "trace" {
+				seconds = 1
+			}
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+		start, stop := pprof.StartCPUProfile, pprof.StopCPUProfile
+		if name == "trace" {
+			start, stop = trace.Start, trace.Stop
+		}
+		if err := start(w); err != nil {
+			w.Header().Del("Content-Disposition")
+			http.Error(w, "Could not enable "+name+": "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		time.Sleep(time.Duration(seconds * float64(time.Second)))
+		stop()
+	default:
+		p := pprof.Lookup(name)
+		if p == nil {
+			http.Error(w, "Unknown profile: "+name, http.StatusNotFound)
+			return
+		}
+		debug, _ := strconv.Atoi(req.FormValue("debug"))
+		if debug != 0 {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		} else {
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+		}
+		_ = p.WriteTo(w, debug)
+	}
+}
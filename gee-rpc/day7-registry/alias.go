@@ -0,0 +1,35 @@
+package geerpc
+
+import (
+	"errors"
+	"strings"
+)
+
+// RegisterAlias makes alias, of format "Service.Method", another name of the
+// registered method target, e.g. to keep the old name of a renamed method
+// working while clients migrate:
+//
+//	server.RegisterAlias("Arith.Add", "Arith.Sum")
+//
+// A call of alias is handled by target, it shares its stats and options and
+// is exposed if target is, see AllowMethods. The name of a registered method
+// can't be an alias, nor can an alias be the target of another one.
+func (server *Server) RegisterAlias(alias, target string) error {
+	dot := strings.LastIndex(alias, ".")
+	if dot <= 0 || dot == len(alias)-1 {
+		return errors.New("rpc: can't register alias " + alias + ", expect a Service.Method name")
+	}
+	if _, mtype, _ := server.lookupMethod(target); mtype == nil {
+		return errors.New("rpc: can't register alias " + alias + ", no method " + target)
+	}
+	if _, mtype, _ := server.lookupMethod(alias); mtype != nil {
+		return errors.New("rpc: can't register alias " + alias + ", it's a registered method")
+	}
+	if _, dup := server.aliases.LoadOrStore(alias, target); dup {
+		return errors.New("rpc: alias already defined: " + alias)
+	}
+	return nil
+}
+
+// RegisterAlias makes alias another name of the method target in the DefaultServer.
+func RegisterAlias(alias, target string) error { return DefaultServer.RegisterAlias(alias, target) }
@@ -0,0 +1,36 @@
+package geerpc
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestServer_RegisterAlias(t *testing.T) {
+	server := NewServer()
+	var foo Foo
+	_ = server.Register(&foo)
+	_assert(server.RegisterAlias("Foo.Add", "Foo.Sum") == nil, "failed to register alias Foo.Add")
+	_assert(server.RegisterAlias("Legacy.Sum", "Foo.Sum") == nil, "failed to register alias Legacy.Sum")
+	_assert(server.RegisterAlias("Foo.Add", "Foo.Sum") != nil, "expect a duplicate alias rejected")
+	_assert(server.RegisterAlias("Foo.Sum", "Foo.Add") != nil, "expect a registered method rejected as alias")
+	_assert(server.RegisterAlias("Foo.Minus", "Foo.Missing") != nil, "expect a missing target rejected")
+	_assert(server.RegisterAlias("Foo.Plus", "Foo.Add") != nil, "expect an alias rejected as target")
+	_assert(server.RegisterAlias("Sum", "Foo.Sum") != nil, "expect an ill-formed alias rejected")
+
+	client, _ := Dial("tcp", startTestServer(server))
+	defer func() { _ = client.Close() }()
+	for _, name := range []string{"Foo.Sum", "Foo.Add", "Legacy.Sum"} {
+		var reply int
+		err := client.Call(context.Background(), name, Args{Num1: 1, Num2: 2}, &reply)
+		_assert(err == nil && reply == 3, "failed to call %s: %v", name, err)
+	}
+	_, mtype, _ := server.findService("Foo.Add")
+	_assert(mtype.NumCalls() == 3, "expect the calls of aliases counted by the method, got %d", mtype.NumCalls())
+
+	w := httptest.NewRecorder()
+	debugHTTP{server}.ServeHTTP(w, httptest.NewRequest("GET", defaultDebugPath, nil))
+	row := "<td align=left font=fixed>Legacy.Sum (alias)</td>\n\t\t\t<td align=left font=fixed>Foo.Sum</td>"
+	_assert(strings.Contains(w.Body.String(), row), "expect the alias on the debug page: %s", w.Body)
+}
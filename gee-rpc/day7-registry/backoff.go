@@ -0,0 +1,98 @@
+package geerpc
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Backoff is the strategy of the delays between retries, e.g. of dialing,
+// see Option.Backoff. attempt is 1 for the delay before the first retry.
+type Backoff interface {
+	NextDelay(attempt int) time.Duration
+}
+
+// ConstantBackoff waits Delay before every retry
+type ConstantBackoff struct {
+	Delay time.Duration
+}
+
+func (b ConstantBackoff) NextDelay(attempt int) time.Duration { return b.Delay }
+
+// ExponentialBackoff waits Base before the first retry and twice as long
+// before each next one, up to Max, 0 means no limit.
+type ExponentialBackoff struct {
+	Base, Max time.Duration
+}
+
+func (b ExponentialBackoff) NextDelay(attempt int) time.Duration {
+	return exponential(b.Base, b.Max, attempt)
+}
+
+// JitteredBackoff is an ExponentialBackoff whose delays are random between
+// half and the whole delay, so that clients failed together don't retry
+// together.
+type JitteredBackoff struct {
+	Base, Max time.Duration
+}
+
+func (b JitteredBackoff) NextDelay(attempt int) time.Duration {
+	d := exponential(b.Base, b.Max, attempt)
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// DecorrelatedBackoff waits a random delay between Base and three times the
+// previous delay, up to Max, 0 means no limit. The delays spread more than
+// JitteredBackoff's while they still grow. It keeps the previous delay, so
+// it's restarted by attempt 1 and must not be shared by concurrent retries.
+type DecorrelatedBackoff struct {
+	Base, Max time.Duration
+
+	mu   sync.Mutex
+	prev time.Duration
+}
+
+func (b *DecorrelatedBackoff) NextDelay(attempt int) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if attempt <= 1 || b.prev < b.Base {
+		b.prev = b.Base
+	}
+	upper := b.prev * 3
+	if upper < b.prev {
+		upper = maxDuration // overflowed
+	}
+	if b.Max > 0 && upper > b.Max {
+		upper = b.Max
+	}
+	d := b.Base
+	if n := int64(upper - b.Base); n > 0 {
+		if n < int64(maxDuration) {
+			n++
+		}
+		d += time.Duration(rand.Int63n(n))
+	}
+	b.prev = d
+	return d
+}
+
+// exponential returns base<<(attempt-1) up to max, 0 means no limit
+func exponential(base, max time.Duration, attempt int) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+	d := base
+	for i := 1; i < attempt; i++ {
+		if d > maxDuration/2 {
+			d = maxDuration
+			break
+		}
+		d *= 2
+	}
+	if max > 0 && d > max {
+		d = max
+	}
+	return d
+}
+
+const maxDuration = time.Duration(1<<63 - 1)
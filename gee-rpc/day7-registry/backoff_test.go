@@ -0,0 +1,86 @@
+package geerpc
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConstantBackoff(t *testing.T) {
+	b := ConstantBackoff{Delay: time.Millisecond * 10}
+	for attempt := 1; attempt <= 5; attempt++ {
+		_assert(b.NextDelay(attempt) == time.Millisecond*10, "expect a constant delay at attempt %d", attempt)
+	}
+}
+
+func TestExponentialBackoff(t *testing.T) {
+	b := ExponentialBackoff{Base: time.Millisecond, Max: time.Millisecond * 50}
+	want := []time.Duration{1, 2, 4, 8, 16, 32, 50, 50}
+	for i, w := range want {
+		d := b.NextDelay(i + 1)
+		_assert(d == w*time.Millisecond, "expect %s at attempt %d, got %s", w*time.Millisecond, i+1, d)
+	}
+	// doubling never overflows without a limit
+	d := ExponentialBackoff{Base: time.Second}.NextDelay(100)
+	_assert(d == maxDuration, "expect the delay to saturate, got %s", d)
+}
+
+func TestJitteredBackoff(t *testing.T) {
+	b := JitteredBackoff{Base: time.Millisecond * 10, Max: time.Millisecond * 100}
+	for attempt := 1; attempt <= 10; attempt++ {
+		full := ExponentialBackoff{Base: b.Base, Max: b.Max}.NextDelay(attempt)
+		seen := make(map[time.Duration]bool)
+		for i := 0; i < 50; i++ {
+			d := b.NextDelay(attempt)
+			_assert(d >= full/2 && d <= full, "expect the delay within [%s, %s] at attempt %d, got %s", full/2, full, attempt, d)
+			seen[d] = true
+		}
+		_assert(len(seen) > 1, "expect jittered delays at attempt %d", attempt)
+	}
+}
+
+func TestDecorrelatedBackoff(t *testing.T) {
+	b := &DecorrelatedBackoff{Base: time.Millisecond, Max: time.Millisecond * 100}
+	seen := make(map[time.Duration]bool)
+	for round := 0; round < 20; round++ {
+		prev := b.Base
+		for attempt := 1; attempt <= 10; attempt++ {
+			d := b.NextDelay(attempt)
+			upper := prev * 3
+			if attempt == 1 {
+				upper = b.Base * 3
+			}
+			if upper > b.Max {
+				upper = b.Max
+			}
+			_assert(d >= b.Base && d <= upper, "expect the delay within [%s, %s] at attempt %d, got %s", b.Base, upper, attempt, d)
+			seen[d] = true
+			prev = d
+		}
+	}
+	_assert(len(seen) > 10, "expect jittered delays, got %d distinct", len(seen))
+	_assert(b.NextDelay(1) <= b.Base*3, "expect attempt 1 to restart from Base")
+
+	unlimited := &DecorrelatedBackoff{Base: time.Hour}
+	for attempt := 1; attempt <= 100; attempt++ {
+		_assert(unlimited.NextDelay(attempt) >= time.Hour, "expect no overflow at attempt %d", attempt)
+	}
+}
+
+type countBackoff struct{ attempts []int }
+
+func (b *countBackoff) NextDelay(attempt int) time.Duration {
+	b.attempts = append(b.attempts, attempt)
+	return time.Millisecond
+}
+
+func TestOption_Backoff(t *testing.T) {
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	addr := l.Addr().String()
+	_ = l.Close()
+	b := new(countBackoff)
+	_, err := Dial("tcp", addr, &Option{DialRetries: 3, Backoff: b})
+	_assert(err != nil && strings.Contains(err.Error(), "after 4 attempts"), "expect the dial to fail, got %v", err)
+	_assert(len(b.attempts) == 3 && b.attempts[0] == 1 && b.attempts[2] == 3, "expect the custom backoff asked before each retry, got %v", b.attempts)
+}
@@ -0,0 +1,100 @@
+package geerpc
+
+import (
+	"context"
+	"reflect"
+	"sort"
+)
+
+// PingServiceMethod is served by every server without being registered,
+// nor filtered by AllowMethods and DenyMethods, see Client.Ping.
+const PingServiceMethod = "_ping.Ping"
+
+type pingService struct{}
+
+func (pingService) Ping(args int, reply *int) error { return nil }
+
+// builtinServices are looked up before the registered services,
+// their names aren't valid names of registered ones
+var builtinServices = map[string]*service{
+	"_ping": newBuiltinService("_ping", &pingService{}),
+}
+
+// IntrospectServiceMethod lists the methods of the server, like Ping it's
+// served by every server without being registered, see Client.Methods.
+// Unlike Ping it's filtered by AllowMethods and DenyMethods, e.g.
+// DenyMethods "_introspect.*" hides the listing.
+const IntrospectServiceMethod = "_introspect.List"
+
+// MethodDesc describes a method of a server, the types are formatted like %v
+type MethodDesc struct {
+	Service   string
+	Method    string
+	ArgType   string
+	ReplyType string
+}
+
+type introspectService struct {
+	server *Server
+}
+
+// List replies the methods exposed by the server, sorted by service then
+// method. The methods not exposed by AllowMethods and DenyMethods are left out.
+func (s *introspectService) List(args struct{}, reply *[]MethodDesc) error {
+	descs := []MethodDesc{}
+	s.server.serviceMap.Range(func(namei, svci interface{}) bool {
+		for methodName, mtype := range svci.(*service).method {
+			if !s.server.exposed(namei.(string) + "." + methodName) {
+				continue
+			}
+			descs = append(descs, MethodDesc{
+				Service:   namei.(string),
+				Method:    methodName,
+				ArgType:   mtype.ArgType.String(),
+				ReplyType: mtype.ReplyType.String(),
+			})
+		}
+		return true
+	})
+	sort.Slice(descs, func(i, j int) bool {
+		if descs[i].Service != descs[j].Service {
+			return descs[i].Service < descs[j].Service
+		}
+		return descs[i].Method < descs[j].Method
+	})
+	*reply = descs
+	return nil
+}
+
+// builtinService returns the built-in service of name, nil if there's none.
+// The introspection service of the server is made on first use.
+func (server *Server) builtinService(name string) *service {
+	if name != "_introspect" {
+		return builtinServices[name]
+	}
+	server.introspectOnce.Do(func() {
+		server.introspect = newBuiltinService(name, &introspectService{server: server})
+	})
+	return server.introspect
+}
+
+func newBuiltinService(name string, rcvr interface{}) *service {
+	s := &service{name: name, rcvr: reflect.ValueOf(rcvr)}
+	s.typ = s.rcvr.Type()
+	s.registerMethods()
+	return s
+}
+
+// Ping checks that the server is reachable and serving, e.g. for a
+// readiness check. It's a round trip of the built-in ping service.
+func (client *Client) Ping(ctx context.Context) error {
+	return client.Call(ctx, PingServiceMethod, 0, new(int))
+}
+
+// Methods returns the methods exposed by the server, e.g. for a dynamic
+// client to discover its API.
+func (client *Client) Methods(ctx context.Context) ([]MethodDesc, error) {
+	var descs []MethodDesc
+	err := client.Call(ctx, IntrospectServiceMethod, struct{}{}, &descs)
+	return descs, err
+}
@@ -0,0 +1,93 @@
+package geerpc
+
+import (
+	"bytes"
+	"container/list"
+	"encoding/gob"
+	"reflect"
+	"sync"
+	"time"
+)
+
+const defaultCacheSize = 1024
+
+// resultCache keeps the replies of a method by its gob encoded args for ttl,
+// the least recently used reply is evicted beyond size entries. Args holding
+// maps may be encoded differently each time, so they miss the cache.
+type resultCache struct {
+	ttl  time.Duration
+	size int
+
+	mu    sync.Mutex // protect following
+	ll    *list.List // front is the most recently used
+	items map[string]*list.Element
+}
+
+type cacheEntry struct {
+	key    string
+	reply  []byte // gob encoded
+	expire time.Time
+}
+
+func newResultCache(ttl time.Duration, size int) *resultCache {
+	if size <= 0 {
+		size = defaultCacheSize
+	}
+	return &resultCache{ttl: ttl, size: size, ll: list.New(), items: make(map[string]*list.Element)}
+}
+
+// lookup decodes the reply cached for argv into replyv, it returns whether
+// it's a hit and the key of argv, which is empty if argv can't be cached.
+// A nil cache never hits.
+func (c *resultCache) lookup(argv, replyv reflect.Value) (key string, hit bool) {
+	if c == nil {
+		return "", false
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).EncodeValue(argv); err != nil {
+		return "", false
+	}
+	key = buf.String()
+	c.mu.Lock()
+	e, ok := c.items[key]
+	var reply []byte
+	if ok {
+		if entry := e.Value.(*cacheEntry); time.Now().Before(entry.expire) {
+			c.ll.MoveToFront(e)
+			reply = entry.reply
+		} else {
+			c.ll.Remove(e)
+			delete(c.items, key)
+		}
+	}
+	c.mu.Unlock()
+	if reply == nil {
+		return key, false
+	}
+	return key, gob.NewDecoder(bytes.NewReader(reply)).DecodeValue(replyv) == nil
+}
+
+// store caches replyv for the key returned by lookup
+func (c *resultCache) store(key string, replyv reflect.Value) {
+	if c == nil || key == "" {
+		return
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).EncodeValue(replyv); err != nil {
+		return
+	}
+	entry := &cacheEntry{key: key, reply: buf.Bytes(), expire: time.Now().Add(c.ttl)}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.items[key]; ok {
+		e.Value = entry
+		c.ll.MoveToFront(e)
+		return
+	}
+	c.items[key] = c.ll.PushFront(entry)
+	for c.ll.Len() > c.size {
+		oldest := c.ll.Back()
+		c.ll.Remove(oldest)
+		delete(c.items, oldest.Value.(*cacheEntry).key)
+	}
+}
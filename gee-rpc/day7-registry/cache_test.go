@@ -0,0 +1,58 @@
+package geerpc
+
+import (
+	"context"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type Quote struct {
+	Symbol string
+	Price  int
+}
+
+// Pricer counts how many times its method body runs
+type Pricer struct{ runs int32 }
+
+func (p *Pricer) Quote(symbol string, reply *Quote) error {
+	n := atomic.AddInt32(&p.runs, 1)
+	*reply = Quote{Symbol: symbol, Price: int(n) * 100}
+	return nil
+}
+
+func TestServer_CacheTTL(t *testing.T) {
+	server := NewServer()
+	p := new(Pricer)
+	err := server.RegisterWithOption(p, ServiceOption{CacheTTL: map[string]time.Duration{"Quote": time.Millisecond * 200}, CacheSize: 2})
+	_assert(err == nil, "failed to register: %v", err)
+	client, _ := Dial("tcp", startTestServer(server))
+	defer func() { _ = client.Close() }()
+
+	quote := func(symbol string) Quote {
+		var q Quote
+		err := client.Call(context.Background(), "Pricer.Quote", symbol, &q)
+		_assert(err == nil && q.Symbol == symbol, "failed to call Pricer.Quote: %v", err)
+		return q
+	}
+	first, second := quote("GEE"), quote("GEE")
+	_assert(atomic.LoadInt32(&p.runs) == 1 && first == second, "expect the cached reply within ttl, got %d runs %v %v", p.runs, first, second)
+	_ = quote("RPC")
+	_assert(atomic.LoadInt32(&p.runs) == 2, "expect different args to call the method, got %d runs", p.runs)
+
+	// GEE is the least recently used once a third symbol is cached
+	_ = quote("GEE")
+	_ = quote("DAY")
+	_ = quote("RPC")
+	_assert(atomic.LoadInt32(&p.runs) == 4, "expect RPC evicted beyond the size, got %d runs", p.runs)
+
+	time.Sleep(time.Millisecond * 200)
+	_ = quote("GEE")
+	_assert(atomic.LoadInt32(&p.runs) == 5, "expect the method called once the ttl expires, got %d runs", p.runs)
+
+	err = NewServer().RegisterWithOption(new(Pricer), ServiceOption{CacheTTL: map[string]time.Duration{"Unknown": time.Second}})
+	_assert(err != nil && strings.Contains(err.Error(), "unknown method"), "expect an unknown method rejected, got %v", err)
+	err = NewServer().RegisterWithOption(new(Lister), ServiceOption{CacheTTL: map[string]time.Duration{"List": time.Second}})
+	_assert(err != nil, "expect a streaming method rejected")
+}
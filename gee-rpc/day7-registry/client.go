@@ -7,7 +7,6 @@ package geerpc
 import (
 	"bufio"
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"geerpc/codec"
@@ -15,6 +14,8 @@ import (
 	"log"
 	"net"
 	"net/http"
+	"reflect"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -28,9 +29,20 @@ type Call struct {
 	Reply         interface{} // reply from the function
 	Error         error       // if error occurs, it will be set
 	Done          chan *Call  // Strobes when call is complete.
+
+	client   *Client           // client sending the call, see Cancel
+	header   *codec.Header     // header of the response
+	stream   *stream           // frames of a streaming method, see Client.Stream
+	metadata map[string]string // metadata of the request, see Option.ContextMetadata
+	priority int               // see WithPriority
+	progress func(Progress)    // see WithProgress
+	start    time.Time         // when the call is registered, see InFlight
 }
 
 func (call *Call) done() {
+	if call.stream != nil {
+		call.stream.finish()
+	}
 	call.Done <- call
 }
 
@@ -40,28 +52,70 @@ func (call *Call) done() {
 // multiple goroutines simultaneously.
 type Client struct {
 	cc       codec.Codec
+	conn     net.Conn // nil if the client isn't made by NewClient
 	opt      *Option
 	sending  sync.Mutex // protect following
 	header   codec.Header
 	mu       sync.Mutex // protect following
 	seq      uint64
 	pending  map[uint64]*Call
-	closing  bool // user has called Close
-	shutdown bool // server has told us to stop
+	closing  bool        // user has called Close
+	shutdown bool        // server has told us to stop
+	retired  bool        // Option.MaxConnLifetime has passed, closed once no call is pending
+	lifetime *time.Timer // retires the client, see Option.MaxConnLifetime
 }
 
 var _ io.Closer = (*Client)(nil)
 
 var ErrShutdown = errors.New("connection is shut down")
 
-// Close the connection
+// ErrConnectTimeout is the error of a dial not connected within
+// Option.ConnectTimeout, unlike context.DeadlineExceeded of the deadline of
+// the context of DialContext or a call.
+var ErrConnectTimeout = errors.New("rpc client: connect timeout")
+
+// ErrCancelled is the error of a call cancelled by Call.Cancel.
+var ErrCancelled = errors.New("rpc client: call cancelled")
+
+// ServerError represents an error that has been returned from
+// the remote side of the RPC connection.
+type ServerError string
+
+func (e ServerError) Error() string {
+	return string(e)
+}
+
+// IsServerError reports whether err is returned by the server,
+// i.e. it's a ServerError, an *RPCError or of a type registered by RegisterError.
+func IsServerError(err error) bool {
+	switch err.(type) {
+	case ServerError, *RPCError:
+		return true
+	}
+	return err != nil && isRegisteredError(err)
+}
+
+// Close the connection. A request being written is failed first, so that
+// a write blocked on a server which stopped reading doesn't block Close.
+// The pending calls fail, even if the client is retired by
+// Option.MaxConnLifetime, which closes it by itself once they are done.
 func (client *Client) Close() error {
 	client.mu.Lock()
-	defer client.mu.Unlock()
 	if client.closing {
+		client.mu.Unlock()
 		return ErrShutdown
 	}
 	client.closing = true
+	if client.lifetime != nil {
+		client.lifetime.Stop()
+	}
+	client.mu.Unlock()
+	if client.conn != nil {
+		_ = client.conn.SetWriteDeadline(time.Now())
+	}
+	// the codec flushes on Close, it mustn't race a write
+	client.sending.Lock()
+	defer client.sending.Unlock()
 	return client.cc.Close()
 }
 
@@ -69,16 +123,51 @@ func (client *Client) Close() error {
 func (client *Client) IsAvailable() bool {
 	client.mu.Lock()
 	defer client.mu.Unlock()
-	return !client.shutdown && !client.closing
+	return !client.shutdown && !client.closing && !client.retired
+}
+
+// CallInfo describes a call in flight, see Client.InFlight.
+type CallInfo struct {
+	Seq           uint64
+	ServiceMethod string
+	Elapsed       time.Duration // since the call is sent
+}
+
+// InFlight returns the calls waiting for their responses by Seq, e.g. to
+// find out which calls hang, dumped on a signal like SIGQUIT.
+func (client *Client) InFlight() []CallInfo {
+	client.mu.Lock()
+	calls := make([]CallInfo, 0, len(client.pending))
+	now := time.Now()
+	for _, call := range client.pending {
+		calls = append(calls, CallInfo{Seq: call.Seq, ServiceMethod: call.ServiceMethod, Elapsed: now.Sub(call.start)})
+	}
+	client.mu.Unlock()
+	sort.Slice(calls, func(i, j int) bool { return calls[i].Seq < calls[j].Seq })
+	return calls
+}
+
+// Retire stops taking new calls, the connection is closed once no call is
+// pending, unlike Close which fails them. It's how Option.MaxConnLifetime
+// retires a client, and how a client which is unavailable is given up.
+func (client *Client) Retire() {
+	client.mu.Lock()
+	client.retired = true
+	idle := len(client.pending) == 0
+	client.mu.Unlock()
+	if idle {
+		_ = client.Close()
+	}
 }
 
 func (client *Client) registerCall(call *Call) (uint64, error) {
 	client.mu.Lock()
 	defer client.mu.Unlock()
-	if client.closing || client.shutdown {
+	if client.closing || client.shutdown || client.retired {
 		return 0, ErrShutdown
 	}
 	call.Seq = client.seq
+	call.start = time.Now()
 	client.pending[call.Seq] = call
 	client.seq++
 	return call.Seq, nil
@@ -89,6 +178,10 @@ func (client *Client) removeCall(seq uint64) *Call {
 	defer client.mu.Unlock()
 	call := client.pending[seq]
 	delete(client.pending, seq)
+	if client.retired && !client.closing && len(client.pending) == 0 {
+		// removeCall may be called with sending locked, which Close takes
+		go func() { _ = client.Close() }()
+	}
 	return call
 }
 
@@ -98,7 +191,10 @@ func (client *Client) terminateCalls(err error) {
 	client.mu.Lock()
 	defer client.mu.Unlock()
 	client.shutdown = true
-	for _, call := range client.pending {
+	// the calls are removed, so that none of them is done twice, e.g. by
+	// a Cancel or a context cancelled at the same time
+	for seq, call := range client.pending {
+		delete(client.pending, seq)
 		call.Error = err
 		call.done()
 	}
@@ -110,6 +206,7 @@ func (client *Client) send(call *Call) {
 	defer client.sending.Unlock()
 
 	// register this call.
+	call.client = client
 	seq, err := client.registerCall(call)
 	if err != nil {
 		call.Error = err
@@ -121,6 +218,12 @@ func (client *Client) send(call *Call) {
 	client.header.ServiceMethod = call.ServiceMethod
 	client.header.Seq = seq
 	client.header.Error = ""
+	client.header.Priority = call.priority
+	client.header.Progress = call.progress != nil
+	client.header.Metadata = nil
+	if client.opt.Features.Has(FeatureMetadata) {
+		client.header.Metadata = call.metadata
+	}
 
 	// encode and send the request
 	if err := client.cc.Write(&client.header, call.Args); err != nil {
@@ -128,12 +231,32 @@ func (client *Client) send(call *Call) {
 		// call may be nil, it usually means that Write partially failed,
 		// client has received the response and handled
 		if call != nil {
+			if isUnregistered(err) {
+				err = errors.New("rpc client: " + gobTypeError("args", call.Args, err).Error())
+			}
 			call.Error = err
 			call.done()
 		}
 	}
 }
 
+// cancel asks server to cancel the context of call, so that the method
+// may stop early. It's best effort, server may have handled call already.
+func (client *Client) cancel(call *Call) {
+	client.sending.Lock()
+	defer client.sending.Unlock()
+	client.mu.Lock()
+	closed := client.closing || client.shutdown
+	client.mu.Unlock()
+	if closed {
+		return // the codec is closed or being closed, there's no server to tell
+	}
+	h := &codec.Header{ServiceMethod: call.ServiceMethod, Seq: call.Seq, Cancel: true}
+	if err := client.cc.Write(h, struct{}{}); err != nil {
+		log.Println("rpc client: cancel error:", err)
+	}
+}
+
 func (client *Client) receive() {
 	var err error
 	for err == nil {
@@ -141,19 +264,46 @@ func (client *Client) receive() {
 		if err = client.cc.ReadHeader(&h); err != nil {
 			break
 		}
+		if h.Progress {
+			err = client.receiveProgress(&h)
+			continue
+		}
+		if h.More {
+			err = client.receiveFrame(&h)
+			continue
+		}
 		call := client.removeCall(h.Seq)
+		if call != nil {
+			call.header = &h
+		}
 		switch {
 		case call == nil:
 			// it usually means that Write partially failed
 			// and call was already removed.
 			err = client.cc.ReadBody(nil)
+		case h.Error != "" && h.ErrorBody:
+			var body errorBody
+			if err = client.cc.ReadBody(&body); err != nil && isUnregistered(err) {
+				// the error type isn't registered here, it's read as a whole though
+				log.Println("rpc client: can't decode error value, see RegisterError:", err)
+				err = nil
+			}
+			call.Error = body.Err
+			if call.Error == nil {
+				call.Error = headerError(&h)
+			}
+			call.done()
 		case h.Error != "":
-			call.Error = fmt.Errorf(h.Error)
+			call.Error = headerError(&h)
 			err = client.cc.ReadBody(nil)
 			call.done()
 		default:
-			err = client.cc.ReadBody(call.Reply)
-			if err != nil {
+			err = client.readReply(call)
+			if errors.Is(err, codec.ErrBodyTooLarge) {
+				call.Error = fmt.Errorf("rpc client: reply too large, more than %d bytes: %w", client.opt.MaxReplySize, err)
+			} else if err != nil && isUnregistered(err) {
+				call.Error = errors.New("reading body " + err.Error() + ", see RegisterGobType")
+			} else if err != nil {
 				call.Error = errors.New("reading body " + err.Error())
 			}
 			call.done()
@@ -163,8 +313,61 @@ func (client *Client) receive() {
 	client.terminateCalls(err)
 }
 
+// ReplyTypes are factories of the values replies are decoded into by
+// "Service.Method", see Option.ReplyTypes.
+type ReplyTypes map[string]func() interface{}
+
+// GobEncode encodes nothing, ReplyTypes is local to client and gob can't
+// encode functions, so that an Option is still sent by GobHandshake.
+func (ReplyTypes) GobEncode() ([]byte, error) { return nil, nil }
+
+// GobDecode decodes nothing, see GobEncode.
+func (*ReplyTypes) GobDecode([]byte) error { return nil }
+
+// readReply reads the body of call into its reply, or into a value made by
+// Option.ReplyTypes which the reply is set to. An error of the value made
+// fails the call only, the body is discarded.
+func (client *Client) readReply(call *Call) error {
+	factory := client.opt.ReplyTypes[call.ServiceMethod]
+	if _, isGob := client.cc.(*codec.GobCodec); factory == nil || isGob || call.Reply == nil {
+		return client.cc.ReadBody(call.Reply)
+	}
+	reply := reflect.ValueOf(call.Reply)
+	if reply.Kind() != reflect.Ptr || reply.Elem().Kind() != reflect.Interface {
+		return client.cc.ReadBody(call.Reply)
+	}
+	v := factory()
+	value := reflect.ValueOf(v)
+	if value.Kind() != reflect.Ptr || value.IsNil() || !value.Type().AssignableTo(reply.Elem().Type()) {
+		call.Error = fmt.Errorf("rpc client: reply type of %s is %T, expect a pointer assignable to %s", call.ServiceMethod, v, reply.Elem().Type())
+		return client.cc.ReadBody(nil)
+	}
+	if err := client.cc.ReadBody(v); err != nil {
+		return err
+	}
+	reply.Elem().Set(value)
+	return nil
+}
+
+// receiveFrame delivers a frame of a stream, the call stays pending
+// until the final response
+func (client *Client) receiveFrame(h *codec.Header) error {
+	client.mu.Lock()
+	call := client.pending[h.Seq]
+	client.mu.Unlock()
+	if call == nil || call.stream == nil {
+		return client.cc.ReadBody(nil)
+	}
+	return call.stream.recv(client.cc)
+}
+
 // Go invokes the function asynchronously.
 // It returns the Call structure representing the invocation.
+// done strobes the Call when it's complete, a channel is allocated if done is nil.
+// Otherwise done must be buffered, and it may be shared by many calls,
+// e.g. to fan out calls and collect them in one place. Then it must be
+// drained while calls are in flight unless its capacity covers them all,
+// or the client stops receiving replies until there is room.
 func (client *Client) Go(serviceMethod string, args, reply interface{}, done chan *Call) *Call {
 	if done == nil {
 		done = make(chan *Call, 10)
@@ -181,33 +384,114 @@ func (client *Client) Go(serviceMethod string, args, reply interface{}, done cha
 	return call
 }
 
+// Cancel cancels the call if it's still in flight: the call is done with
+// ErrCancelled and server is asked to cancel the context of the method,
+// like a cancelled context does for Call. It's a no-op if the call is
+// done already, so it's safe to call it more than once.
+func (call *Call) Cancel() {
+	client := call.client
+	if client == nil || client.removeCall(call.Seq) == nil {
+		return
+	}
+	client.cancel(call)
+	call.Error = ErrCancelled
+	call.done()
+}
+
 // Call invokes the named function, waits for it to complete,
 // and returns its error status.
+// A nil reply discards the reply, e.g. if only the success matters: the
+// body is still read off the connection, but it's not decoded into a value.
 func (client *Client) Call(ctx context.Context, serviceMethod string, args, reply interface{}) error {
-	call := client.Go(serviceMethod, args, reply, make(chan *Call, 1))
+	_, err := client.CallWithHeader(ctx, serviceMethod, args, reply)
+	return err
+}
+
+// CallWithHeader is like Call, but it also returns the response header,
+// e.g. to read the metadata set by server.
+// The header is nil if no response is received.
+func (client *Client) CallWithHeader(ctx context.Context, serviceMethod string, args, reply interface{}) (*codec.Header, error) {
+	call := &Call{
+		ServiceMethod: serviceMethod,
+		Args:          args,
+		Reply:         reply,
+		Done:          make(chan *Call, 1),
+		metadata:      client.contextMetadata(ctx),
+		priority:      priorityOf(ctx),
+		progress:      progressOf(ctx),
+	}
+	client.send(call)
 	select {
 	case <-ctx.Done():
-		client.removeCall(call.Seq)
-		return errors.New("rpc client: call failed: " + ctx.Err().Error())
+		if client.removeCall(call.Seq) != nil {
+			client.cancel(call)
+		}
+		return nil, fmt.Errorf("rpc client: call failed: %w", ctx.Err())
 	case call := <-call.Done:
-		return call.Error
+		return call.header, call.Error
 	}
 }
 
-func parseOptions(opts ...*Option) (*Option, error) {
+// contextMetadata returns the request metadata copied from ctx by Option.ContextMetadata
+func (client *Client) contextMetadata(ctx context.Context) map[string]string {
+	var md map[string]string
+	for key, name := range client.opt.ContextMetadata {
+		if value, ok := ctx.Value(key).(string); ok {
+			if md == nil {
+				md = make(map[string]string)
+			}
+			md[name] = value
+		}
+	}
+	return md
+}
+
+// ParseOptions merges the options passed to Dial and its variants.
+// It returns DefaultOption if opts is empty or nil, otherwise a copy of
+// the only option, with unset MagicNumber, CodecType and Features taken
+// from DefaultOption. The timeouts are left as they are since 0 means no limit.
+// It returns an error for more than one option, an unsupported codec type
+// or a negative timeout.
+func ParseOptions(opts ...*Option) (*Option, error) {
 	// if opts is nil or pass nil as parameter
 	if len(opts) == 0 || opts[0] == nil {
 		return DefaultOption, nil
 	}
 	if len(opts) != 1 {
-		return nil, errors.New("number of options is more than 1")
+		return nil, errors.New("rpc client: number of options is more than 1")
 	}
-	opt := opts[0]
+	opt := *opts[0]
 	opt.MagicNumber = DefaultOption.MagicNumber
 	if opt.CodecType == "" {
 		opt.CodecType = DefaultOption.CodecType
 	}
-	return opt, nil
+	if opt.Features == 0 {
+		opt.Features = AllFeatures
+	}
+	if codec.NewCodecFuncMap[opt.CodecType] == nil {
+		return nil, fmt.Errorf("rpc client: invalid codec type %s", opt.CodecType)
+	}
+	switch opt.Handshake {
+	case "", JSONHandshake, GobHandshake:
+	default:
+		return nil, fmt.Errorf("rpc client: invalid handshake type %s", opt.Handshake)
+	}
+	if opt.ConnectTimeout < 0 || opt.HandleTimeout < 0 || opt.IdleTimeout < 0 || opt.MaxConnLifetime < 0 {
+		return nil, errors.New("rpc client: timeouts must not be negative")
+	}
+	if opt.DialRetries < 0 || opt.DialBackoff < 0 {
+		return nil, errors.New("rpc client: DialRetries and DialBackoff must not be negative")
+	}
+	if len(opt.CompressionDict) > maxCompressionDict {
+		return nil, fmt.Errorf("rpc client: CompressionDict is larger than %d bytes", maxCompressionDict)
+	}
+	if opt.MaxReplySize < 0 {
+		return nil, errors.New("rpc client: MaxReplySize must not be negative")
+	}
+	if opt.MaxRequestsPerConn < 0 {
+		return nil, errors.New("rpc client: MaxRequestsPerConn must not be negative")
+	}
+	return &opt, nil
 }
 
 func NewClient(conn net.Conn, opt *Option) (*Client, error) {
@@ -217,22 +501,89 @@ func NewClient(conn net.Conn, opt *Option) (*Client, error) {
 		log.Println("rpc client: codec error:", err)
 		return nil, err
 	}
+	if opt.BodyTransform != "" && bodyTransform(opt.BodyTransform) == nil {
+		err := fmt.Errorf("unknown body transform %s, see RegisterBodyTransform", opt.BodyTransform)
+		log.Println("rpc client: options error:", err)
+		_ = conn.Close()
+		return nil, err
+	}
 	// send options with server
-	if err := json.NewEncoder(conn).Encode(opt); err != nil {
+	if err := writeOption(conn, opt.Handshake, opt); err != nil {
 		log.Println("rpc client: options error: ", err)
 		_ = conn.Close()
 		return nil, err
 	}
-	return newClientCodec(f(conn), opt), nil
+	// server acknowledges with the codec chosen, it may fall back to another one
+	ack, err := readAck(conn, opt.Handshake, opt.AckTimeout)
+	if err != nil {
+		log.Println("rpc client: options ack error: ", err)
+		_ = conn.Close()
+		return nil, err
+	}
+	if ack == nil {
+		log.Println("rpc client: server doesn't acknowledge the options, fall back to the basic protocol")
+		ack = legacyAck(opt)
+		conn = &lateAckConn{Conn: conn}
+	}
+	if ack.BodyTransform != opt.BodyTransform {
+		err = fmt.Errorf("server doesn't support body transform %s", opt.BodyTransform)
+		log.Println("rpc client: options error:", err)
+		_ = conn.Close()
+		return nil, err
+	}
+	if ack.Features != opt.Features {
+		negotiated := *opt
+		negotiated.Features = ack.Features
+		if !ack.Features.Has(FeatureCompressionDict) {
+			negotiated.CompressionDict = nil
+		}
+		opt = &negotiated
+	}
+	if ack.CodecType != opt.CodecType {
+		if f = codec.NewCodecFuncMap[ack.CodecType]; f == nil {
+			err = fmt.Errorf("invalid codec type %s chosen by server", ack.CodecType)
+			log.Println("rpc client: codec error:", err)
+			_ = conn.Close()
+			return nil, err
+		}
+		log.Printf("rpc client: server doesn't support codec %s, fall back to %s", opt.CodecType, ack.CodecType)
+		negotiated := *opt
+		negotiated.CodecType = ack.CodecType
+		opt = &negotiated
+	}
+	client := newClientCodec(f(conn), opt)
+	client.conn = conn
+	return client, nil
 }
 
 func newClientCodec(cc codec.Codec, opt *Option) *Client {
+	if c, ok := cc.(codec.Compressor); ok {
+		c.SetCompress(opt.CompressRequest && opt.Features.Has(FeatureCompression))
+	}
+	if c, ok := cc.(codec.DictionaryCompressor); ok && opt.Features.Has(FeatureCompression) {
+		c.SetDictionary(opt.CompressionDict)
+	}
+	if c, ok := cc.(codec.Checksummer); ok {
+		c.SetChecksum(opt.ChecksumEnabled)
+	}
+	if c, ok := cc.(codec.BodyLimiter); ok {
+		c.SetMaxBodySize(opt.MaxReplySize)
+	}
+	if c, ok := cc.(codec.Transformer); ok {
+		c.SetTransform(bodyTransform(opt.BodyTransform))
+	}
 	client := &Client{
 		seq:     1, // seq starts with 1, 0 means invalid call
 		cc:      cc,
 		opt:     opt,
 		pending: make(map[uint64]*Call),
 	}
+	if opt.MaxConnLifetime > 0 {
+		// Retire takes mu, it doesn't run before lifetime is set
+		client.mu.Lock()
+		client.lifetime = time.AfterFunc(opt.MaxConnLifetime, client.Retire)
+		client.mu.Unlock()
+	}
 	go client.receive()
 	return client
 }
@@ -245,13 +596,28 @@ type clientResult struct {
 type newClientFunc func(conn net.Conn, opt *Option) (client *Client, err error)
 
 func dialTimeout(f newClientFunc, network, address string, opts ...*Option) (client *Client, err error) {
-	opt, err := parseOptions(opts...)
+	return dialContext(context.Background(), f, network, address, opts...)
+}
+
+// dialContext connects by f within the sooner of opt.ConnectTimeout and the
+// deadline of ctx, the error tells which one is exceeded.
+func dialContext(ctx context.Context, f newClientFunc, network, address string, opts ...*Option) (client *Client, err error) {
+	opt, err := ParseOptions(opts...)
 	if err != nil {
 		return nil, err
 	}
-	conn, err := net.DialTimeout(network, address, opt.ConnectTimeout)
+	connectCtx := ctx
+	if opt.ConnectTimeout > 0 {
+		var cancel context.CancelFunc
+		connectCtx, cancel = context.WithTimeout(ctx, opt.ConnectTimeout)
+		defer cancel()
+	}
+	conn, err := dialRetry(connectCtx, network, address, opt)
 	if err != nil {
-		return nil, err
+		return nil, connectError(ctx, connectCtx, opt, err)
+	}
+	if opt.Nagle {
+		setNoDelay(conn, false)
 	}
 	// close the connection if client is nil
 	defer func() {
@@ -264,23 +630,67 @@ func dialTimeout(f newClientFunc, network, address string, opts ...*Option) (cli
 		client, err := f(conn, opt)
 		ch <- clientResult{client: client, err: err}
 	}()
-	if opt.ConnectTimeout == 0 {
-		result := <-ch
-		return result.client, result.err
-	}
 	select {
-	case <-time.After(opt.ConnectTimeout):
-		return nil, fmt.Errorf("rpc client: connect timeout: expect within %s", opt.ConnectTimeout)
+	case <-connectCtx.Done():
+		return nil, connectError(ctx, connectCtx, opt, connectCtx.Err())
 	case result := <-ch:
 		return result.client, result.err
 	}
 }
 
+// connectError attributes err to ctx if it's done, to ConnectTimeout if
+// only connectCtx is done.
+func connectError(ctx, connectCtx context.Context, opt *Option, err error) error {
+	if ctx.Err() != nil {
+		return fmt.Errorf("rpc client: dial failed: %w", ctx.Err())
+	}
+	if connectCtx.Err() != nil {
+		return fmt.Errorf("%w: expect within %s", ErrConnectTimeout, opt.ConnectTimeout)
+	}
+	return err
+}
+
+const defaultDialBackoff = 100 * time.Millisecond
+
+// dialRetry dials address, retrying opt.DialRetries times waiting the delays
+// of opt.Backoff, by default a JitteredBackoff from opt.DialBackoff, so that
+// clients failed together don't retry together.
+func dialRetry(ctx context.Context, network, address string, opt *Option) (net.Conn, error) {
+	backoff := opt.Backoff
+	if backoff == nil {
+		base := opt.DialBackoff
+		if base == 0 {
+			base = defaultDialBackoff
+		}
+		backoff = JitteredBackoff{Base: base}
+	}
+	for attempt := 1; ; attempt++ {
+		conn, err := new(net.Dialer).DialContext(ctx, network, address)
+		if err == nil || opt.DialRetries == 0 {
+			return conn, err
+		}
+		if attempt > opt.DialRetries {
+			return nil, fmt.Errorf("rpc client: dial failed after %d attempts: %v", attempt, err)
+		}
+		select {
+		case <-time.After(backoff.NextDelay(attempt)):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
 // Dial connects to an RPC server at the specified network address
 func Dial(network, address string, opts ...*Option) (*Client, error) {
 	return dialTimeout(NewClient, network, address, opts...)
 }
 
+// DialContext is like Dial, but connecting is limited by the deadline of ctx
+// too, whichever is sooner of it and Option.ConnectTimeout.
+func DialContext(ctx context.Context, network, address string, opts ...*Option) (*Client, error) {
+	return dialContext(ctx, NewClient, network, address, opts...)
+}
+
 // NewHTTPClient new a Client instance via HTTP as transport protocol
 func NewHTTPClient(conn net.Conn, opt *Option) (*Client, error) {
 	_, _ = io.WriteString(conn, fmt.Sprintf("CONNECT %s HTTP/1.0\n\n", defaultRPCPath))
@@ -308,6 +718,11 @@ func DialHTTP(network, address string, opts ...*Option) (*Client, error) {
 // rpcAddr is a general format (protocol@addr) to represent a rpc server
 // eg, http@10.0.0.1:7001, tcp@10.0.0.1:9999, unix@/tmp/geerpc.sock
 func XDial(rpcAddr string, opts ...*Option) (*Client, error) {
+	return XDialContext(context.Background(), rpcAddr, opts...)
+}
+
+// XDialContext is like XDial, but connecting is limited by ctx, see DialContext
+func XDialContext(ctx context.Context, rpcAddr string, opts ...*Option) (*Client, error) {
 	parts := strings.Split(rpcAddr, "@")
 	if len(parts) != 2 {
 		return nil, fmt.Errorf("rpc client err: wrong format '%s', expect protocol@addr", rpcAddr)
@@ -315,9 +730,9 @@ func XDial(rpcAddr string, opts ...*Option) (*Client, error) {
 	protocol, addr := parts[0], parts[1]
 	switch protocol {
 	case "http":
-		return DialHTTP("tcp", addr, opts...)
+		return dialContext(ctx, NewHTTPClient, "tcp", addr, opts...)
 	default:
 		// tcp, unix or other transport protocol
-		return Dial(protocol, addr, opts...)
+		return DialContext(ctx, protocol, addr, opts...)
 	}
 }
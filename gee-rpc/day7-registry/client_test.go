@@ -2,10 +2,15 @@ package geerpc
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"geerpc/codec"
 	"net"
 	"os"
 	"runtime"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -45,6 +50,105 @@ func TestClient_dialTimeout(t *testing.T) {
 	})
 }
 
+func TestClient_dialContext(t *testing.T) {
+	t.Parallel()
+	l, _ := net.Listen("tcp", ":0")
+
+	// the handshake is never answered
+	f := func(conn net.Conn, opt *Option) (client *Client, err error) {
+		time.Sleep(time.Second * 2)
+		return nil, errors.New("unreachable")
+	}
+	t.Run("connect timeout first", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_, err := dialContext(ctx, f, "tcp", l.Addr().String(), &Option{ConnectTimeout: time.Millisecond * 100})
+		_assert(errors.Is(err, ErrConnectTimeout) && !errors.Is(err, context.DeadlineExceeded), "expect ErrConnectTimeout, got %v", err)
+	})
+	t.Run("deadline first", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+		defer cancel()
+		start := time.Now()
+		_, err := dialContext(ctx, f, "tcp", l.Addr().String(), &Option{ConnectTimeout: time.Second})
+		_assert(errors.Is(err, context.DeadlineExceeded) && !errors.Is(err, ErrConnectTimeout), "expect context.DeadlineExceeded, got %v", err)
+		_assert(time.Since(start) < time.Millisecond*500, "expect the sooner deadline enforced, took %s", time.Since(start))
+	})
+}
+
+func TestClient_CallDeadline(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	var b Bar
+	_ = server.Register(&b)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*200)
+	defer cancel()
+	client, err := XDialContext(ctx, "tcp@"+startTestServer(server), &Option{ConnectTimeout: time.Second * 10})
+	_assert(err == nil, "failed to dial: %v", err)
+	defer func() { _ = client.Close() }()
+
+	// the call deadline is much shorter than the connect timeout
+	start := time.Now()
+	var reply int
+	err = client.Call(ctx, "Bar.Timeout", 1, &reply)
+	_assert(errors.Is(err, context.DeadlineExceeded) && !errors.Is(err, ErrConnectTimeout), "expect context.DeadlineExceeded, got %v", err)
+	_assert(time.Since(start) < time.Second, "expect the call deadline enforced, took %s", time.Since(start))
+}
+
+func TestParseOptions(t *testing.T) {
+	t.Run("nil", func(t *testing.T) {
+		opt, err := ParseOptions()
+		_assert(err == nil && opt == DefaultOption, "expect DefaultOption without options: %v", err)
+		opt, err = ParseOptions(nil)
+		_assert(err == nil && opt == DefaultOption, "expect DefaultOption for a nil option: %v", err)
+	})
+	t.Run("single", func(t *testing.T) {
+		given := &Option{HandleTimeout: time.Second}
+		opt, err := ParseOptions(given)
+		_assert(err == nil, "failed to parse a single option: %v", err)
+		_assert(opt.MagicNumber == MagicNumber && opt.CodecType == DefaultOption.CodecType, "expect defaults for unset fields, got %+v", opt)
+		_assert(opt.HandleTimeout == time.Second && opt.ConnectTimeout == 0, "expect the fields set kept, got %+v", opt)
+		_assert(given.MagicNumber == 0, "expect the given option not modified")
+
+		_, err = ParseOptions(&Option{CodecType: "application/unknown"})
+		_assert(err != nil && strings.Contains(err.Error(), "invalid codec type"), "expect an invalid codec type error, got %v", err)
+		_, err = ParseOptions(&Option{ConnectTimeout: -time.Second})
+		_assert(err != nil && strings.Contains(err.Error(), "negative"), "expect a negative timeout error, got %v", err)
+		_, err = ParseOptions(&Option{DialRetries: -1})
+		_assert(err != nil && strings.Contains(err.Error(), "negative"), "expect a negative DialRetries error, got %v", err)
+	})
+	t.Run("multiple", func(t *testing.T) {
+		_, err := ParseOptions(&Option{}, &Option{})
+		_assert(err != nil && strings.Contains(err.Error(), "more than 1"), "expect an error for more than 1 option, got %v", err)
+	})
+}
+
+func TestClient_dialRetry(t *testing.T) {
+	t.Parallel()
+	// reserve a port, the server listens on it after a while
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	addr := l.Addr().String()
+	_ = l.Close()
+
+	t.Run("eventually connects", func(t *testing.T) {
+		go func() {
+			time.Sleep(200 * time.Millisecond)
+			l, err := net.Listen("tcp", addr)
+			_assert(err == nil, "failed to listen again: %v", err)
+			NewServer().Accept(l)
+		}()
+		client, err := Dial("tcp", addr, &Option{DialRetries: 10, DialBackoff: 20 * time.Millisecond})
+		_assert(err == nil, "expect dial to connect after retries, got %v", err)
+		_ = client.Close()
+	})
+	t.Run("attempt count", func(t *testing.T) {
+		l, _ := net.Listen("tcp", "127.0.0.1:0")
+		addr := l.Addr().String()
+		_ = l.Close()
+		_, err := Dial("tcp", addr, &Option{DialRetries: 2, DialBackoff: time.Millisecond})
+		_assert(err != nil && strings.Contains(err.Error(), "after 3 attempts"), "expect the attempt count in error, got %v", err)
+	})
+}
+
 func TestClient_Call(t *testing.T) {
 	t.Parallel()
 	addrCh := make(chan string)
@@ -86,3 +190,296 @@ func TestXDial(t *testing.T) {
 		_assert(err == nil, "failed to connect unix socket")
 	}
 }
+
+func TestClient_CallWithHeader(t *testing.T) {
+	l, _ := net.Listen("tcp", ":0")
+	// a server setting metadata in the response header
+	go func() {
+		conn, _ := l.Accept()
+		opt, _ := readOption(conn)
+		_ = json.NewEncoder(conn).Encode(opt)
+		cc := codec.NewGobCodec(conn)
+		var h codec.Header
+		var args int
+		_ = cc.ReadHeader(&h)
+		_ = cc.ReadBody(&args)
+		h.Metadata = map[string]string{"trace-id": "42"}
+		_ = cc.Write(&h, args*2)
+	}()
+	client, _ := Dial("tcp", l.Addr().String())
+	defer func() { _ = client.Close() }()
+
+	var reply int
+	h, err := client.CallWithHeader(context.Background(), "Foo.Double", 2, &reply)
+	_assert(err == nil && reply == 4, "failed to call Foo.Double: %v", err)
+	_assert(h != nil && h.Metadata["trace-id"] == "42", "expect metadata in the response header")
+}
+
+func TestClient_GoSharedDone(t *testing.T) {
+	server := NewServer()
+	var foo Foo
+	_ = server.Register(&foo)
+	client, _ := Dial("tcp", startTestServer(server))
+	defer func() { _ = client.Close() }()
+
+	// many more calls than the capacity of the channel
+	const n = 200
+	done := make(chan *Call, 16)
+	go func() {
+		for i := 0; i < n; i++ {
+			client.Go("Foo.Sum", &Args{Num1: i, Num2: i}, new(int), done)
+		}
+	}()
+	sum := 0
+	for i := 0; i < n; i++ {
+		select {
+		case call := <-done:
+			_assert(call.Error == nil, "failed to call Foo.Sum: %v", call.Error)
+			sum += *call.Reply.(*int)
+		case <-time.After(time.Second * 5):
+			t.Fatalf("only %d of %d calls are done", i, n)
+		}
+	}
+	_assert(sum == n*(n-1), "expect sum %d, got %d", n*(n-1), sum)
+}
+
+func TestClient_CloseStuckWrite(t *testing.T) {
+	l, _ := net.Listen("tcp", ":0")
+	defer func() { _ = l.Close() }()
+	// a server acknowledging the option, then never reading
+	go func() {
+		conn, _ := l.Accept()
+		opt, _ := readOption(conn)
+		_ = json.NewEncoder(conn).Encode(opt)
+	}()
+	client, err := Dial("tcp", l.Addr().String())
+	_assert(err == nil, "failed to dial: %v", err)
+	call := client.Go("Foo.Sum", make([]byte, 64<<20), new(int), nil)
+	time.Sleep(100 * time.Millisecond)
+
+	closed := make(chan struct{})
+	go func() {
+		_ = client.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("expect Close not blocked by a stuck write")
+	}
+	<-call.Done
+	_assert(call.Error != nil, "expect the stuck call failed")
+}
+
+func TestClient_NilReply(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	var foo Foo
+	var b Blob
+	_ = server.Register(&foo)
+	_ = server.Register(&b)
+	addr := startTestServer(server)
+	for _, opt := range []*Option{
+		{CodecType: codec.GobType},
+		{CodecType: codec.GobFramedType},
+		{CodecType: codec.GobBinaryHeaderType},
+		{CodecType: codec.GobType, CompressResponse: true},
+		{CodecType: codec.JsonType},
+	} {
+		client, err := Dial("tcp", addr, opt)
+		_assert(err == nil, "failed to dial over %s: %v", opt.CodecType, err)
+		for i := 0; i < 3; i++ {
+			// large bodies are discarded as a whole, so the next response is aligned
+			err = client.Call(context.Background(), "Blob.Get", 64<<10, nil)
+			_assert(err == nil, "expect a nil reply discarded over %s, got %v", opt.CodecType, err)
+			var sum int
+			err = client.Call(context.Background(), "Foo.Sum", Args{Num1: i, Num2: 1}, &sum)
+			_assert(err == nil && sum == i+1, "expect the connection usable after a nil reply over %s, got %v %d", opt.CodecType, err, sum)
+		}
+		call := <-client.Go("Foo.Sum", Args{Num1: 1, Num2: 2}, nil, nil).Done
+		_assert(call.Error == nil, "expect Go with a nil reply to succeed over %s, got %v", opt.CodecType, call.Error)
+		_ = client.Close()
+	}
+}
+
+// shortConn writes half of the bytes asked without an error once short is set
+type shortConn struct {
+	net.Conn
+	short int32
+}
+
+func (c *shortConn) Write(p []byte) (int, error) {
+	if atomic.LoadInt32(&c.short) != 0 {
+		return c.Conn.Write(p[:len(p)/2])
+	}
+	return c.Conn.Write(p)
+}
+
+func TestClient_ShortWrite(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	var foo Foo
+	_ = server.Register(&foo)
+	conn, _ := net.Dial("tcp", startTestServer(server))
+	sc := &shortConn{Conn: conn}
+	client, err := NewClient(sc, DefaultOption)
+	_assert(err == nil, "failed to create client: %v", err)
+	defer func() { _ = client.Close() }()
+	var sum int
+	err = client.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &sum)
+	_assert(err == nil && sum == 3, "failed to call Foo.Sum: %v", err)
+
+	atomic.StoreInt32(&sc.short, 1)
+	err = client.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &sum)
+	_assert(err != nil && strings.Contains(err.Error(), "short write") && strings.Contains(err.Error(), "connection closed"),
+		"expect the call failed by the short write, got %v", err)
+	deadline := time.Now().Add(time.Second)
+	for client.IsAvailable() && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond * 10)
+	}
+	_assert(!client.IsAvailable(), "expect the client torn down after a short write")
+}
+
+func TestClient_InFlight(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	release := make(chan struct{})
+	_ = server.RegisterFunc("Stall.Wait", func(args int, reply *int) error {
+		<-release
+		return nil
+	})
+	client, _ := Dial("tcp", startTestServer(server))
+	defer func() { _ = client.Close() }()
+	_assert(len(client.InFlight()) == 0, "expect no call in flight")
+
+	call := client.Go("Stall.Wait", 0, new(int), nil)
+	time.Sleep(time.Millisecond * 50)
+	calls := client.InFlight()
+	_assert(len(calls) == 1 && calls[0].Seq == call.Seq && calls[0].ServiceMethod == "Stall.Wait",
+		"expect Stall.Wait in flight, got %v", calls)
+	time.Sleep(time.Millisecond * 50)
+	later := client.InFlight()
+	_assert(len(later) == 1 && later[0].Elapsed > calls[0].Elapsed && later[0].Elapsed >= time.Millisecond*100,
+		"expect the elapsed time growing, got %v then %v", calls[0].Elapsed, later)
+
+	close(release)
+	<-call.Done
+	_assert(call.Error == nil && len(client.InFlight()) == 0, "expect no call in flight once done: %v", call.Error)
+}
+
+func TestClient_CloseCallRace(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	var foo Foo
+	_ = server.Register(&foo)
+	addr := startTestServer(server)
+	for round := 0; round < 20; round++ {
+		client, err := Dial("tcp", addr)
+		_assert(err == nil, "failed to dial: %v", err)
+		var wg sync.WaitGroup
+		for i := 0; i < 16; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				for {
+					// some calls are cancelled while Close tears the connection down
+					ctx, cancel := context.WithTimeout(context.Background(), time.Duration(i%4)*time.Millisecond+time.Microsecond)
+					var sum int
+					err := client.Call(ctx, "Foo.Sum", Args{Num1: i, Num2: 1}, &sum)
+					cancel()
+					if err == ErrShutdown {
+						return
+					}
+					_assert(err != nil || sum == i+1, "expect %d, got %d", i+1, sum)
+				}
+			}(i)
+		}
+		time.Sleep(time.Millisecond * time.Duration(round%5))
+		_ = client.Close()
+		err = client.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 1}, new(int))
+		_assert(err == ErrShutdown, "expect ErrShutdown of a call after Close, got %v", err)
+		wg.Wait()
+	}
+}
+
+func TestClient_MaxReplySize(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	var b Blob
+	_ = server.Register(&b)
+	addr := startTestServer(server)
+	for _, codecType := range []codec.Type{codec.GobType, codec.GobFramedType, codec.JsonType} {
+		client, _ := Dial("tcp", addr, &Option{CodecType: codecType, MaxReplySize: 1 << 16})
+		var blob []byte
+		err := client.Call(context.Background(), "Blob.Get", 1<<10, &blob)
+		_assert(err == nil && len(blob) == 1<<10, "failed to call Blob.Get within the limit over %s: %v", codecType, err)
+		err = client.Call(context.Background(), "Blob.Get", 1<<20, &blob)
+		_assert(errors.Is(err, codec.ErrBodyTooLarge) && strings.Contains(err.Error(), "reply too large"),
+			"expect the oversized reply rejected over %s, got %v", codecType, err)
+		err = client.Call(context.Background(), "Blob.Get", 1, &blob)
+		_assert(err != nil, "expect the connection closed after an oversized reply over %s", codecType)
+		_ = client.Close()
+	}
+}
+
+type Circle struct{ R float64 }
+
+func (c *Circle) Area() float64 { return 3 * c.R * c.R }
+
+type Shapes int
+
+func (Shapes) Circle(r float64, reply *Shape) error { *reply = &Circle{R: r}; return nil }
+func (Shapes) Square(s float64, reply *Shape) error { *reply = Square{Side: s}; return nil }
+
+func TestOption_ReplyTypes(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	var s Shapes
+	_ = server.Register(&s)
+	client, _ := Dial("tcp", startTestServer(server), &Option{
+		CodecType: codec.JsonType,
+		ReplyTypes: ReplyTypes{
+			"Shapes.Circle": func() interface{} { return &Circle{} },
+			"Shapes.Square": func() interface{} { return &Square{} },
+		},
+	})
+	defer func() { _ = client.Close() }()
+
+	var shape Shape
+	err := client.Call(context.Background(), "Shapes.Circle", 2.0, &shape)
+	circle, ok := shape.(*Circle)
+	_assert(err == nil && ok && circle.R == 2 && shape.Area() == 12, "expect a *Circle, got %#v: %v", shape, err)
+	err = client.Call(context.Background(), "Shapes.Square", 3.0, &shape)
+	square, ok := shape.(*Square)
+	_assert(err == nil && ok && square.Side == 3, "expect a *Square, got %#v: %v", shape, err)
+
+	// a reply which isn't an interface is decoded into as it is
+	var sum int
+	err = client.Call(context.Background(), "Shapes.Circle", 2.0, &sum)
+	_assert(err != nil, "expect a reply into a non-interface decoded as it is")
+}
+
+func TestClient_CloseRetired(t *testing.T) {
+	server := NewServer()
+	var s Sleeper
+	_ = server.Register(&s)
+	client, _ := Dial("tcp", startTestServer(server), &Option{MaxConnLifetime: 20 * time.Millisecond})
+	slow := client.Go("Sleeper.Sleep", 500, new(int), nil)
+	time.Sleep(50 * time.Millisecond)
+	_assert(!client.IsAvailable(), "expect the client retired")
+
+	// Close doesn't wait for the pending calls of a retired client
+	_assert(client.Close() == nil, "failed to close the retired client")
+	select {
+	case <-slow.Done:
+		_assert(slow.Error != nil, "expect the pending call failed by Close")
+	case <-time.After(200 * time.Millisecond):
+		t.Fatal("expect the connection closed by Close")
+	}
+	_assert(client.Close() == ErrShutdown, "expect the client closed once")
+
+	// the lifetime of a client closed is stopped
+	client, _ = Dial("tcp", startTestServer(server), &Option{MaxConnLifetime: time.Hour})
+	_ = client.Close()
+	_assert(!client.lifetime.Stop(), "expect the lifetime stopped by Close")
+}
@@ -1,20 +1,141 @@
 package codec
 
 import (
+	"errors"
+	"fmt"
 	"io"
+	"strings"
 )
 
 type Header struct {
 	ServiceMethod string // format "Service.Method"
 	Seq           uint64 // sequence number chosen by client
 	Error         string
+	Location      string // where Error is created, see geerpc.WithStack
+	Code          int    // code of Error, see geerpc.Code
+	Priority      int    // higher is handled first by a busy server, see geerpc.WithPriority
+	Raw           bool   // body is a RawReply, the receiver decodes it on its own
+	Metadata      map[string]string
+	Cancel        bool   // asks server to cancel the call of Seq, the body is empty
+	Compressed    bool   // body is compressed, see Compressor
+	More          bool   // body is a frame of a stream, more responses of Seq follow
+	Checksummed   bool   // Checksum is set, see Checksummer
+	Checksum      uint32 // CRC32 (IEEE) of the encoded body
+	ErrorBody     bool   // body is the value of Error, see geerpc.RegisterError
+	Progress      bool   // request asks for progress, response body is a geerpc.Progress of Seq
+	Transformed   bool   // body is transformed, see Transformer
 }
 
+// RawReply is a body already encoded by the codec type of the connection,
+// e.g. a reply relayed from another service. Write sends it as it is
+// instead of encoding the value again.
+type RawReply []byte
+
 type Codec interface {
 	io.Closer
 	ReadHeader(*Header) error
+	// ReadBody reads the body of the header read, a nil body is discarded
 	ReadBody(interface{}) error
+	// Write writes a message, it returns a *BodyError without writing
+	// anything if the body can't be encoded.
 	Write(*Header, interface{}) error
+	// Flush sends the buffered messages, Write flushes by itself
+	// unless the codec is in batch mode. Codecs that don't buffer
+	// implement it as a no-op.
+	Flush() error
+}
+
+// Batcher is implemented by codecs which are able to write several
+// messages and send them with a single Flush.
+type Batcher interface {
+	// SetBatch switches batch mode on or off, in batch mode
+	// Write doesn't flush, the caller has to call Flush.
+	SetBatch(batch bool)
+}
+
+// Compressor is implemented by codecs which are able to compress the bodies
+// they write. The bodies are flagged in the header, so a codec always
+// decodes compressed bodies whether compression is on or off for writing.
+type Compressor interface {
+	// SetCompress switches compression of the bodies written on or off
+	SetCompress(compress bool)
+}
+
+// DictionaryCompressor is implemented by Compressors which are able to
+// compress by a preset dictionary, e.g. the common parts of structured
+// messages, which improves the compression of small messages a lot. The
+// peers of a connection have to use the same dictionary.
+type DictionaryCompressor interface {
+	// SetDictionary presets dict for compressing the bodies written and for
+	// decompressing the bodies read, nil means no dictionary
+	SetDictionary(dict []byte)
+}
+
+// Checksummer is implemented by codecs which are able to checksum the bodies
+// they write. The checksum is carried by the header, a codec always verifies
+// the bodies checksummed whether checksums are on or off for writing.
+type Checksummer interface {
+	// SetChecksum switches checksums of the bodies written on or off
+	SetChecksum(checksum bool)
+}
+
+// ErrChecksum is returned by ReadBody if the body doesn't match its checksum,
+// i.e. it's corrupted on the way.
+var ErrChecksum = errors.New("rpc codec: body checksum mismatch, the body is corrupted")
+
+// BodyTransform transforms the encoded bodies on the wire, e.g. to encrypt
+// them. Decode must reverse Encode, the peers of a connection have to use
+// the same transform.
+type BodyTransform interface {
+	// Encode transforms a body after it's encoded, e.g. encrypts it
+	Encode(body []byte) ([]byte, error)
+	// Decode reverses Encode before a body is decoded, e.g. decrypts it
+	Decode(body []byte) ([]byte, error)
+}
+
+// Transformer is implemented by codecs which are able to transform the bodies
+// they write by a BodyTransform. The bodies are flagged in the header, a body
+// transformed is rejected by ErrNoTransform if no transform is set.
+type Transformer interface {
+	// SetTransform transforms the bodies written and read by t, nil means none
+	SetTransform(t BodyTransform)
+}
+
+// ErrNoTransform is returned by ReadBody if the body is transformed but the
+// codec has no transform to reverse it.
+var ErrNoTransform = errors.New("rpc codec: body is transformed, but no transform is set")
+
+// BodyLimiter is implemented by codecs which are able to limit the size of
+// the bodies they read, e.g. so that a peer can't exhaust the memory by an
+// enormous body. A framed codec rejects a body too large before it's read,
+// the others stop reading once the limit is passed.
+type BodyLimiter interface {
+	// SetMaxBodySize limits the bodies read to n bytes, 0 means no limit
+	SetMaxBodySize(n int64)
+}
+
+// ErrBodyTooLarge is returned by ReadBody if the body is larger than the limit
+// set by BodyLimiter. The rest of the body isn't read, so the connection can't
+// be used anymore.
+var ErrBodyTooLarge = errors.New("rpc codec: body too large")
+
+// BodyError is returned by Write if the body can't be encoded. The body is
+// encoded before anything of the message is written, so the connection is
+// still usable then, e.g. to write an error response instead.
+type BodyError struct {
+	Err error
+}
+
+func (e *BodyError) Error() string { return "rpc codec: can't encode body: " + e.Err.Error() }
+
+func (e *BodyError) Unwrap() error { return e.Err }
+
+// closeOnWriteError closes c after writing failed, e.g. a short write. A
+// message may be partially sent then, the peer can't tell where the next
+// one starts, so the connection can't be used anymore.
+func closeOnWriteError(c io.Closer, err error) error {
+	_ = c.Close()
+	return fmt.Errorf("rpc codec: write failed, connection closed: %w", err)
 }
 
 type NewCodecFunc func(io.ReadWriteCloser) Codec
@@ -22,8 +143,11 @@ type NewCodecFunc func(io.ReadWriteCloser) Codec
 type Type string
 
 const (
-	GobType  Type = "application/gob"
-	JsonType Type = "application/json" // not implemented
+	GobType       Type = "application/gob"
+	GobFramedType Type = "application/gob+framed" // gob messages prefixed by their length
+	JsonType      Type = "application/json"
+	// GobBinaryHeaderType encodes headers by BinaryHeader and bodies by gob
+	GobBinaryHeaderType Type = "application/gob+binheader"
 )
 
 var NewCodecFuncMap map[Type]NewCodecFunc
@@ -31,4 +155,43 @@ var NewCodecFuncMap map[Type]NewCodecFunc
 func init() {
 	NewCodecFuncMap = make(map[Type]NewCodecFunc)
 	NewCodecFuncMap[GobType] = NewGobCodec
+	NewCodecFuncMap[GobFramedType] = NewFramedGobCodec
+	NewCodecFuncMap[JsonType] = NewJsonCodec
+	NewCodecFuncMap[GobBinaryHeaderType] = func(conn io.ReadWriteCloser) Codec {
+		return NewGobCodecWithHeader(conn, BinaryHeader{})
+	}
+}
+
+// joinedError is the errors returned together by Close, like errors.Join
+// which requires a newer Go than the go.mod of the module.
+type joinedError []error
+
+func (e joinedError) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// Unwrap makes errors.Is and errors.As match any of the errors.
+func (e joinedError) Unwrap() []error {
+	return e
+}
+
+// joinErrors returns nil if all errs are nil, the only error if there's one.
+func joinErrors(errs ...error) error {
+	var joined joinedError
+	for _, err := range errs {
+		if err != nil {
+			joined = append(joined, err)
+		}
+	}
+	switch len(joined) {
+	case 0:
+		return nil
+	case 1:
+		return joined[0]
+	}
+	return joined
 }
@@ -0,0 +1,188 @@
+package codec
+
+import (
+	"encoding/json"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// jsonDuration is a time.Duration encoded as a string like "5s" in JSON,
+// a number of nanoseconds is decoded as well, like encoding/json does.
+type jsonDuration time.Duration
+
+func (d jsonDuration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *jsonDuration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return json.Unmarshal(b, (*int64)(d))
+	}
+	v, err := time.ParseDuration(s)
+	*d = jsonDuration(v)
+	return err
+}
+
+var (
+	durationType     = reflect.TypeOf(time.Duration(0))
+	jsonDurationType = reflect.TypeOf(jsonDuration(0))
+	marshalerType    = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+)
+
+// durationMirrors caches mirrorOf, reflect.Type -> reflect.Type, nil if a
+// type has no time.Duration to encode
+var durationMirrors sync.Map
+
+// mirrorOf returns the type of t with every time.Duration replaced by
+// jsonDuration, so that JsonCodec encodes the durations in bodies as strings.
+// It's nil if t has no time.Duration, or the ones of interfaces, recursive
+// types or types marshaling themselves, which are left as numbers.
+func mirrorOf(t reflect.Type) reflect.Type {
+	if m, ok := durationMirrors.Load(t); ok {
+		m, _ := m.(reflect.Type)
+		return m
+	}
+	m := buildMirror(t, make(map[reflect.Type]bool))
+	durationMirrors.Store(t, m)
+	return m
+}
+
+func buildMirror(t reflect.Type, visiting map[reflect.Type]bool) reflect.Type {
+	if t == durationType {
+		return jsonDurationType
+	}
+	if visiting[t] || t.Implements(marshalerType) || reflect.PtrTo(t).Implements(marshalerType) {
+		return nil
+	}
+	visiting[t] = true
+	defer delete(visiting, t)
+	switch t.Kind() {
+	case reflect.Ptr:
+		if m := buildMirror(t.Elem(), visiting); m != nil {
+			return reflect.PtrTo(m)
+		}
+	case reflect.Slice:
+		if m := buildMirror(t.Elem(), visiting); m != nil {
+			return reflect.SliceOf(m)
+		}
+	case reflect.Array:
+		if m := buildMirror(t.Elem(), visiting); m != nil {
+			return reflect.ArrayOf(t.Len(), m)
+		}
+	case reflect.Map:
+		if m := buildMirror(t.Elem(), visiting); m != nil {
+			return reflect.MapOf(t.Key(), m)
+		}
+	case reflect.Struct:
+		fields := make([]reflect.StructField, 0, t.NumField())
+		mirrored := false
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.PkgPath != "" {
+				if f.Anonymous {
+					return nil // its exported fields are promoted, StructOf can't embed it
+				}
+				continue // ignored by encoding/json
+			}
+			if m := buildMirror(f.Type, visiting); m != nil {
+				f.Type, mirrored = m, true
+			}
+			f.Index, f.Offset = nil, 0
+			fields = append(fields, f)
+		}
+		if mirrored {
+			return reflect.StructOf(fields)
+		}
+	}
+	return nil
+}
+
+// convert copies src to dst, one of their types is the mirror of the other
+func convert(dst, src reflect.Value) {
+	if dst.Type() == src.Type() {
+		dst.Set(src)
+		return
+	}
+	switch src.Kind() {
+	case reflect.Int64:
+		dst.SetInt(src.Int())
+	case reflect.Ptr:
+		if src.IsNil() {
+			dst.Set(reflect.Zero(dst.Type()))
+			return
+		}
+		if dst.IsNil() {
+			dst.Set(reflect.New(dst.Type().Elem()))
+		}
+		convert(dst.Elem(), src.Elem())
+	case reflect.Slice:
+		if src.IsNil() {
+			dst.Set(reflect.Zero(dst.Type()))
+			return
+		}
+		dst.Set(reflect.MakeSlice(dst.Type(), src.Len(), src.Len()))
+		fallthrough
+	case reflect.Array:
+		for i := 0; i < src.Len(); i++ {
+			convert(dst.Index(i), src.Index(i))
+		}
+	case reflect.Map:
+		if src.IsNil() {
+			dst.Set(reflect.Zero(dst.Type()))
+			return
+		}
+		dst.Set(reflect.MakeMapWithSize(dst.Type(), src.Len()))
+		iter := src.MapRange()
+		for iter.Next() {
+			v := reflect.New(dst.Type().Elem()).Elem()
+			convert(v, iter.Value())
+			dst.SetMapIndex(iter.Key(), v)
+		}
+	case reflect.Struct:
+		for i := 0; i < dst.NumField(); i++ {
+			if f := dst.Type().Field(i); f.PkgPath == "" {
+				if sf := src.FieldByName(f.Name); sf.IsValid() {
+					convert(dst.Field(i), sf)
+				}
+			}
+		}
+	}
+}
+
+// marshalDurations returns body with its durations encoded as strings
+func marshalDurations(body interface{}) interface{} {
+	if body == nil {
+		return nil
+	}
+	v := reflect.ValueOf(body)
+	m := mirrorOf(v.Type())
+	if m == nil {
+		return body
+	}
+	mv := reflect.New(m).Elem()
+	convert(mv, v)
+	return mv.Interface()
+}
+
+// decodeDurations decodes into body by decode, with its durations
+// read from strings, body is a pointer
+func decodeDurations(body interface{}, decode func(interface{}) error) error {
+	v := reflect.ValueOf(body)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return decode(body)
+	}
+	m := mirrorOf(v.Type())
+	if m == nil {
+		return decode(body)
+	}
+	mv := reflect.New(m.Elem())
+	// keep the values already in body, like decoding into body itself
+	convert(mv.Elem(), v.Elem())
+	if err := decode(mv.Interface()); err != nil {
+		return err
+	}
+	convert(v.Elem(), mv.Elem())
+	return nil
+}
@@ -2,56 +2,484 @@ package codec
 
 import (
 	"bufio"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/binary"
 	"encoding/gob"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
 	"io"
+	"io/ioutil"
 	"log"
+	"sync"
 )
 
 type GobCodec struct {
-	conn io.ReadWriteCloser
-	buf  *bufio.Writer
-	dec  *gob.Decoder
-	enc  *gob.Encoder
+	conn       io.ReadWriteCloser
+	buf        *bufio.Writer
+	dec        *gob.Decoder
+	enc        *gob.Encoder
+	raw        bool          // body of the last read header is a RawReply
+	compressed bool          // body of the last read header is compressed
+	batch      bool          // don't flush on Write
+	compress   bool          // compress the bodies written
+	dict       []byte        // preset dictionary of compression, see DictionaryCompressor
+	checksum   bool          // checksum the bodies written
+	transform  BodyTransform // of the bodies written and read, nil means none
+
+	checksummed bool   // body of the last read header is checksummed
+	sum         uint32 // checksum of the body of the last read header
+	transformed bool   // body of the last read header is transformed
+	br          bodyReader
+	maxBody     int64 // limit of the bodies read, 0 means no limit
+
+	// framed mode, every gob message is prefixed by its length
+	framed bool
+	r      *bufio.Reader
+	in     bytes.Reader // frame being decoded
+
+	out     bufferRef // where the gob encoder writes
+	pending []byte    // type definitions sent while a body failed to be encoded
+
+	header HeaderCodec // encodes headers instead of gob if set, reads from r
 }
 
 var _ Codec = (*GobCodec)(nil)
+var _ Batcher = (*GobCodec)(nil)
+var _ Compressor = (*GobCodec)(nil)
+var _ DictionaryCompressor = (*GobCodec)(nil)
+var _ Checksummer = (*GobCodec)(nil)
+var _ BodyLimiter = (*GobCodec)(nil)
+var _ Transformer = (*GobCodec)(nil)
+
+const defaultBufferSize = 4096
 
 func NewGobCodec(conn io.ReadWriteCloser) Codec {
-	buf := bufio.NewWriter(conn)
-	return &GobCodec{
+	return NewGobCodecSize(conn, defaultBufferSize)
+}
+
+// NewGobCodecSize returns a GobCodec whose writer buffer has at least size bytes.
+// Larger buffers save syscalls for large messages, to use it for all connections:
+//
+//	codec.NewCodecFuncMap[codec.GobType] = func(conn io.ReadWriteCloser) codec.Codec {
+//		return codec.NewGobCodecSize(conn, 64<<10)
+//	}
+func NewGobCodecSize(conn io.ReadWriteCloser, size int) Codec {
+	buf := bufio.NewWriterSize(conn, size)
+	c := &GobCodec{
 		conn: conn,
 		buf:  buf,
-		dec:  gob.NewDecoder(conn),
-		enc:  gob.NewEncoder(buf),
 	}
+	c.br.r = bufio.NewReader(conn)
+	c.dec = gob.NewDecoder(&c.br) // bodyReader is an io.ByteReader, gob reads no more than a message
+	c.out.w = buf
+	c.enc = gob.NewEncoder(&c.out)
+	return c
+}
+
+// maxFrameSize is the largest frame accepted in framed mode, the same limit gob applies to its messages.
+const maxFrameSize = 1 << 30
+
+var errFrameTooLarge = errors.New("rpc codec: frame too large")
+
+// framePool holds the buffers messages are encoded into and read from in framed mode.
+var framePool = sync.Pool{New: func() interface{} { return new(bytes.Buffer) }}
+
+// bufferRef redirects the output of the gob encoder, to the frame being
+// encoded, or to the body being encoded before it's written.
+type bufferRef struct{ w io.Writer }
+
+func (r *bufferRef) Write(p []byte) (int, error) { return r.w.Write(p) }
+
+// NewFramedGobCodec returns a GobCodec which prefixes every gob message with
+// its length as a uvarint, so that a message is read as a whole before it is
+// decoded. Messages are encoded into buffers taken from a pool.
+func NewFramedGobCodec(conn io.ReadWriteCloser) Codec {
+	buf := bufio.NewWriterSize(conn, defaultBufferSize)
+	c := &GobCodec{
+		conn:   conn,
+		buf:    buf,
+		framed: true,
+		r:      bufio.NewReader(conn),
+	}
+	c.dec = gob.NewDecoder(&c.in) // bytes.Reader is an io.ByteReader, gob doesn't buffer it
+	c.enc = gob.NewEncoder(&c.out)
+	return c
+}
+
+// NewGobCodecWithHeader returns a GobCodec whose headers are encoded by hc,
+// e.g. BinaryHeader, while the bodies are still gob messages.
+func NewGobCodecWithHeader(conn io.ReadWriteCloser, hc HeaderCodec) Codec {
+	buf := bufio.NewWriterSize(conn, defaultBufferSize)
+	c := &GobCodec{
+		conn:   conn,
+		buf:    buf,
+		r:      bufio.NewReader(conn),
+		header: hc,
+	}
+	c.out.w = buf
+	c.enc = gob.NewEncoder(&c.out)
+	c.br.r = c.r
+	c.dec = gob.NewDecoder(&c.br) // bodyReader is an io.ByteReader, gob reads no more than a message
+	return c
+}
+
+func (c *GobCodec) decode(v interface{}) error {
+	if !c.framed {
+		return c.dec.Decode(v)
+	}
+	n, err := binary.ReadUvarint(c.r)
+	if err != nil {
+		return err
+	}
+	if n > maxFrameSize {
+		return errFrameTooLarge
+	}
+	if c.br.limited && int64(n) > c.br.n {
+		return ErrBodyTooLarge // rejected before it's read
+	}
+	b := framePool.Get().(*bytes.Buffer)
+	defer framePool.Put(b)
+	b.Reset()
+	if _, err = io.CopyN(b, c.r, int64(n)); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	if c.br.h != nil {
+		_, _ = c.br.h.Write(b.Bytes())
+	}
+	c.in.Reset(b.Bytes())
+	return c.dec.Decode(v)
+}
+
+// bodyReader hashes the bytes read by h if it's set, i.e. while a body
+// checksummed is read, and fails once more than n bytes are read if limited
+type bodyReader struct {
+	r       *bufio.Reader
+	h       hash.Hash32
+	limited bool
+	n       int64
+	b       [1]byte
+}
+
+func (r *bodyReader) Read(p []byte) (int, error) {
+	if r.limited {
+		if r.n <= 0 {
+			return 0, ErrBodyTooLarge
+		}
+		if int64(len(p)) > r.n {
+			p = p[:r.n]
+		}
+	}
+	n, err := r.r.Read(p)
+	r.n -= int64(n)
+	if r.h != nil {
+		_, _ = r.h.Write(p[:n])
+	}
+	return n, err
 }
 
-func (c *GobCodec) ReadHeader(h *Header) error {
-	return c.dec.Decode(h)
+func (r *bodyReader) ReadByte() (byte, error) {
+	if r.limited && r.n <= 0 {
+		return 0, ErrBodyTooLarge
+	}
+	b, err := r.r.ReadByte()
+	if err == nil {
+		r.n--
+		if r.h != nil {
+			r.b[0] = b
+			_, _ = r.h.Write(r.b[:])
+		}
+	}
+	return b, err
 }
 
-func (c *GobCodec) ReadBody(body interface{}) error {
-	return c.dec.Decode(body)
+func (c *GobCodec) encode(v interface{}) error {
+	if !c.framed {
+		return c.enc.Encode(v)
+	}
+	b := framePool.Get().(*bytes.Buffer)
+	defer framePool.Put(b)
+	b.Reset()
+	c.out.w = b
+	if err := c.enc.Encode(v); err != nil {
+		return err
+	}
+	return c.writeFrame(b.Bytes())
+}
+
+func (c *GobCodec) writeFrame(frame []byte) error {
+	var size [binary.MaxVarintLen64]byte
+	if _, err := c.buf.Write(size[:binary.PutUvarint(size[:], uint64(len(frame)))]); err != nil {
+		return err
+	}
+	_, err := c.buf.Write(frame)
+	return err
+}
+
+// encodeBody encodes body into b before the message is written. The type
+// definitions gob sends before it fails to encode a body are marked sent,
+// so they are kept and sent with the next body, rather than discarded.
+func (c *GobCodec) encodeBody(b *bytes.Buffer, body interface{}) error {
+	b.Write(c.pending)
+	c.out.w = b
+	defer func() { c.out.w = c.buf }()
+	if err := c.enc.Encode(body); err != nil {
+		c.pending = append([]byte(nil), b.Bytes()...)
+		return err
+	}
+	c.pending = nil
+	return nil
+}
+
+// recoverDecode turns a panic while decoding what into an error, so that a
+// malformed message of a malicious peer fails the read rather than crashes
+// the goroutine. The rest of the message may be unread, so the connection
+// can't be used anymore.
+func recoverDecode(what string, err *error) {
+	if r := recover(); r != nil {
+		*err = fmt.Errorf("rpc codec: gob panicked decoding %s, the message is malformed: %v", what, r)
+	}
+}
+
+func (c *GobCodec) ReadHeader(h *Header) (err error) {
+	defer recoverDecode("header", &err)
+	if c.header != nil {
+		err = c.header.ReadHeader(c.r, h)
+	} else {
+		err = c.decode(h)
+	}
+	c.raw = h.Raw
+	c.compressed = h.Compressed
+	c.checksummed, c.sum = h.Checksummed, h.Checksum
+	c.transformed = h.Transformed
+	return err
+}
+
+func (c *GobCodec) ReadBody(body interface{}) (err error) {
+	defer recoverDecode("body", &err)
+	c.br.limited, c.br.n = c.maxBody > 0, c.maxBody
+	defer func() { c.br.limited, c.br.h = false, nil }()
+	if !c.checksummed {
+		return c.readBody(body)
+	}
+	// the bytes of the body are hashed while they are read
+	c.br.h = crc32.NewIEEE()
+	err = c.readBody(body)
+	if sum := c.br.h.Sum32(); err == nil && sum != c.sum {
+		err = ErrChecksum
+	}
+	return err
+}
+
+func (c *GobCodec) readBody(body interface{}) error {
+	if !c.raw && !c.compressed && !c.transformed {
+		return c.decode(body)
+	}
+	// a RawReply, a compressed or a transformed body is a standalone gob
+	// stream carried as []byte
+	var raw RawReply
+	if err := c.decode(&raw); err != nil || body == nil {
+		return err
+	}
+	if c.transformed {
+		if c.transform == nil {
+			return ErrNoTransform
+		}
+		var err error
+		if raw, err = c.transform.Decode(raw); err != nil {
+			return err
+		}
+	}
+	if c.compressed {
+		zr, err := c.decompressor(raw)
+		if err != nil {
+			return err
+		}
+		if raw, err = c.decompress(zr); err != nil {
+			return err
+		}
+	}
+	if r, ok := body.(*RawReply); ok {
+		*r = raw
+		return nil
+	}
+	return gob.NewDecoder(bytes.NewReader(raw)).Decode(body)
+}
+
+// decompressor returns a reader of the compressed body raw, which is gzip
+// compressed, or zlib compressed with the dictionary if it's set
+func (c *GobCodec) decompressor(raw []byte) (io.Reader, error) {
+	if len(raw) >= 2 && raw[0] == 0x1f && raw[1] == 0x8b { // the magic of gzip
+		return gzip.NewReader(bytes.NewReader(raw))
+	}
+	return zlib.NewReaderDict(bytes.NewReader(raw), c.dict)
+}
+
+// decompress reads the body decompressed by zr, within the limit of the
+// bodies if it's set
+func (c *GobCodec) decompress(zr io.Reader) ([]byte, error) {
+	if c.maxBody <= 0 {
+		return ioutil.ReadAll(zr)
+	}
+	raw, err := ioutil.ReadAll(io.LimitReader(zr, c.maxBody+1))
+	if err == nil && int64(len(raw)) > c.maxBody {
+		err = ErrBodyTooLarge
+	}
+	return raw, err
 }
 
 func (c *GobCodec) Write(h *Header, body interface{}) (err error) {
 	defer func() {
-		_ = c.buf.Flush()
+		if _, ok := err.(*BodyError); ok {
+			return // nothing is written
+		}
+		if !c.batch || err != nil {
+			if flushErr := c.buf.Flush(); err == nil {
+				err = flushErr
+			}
+		}
 		if err != nil {
-			_ = c.Close()
+			err = closeOnWriteError(c, err)
 		}
 	}()
-	if err = c.enc.Encode(h); err != nil {
-		log.Println("rpc: gob error encoding header:", err)
-		return
+	raw, isRaw := body.(RawReply)
+	if r, ok := body.(*RawReply); ok {
+		raw, isRaw = *r, true
+	}
+	h.Raw = isRaw
+	h.Compressed = c.compress
+	h.Transformed = c.transform != nil
+	if c.compress || isRaw || c.transform != nil {
+		if body, err = c.streamBody(raw, isRaw, body); err != nil {
+			return &BodyError{Err: err}
+		}
 	}
-	if err = c.enc.Encode(body); err != nil {
+	b := framePool.Get().(*bytes.Buffer)
+	defer framePool.Put(b)
+	b.Reset()
+	if err = c.encodeBody(b, body); err != nil {
 		log.Println("rpc: gob error encoding body:", err)
+		return &BodyError{Err: err}
+	}
+	h.Checksummed, h.Checksum = c.checksum, 0
+	if c.checksum {
+		h.Checksum = crc32.ChecksumIEEE(b.Bytes())
+	}
+	if c.header != nil {
+		err = c.header.WriteHeader(c.buf, h)
+	} else {
+		err = c.encode(h)
+	}
+	if err != nil {
+		log.Println("rpc: gob error encoding header:", err)
 		return
 	}
+	if c.framed {
+		err = c.writeFrame(b.Bytes())
+	} else {
+		_, err = c.buf.Write(b.Bytes())
+	}
 	return
 }
 
+// streamBody returns the standalone gob stream of body, raw is the stream
+// already if isRaw, compressed and then transformed if the codec does so
+func (c *GobCodec) streamBody(raw RawReply, isRaw bool, body interface{}) ([]byte, error) {
+	data := []byte(raw)
+	var err error
+	switch {
+	case c.compress:
+		if data, err = compressBody(raw, isRaw, body, c.dict); err != nil {
+			log.Println("rpc: gob error compressing body:", err)
+			return nil, err
+		}
+	case !isRaw:
+		var buf bytes.Buffer
+		if err = gob.NewEncoder(&buf).Encode(body); err != nil {
+			log.Println("rpc: gob error encoding body:", err)
+			return nil, err
+		}
+		data = buf.Bytes()
+	}
+	if c.transform != nil {
+		if data, err = c.transform.Encode(data); err != nil {
+			log.Println("rpc: gob error transforming body:", err)
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+// compressBody returns the gzip compressed standalone gob stream of body,
+// raw is the stream already if isRaw. It's zlib compressed with dict preset
+// instead if dict is set, gzip doesn't support dictionaries. The messages a
+// dictionary is meant for are small, so they are compressed at the best
+// compression, which references the dictionary for small inputs too.
+func compressBody(raw RawReply, isRaw bool, body interface{}, dict []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	var zw io.WriteCloser
+	var err error
+	if dict != nil {
+		if zw, err = zlib.NewWriterLevelDict(&buf, zlib.BestCompression, dict); err != nil {
+			return nil, err
+		}
+	} else {
+		zw = gzip.NewWriter(&buf)
+	}
+	if isRaw {
+		_, err = zw.Write(raw)
+	} else {
+		err = gob.NewEncoder(zw).Encode(body)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (c *GobCodec) Flush() error {
+	if err := c.buf.Flush(); err != nil {
+		return closeOnWriteError(c, err)
+	}
+	return nil
+}
+
+func (c *GobCodec) SetBatch(batch bool) {
+	c.batch = batch
+}
+
+func (c *GobCodec) SetCompress(compress bool) {
+	c.compress = compress
+}
+
+func (c *GobCodec) SetDictionary(dict []byte) {
+	c.dict = dict
+}
+
+func (c *GobCodec) SetChecksum(checksum bool) {
+	c.checksum = checksum
+}
+
+func (c *GobCodec) SetMaxBodySize(n int64) {
+	c.maxBody = n
+}
+
+func (c *GobCodec) SetTransform(t BodyTransform) {
+	c.transform = t
+}
+
+// Close flushes the buffered messages and closes the connection. A failed
+// flush means the messages are lost, its error is returned rather than
+// swallowed, joined with the error of closing if both fail.
 func (c *GobCodec) Close() error {
-	return c.conn.Close()
+	return joinErrors(c.buf.Flush(), c.conn.Close())
 }
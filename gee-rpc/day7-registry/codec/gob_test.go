@@ -0,0 +1,435 @@
+package codec
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+// countConn counts the Write calls, each of them is a syscall on a real connection.
+// The written bytes can be read back.
+type countConn struct {
+	bytes.Buffer
+	writes int
+}
+
+func (c *countConn) Write(p []byte) (int, error) { c.writes++; return c.Buffer.Write(p) }
+func (c *countConn) Close() error                { return nil }
+
+func _assert(condition bool, msg string, v ...interface{}) {
+	if !condition {
+		panic(fmt.Sprintf("assertion failed: "+msg, v...))
+	}
+}
+
+func TestGobCodec_Batch(t *testing.T) {
+	conn := &countConn{}
+	cc := NewGobCodec(conn)
+	cc.(Batcher).SetBatch(true)
+	for i := 1; i <= 3; i++ {
+		_ = cc.Write(&Header{ServiceMethod: "Foo.Sum", Seq: uint64(i)}, i)
+	}
+	_assert(conn.writes == 0, "expect nothing sent before Flush, got %d writes", conn.writes)
+	err := cc.Flush()
+	_assert(err == nil && conn.writes == 1, "expect 1 write after Flush, got %d: %v", conn.writes, err)
+	for i := 1; i <= 3; i++ {
+		var h Header
+		var body int
+		_ = cc.ReadHeader(&h)
+		_ = cc.ReadBody(&body)
+		_assert(h.Seq == uint64(i) && body == i, "failed to read message %d, got seq %d, body %d", i, h.Seq, body)
+	}
+}
+
+func BenchmarkGobCodec_Write(b *testing.B) {
+	body := make([]byte, 32<<10)
+	for _, size := range []int{defaultBufferSize, 16 << 10, 64 << 10} {
+		b.Run(fmt.Sprintf("buffer-%dK", size>>10), func(b *testing.B) {
+			conn := &countConn{}
+			cc := NewGobCodecSize(conn, size)
+			h := &Header{ServiceMethod: "Foo.Sum"}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				h.Seq = uint64(i)
+				_ = cc.Write(h, body)
+				conn.Reset()
+			}
+			b.ReportMetric(float64(conn.writes)/float64(b.N), "writes/op")
+		})
+	}
+}
+
+// failConn fails every Write, and Close if closeErr is set
+type failConn struct {
+	bytes.Buffer
+	closeErr error
+}
+
+var errBrokenPipe = errors.New("broken pipe")
+
+func (c *failConn) Write(p []byte) (int, error) { return 0, errBrokenPipe }
+func (c *failConn) Close() error                { return c.closeErr }
+
+func TestGobCodec_Close(t *testing.T) {
+	t.Run("flush error", func(t *testing.T) {
+		cc := NewGobCodec(&failConn{}).(*GobCodec)
+		cc.SetBatch(true)
+		_ = cc.Write(&Header{ServiceMethod: "Foo.Sum", Seq: 1}, 1)
+		cc.SetBatch(false)
+		err := cc.Close()
+		_assert(err == errBrokenPipe, "expect the flush error, got %v", err)
+	})
+	t.Run("joined error", func(t *testing.T) {
+		errClosed := errors.New("use of closed connection")
+		cc := NewGobCodec(&failConn{closeErr: errClosed}).(*GobCodec)
+		cc.SetBatch(true)
+		_ = cc.Write(&Header{ServiceMethod: "Foo.Sum", Seq: 1}, 1)
+		err := cc.Close()
+		joined, ok := err.(joinedError)
+		_assert(ok && len(joined) == 2, "expect both errors joined, got %v", err)
+		_assert(joined[0] == errBrokenPipe && joined[1] == errClosed, "expect flush error first, got %v", err)
+	})
+	t.Run("no error", func(t *testing.T) {
+		err := NewGobCodec(&countConn{}).Close()
+		_assert(err == nil, "expect no error, got %v", err)
+	})
+}
+
+func TestGobCodec_Framed(t *testing.T) {
+	conn := &countConn{}
+	cc := NewFramedGobCodec(conn)
+	type Args struct{ Num1, Num2 int }
+	for i := 1; i <= 3; i++ {
+		_ = cc.Write(&Header{ServiceMethod: "Foo.Sum", Seq: uint64(i)}, &Args{Num1: i, Num2: i * i})
+	}
+	_ = cc.Write(&Header{ServiceMethod: "Foo.Raw", Seq: 4}, RawReply("raw"))
+	for i := 1; i <= 3; i++ {
+		var h Header
+		var args Args
+		_ = cc.ReadHeader(&h)
+		err := cc.ReadBody(&args)
+		_assert(err == nil && h.Seq == uint64(i) && args.Num2 == i*i, "failed to read message %d: %v", i, err)
+	}
+	var h Header
+	var raw RawReply
+	_ = cc.ReadHeader(&h)
+	err := cc.ReadBody(&raw)
+	_assert(err == nil && h.Raw && string(raw) == "raw", "failed to read raw reply: %v", err)
+
+	conn.Reset()
+	conn.Write([]byte{0xff, 0xff, 0xff, 0xff, 0x7f})
+	err = cc.ReadHeader(&h)
+	_assert(err == errFrameTooLarge, "expect frame too large, got %v", err)
+}
+
+func TestGobCodec_Compress(t *testing.T) {
+	conn := &countConn{}
+	cc := NewGobCodec(conn)
+	_ = cc.Write(&Header{ServiceMethod: "Foo.Sum", Seq: 1}, 1)
+	cc.(Compressor).SetCompress(true)
+	_ = cc.Write(&Header{ServiceMethod: "Foo.Sum", Seq: 2}, make([]byte, 1<<16))
+	_ = cc.Write(&Header{ServiceMethod: "Foo.Sum", Seq: 3}, RawReply("raw"))
+	_assert(conn.Len() < 1<<12, "expect the large body compressed, got %d bytes", conn.Len())
+
+	var h Header
+	var n int
+	_ = cc.ReadHeader(&h)
+	err := cc.ReadBody(&n)
+	_assert(err == nil && !h.Compressed && n == 1, "failed to read uncompressed body: %v", err)
+	var b []byte
+	_ = cc.ReadHeader(&h)
+	err = cc.ReadBody(&b)
+	_assert(err == nil && h.Compressed && len(b) == 1<<16, "failed to read compressed body: %v", err)
+	var raw RawReply
+	_ = cc.ReadHeader(&h)
+	err = cc.ReadBody(&raw)
+	_assert(err == nil && h.Compressed && h.Raw && string(raw) == "raw", "failed to read compressed raw reply: %v", err)
+}
+
+func TestGobCodec_CompressDictionary(t *testing.T) {
+	type Event struct {
+		Kind, Source, Message string
+		Seq                   int
+	}
+	event := func(i int) Event {
+		return Event{Kind: "user.signed_in", Source: "auth-service/eu-west-1", Message: "user signed in", Seq: i}
+	}
+	// the dictionary is what the messages have in common, the stream of a sample
+	var sample bytes.Buffer
+	_ = gob.NewEncoder(&sample).Encode(event(0))
+	written := func(dict []byte) int {
+		conn := &countConn{}
+		cc := NewGobCodec(conn)
+		cc.(Compressor).SetCompress(true)
+		cc.(DictionaryCompressor).SetDictionary(dict)
+		for i := 1; i <= 10; i++ {
+			_ = cc.Write(&Header{ServiceMethod: "Log.Append", Seq: uint64(i)}, event(i))
+		}
+		n := conn.Len()
+		for i := 1; i <= 10; i++ {
+			var h Header
+			var e Event
+			_ = cc.ReadHeader(&h)
+			err := cc.ReadBody(&e)
+			_assert(err == nil && e == event(i), "failed to read message %d with dictionary %t: %v", i, dict != nil, err)
+		}
+		return n
+	}
+	plain, withDict := written(nil), written(sample.Bytes())
+	_assert(withDict < plain*2/3, "expect the dictionary to compress better, got %d bytes with it and %d without", withDict, plain)
+
+	// a body compressed with the dictionary can't be read without it
+	conn := &countConn{}
+	cc := NewGobCodec(conn)
+	cc.(Compressor).SetCompress(true)
+	cc.(DictionaryCompressor).SetDictionary(sample.Bytes())
+	_ = cc.Write(&Header{ServiceMethod: "Log.Append", Seq: 1}, event(1))
+	cc.(DictionaryCompressor).SetDictionary(nil)
+	var h Header
+	var e Event
+	_ = cc.ReadHeader(&h)
+	err := cc.ReadBody(&e)
+	_assert(err != nil, "expect a body compressed with a dictionary rejected without it")
+}
+
+// unpooledFramer frames gob messages the way the framed GobCodec does,
+// but with a new buffer for every message.
+type unpooledFramer struct {
+	w   io.Writer
+	out bufferRef
+	enc *gob.Encoder
+}
+
+func (f *unpooledFramer) Write(h *Header, body interface{}) {
+	for _, v := range []interface{}{h, body} {
+		b := new(bytes.Buffer)
+		f.out.w = b
+		_ = f.enc.Encode(v)
+		var size [binary.MaxVarintLen64]byte
+		_, _ = f.w.Write(size[:binary.PutUvarint(size[:], uint64(b.Len()))])
+		_, _ = f.w.Write(b.Bytes())
+	}
+}
+
+func BenchmarkGobCodec_Framed(b *testing.B) {
+	body := make([]byte, 32<<10)
+	h := &Header{ServiceMethod: "Foo.Sum"}
+	b.Run("pooled", func(b *testing.B) {
+		conn := &countConn{}
+		cc := NewFramedGobCodec(conn)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			h.Seq = uint64(i)
+			_ = cc.Write(h, body)
+			conn.Reset()
+		}
+	})
+	b.Run("unpooled", func(b *testing.B) {
+		conn := &countConn{}
+		f := &unpooledFramer{w: bufio.NewWriterSize(conn, defaultBufferSize)}
+		f.enc = gob.NewEncoder(&f.out)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			h.Seq = uint64(i)
+			f.Write(h, body)
+			conn.Reset()
+		}
+	})
+}
+
+type unregistered struct{ N int }
+
+func TestGobCodec_BodyError(t *testing.T) {
+	type Box struct {
+		Tag string
+		V   interface{}
+	}
+	for name, newCodec := range map[string]NewCodecFunc{
+		"plain":     NewGobCodec,
+		"framed":    NewFramedGobCodec,
+		"binheader": func(conn io.ReadWriteCloser) Codec { return NewGobCodecWithHeader(conn, BinaryHeader{}) },
+	} {
+		conn := &countConn{}
+		cc := newCodec(conn)
+		// the type of Box is sent before its interface fails to be encoded
+		err := cc.Write(&Header{Seq: 1}, &Box{Tag: "bad", V: unregistered{N: 1}})
+		var bodyErr *BodyError
+		_assert(errors.As(err, &bodyErr), "expect a BodyError over %s, got %v", name, err)
+		_assert(conn.Len() == 0, "expect nothing written over %s, got %d bytes", name, conn.Len())
+		_assert(len(cc.(*GobCodec).pending) > 0, "expect the type of Box kept for the next body over %s", name)
+		err = cc.Write(&Header{Seq: 2, Error: "failed"}, struct{}{})
+		_assert(err == nil, "expect the codec usable over %s, got %v", name, err)
+		err = cc.Write(&Header{Seq: 3}, &Box{Tag: "ok"})
+		_assert(err == nil, "failed to write over %s: %v", name, err)
+
+		var h Header
+		err = cc.ReadHeader(&h)
+		_assert(err == nil && h.Seq == 2 && cc.ReadBody(nil) == nil, "failed to read the error response over %s: %v", name, err)
+		var box Box
+		err = cc.ReadHeader(&h)
+		_assert(err == nil && h.Seq == 3, "failed to read header over %s: %v", name, err)
+		err = cc.ReadBody(&box)
+		_assert(err == nil && box.Tag == "ok", "failed to read the body after a BodyError over %s: %v", name, err)
+	}
+}
+
+// shortConn writes half of the bytes asked without an error, and records
+// whether it's closed
+type shortConn struct {
+	bytes.Buffer
+	closed bool
+}
+
+func (c *shortConn) Write(p []byte) (int, error) { return c.Buffer.Write(p[:len(p)/2]) }
+func (c *shortConn) Close() error                { c.closed = true; return nil }
+
+func TestCodec_ShortWrite(t *testing.T) {
+	for _, codecType := range []Type{GobType, GobFramedType, JsonType} {
+		conn := &shortConn{}
+		cc := NewCodecFuncMap[codecType](conn)
+		err := cc.Write(&Header{ServiceMethod: "Foo.Sum", Seq: 1}, 1)
+		_assert(errors.Is(err, io.ErrShortWrite) && conn.closed, "expect a short write to close the connection over %s, got %v", codecType, err)
+
+		conn = &shortConn{}
+		cc = NewCodecFuncMap[codecType](conn)
+		cc.(Batcher).SetBatch(true)
+		err = cc.Write(&Header{ServiceMethod: "Foo.Sum", Seq: 1}, 1)
+		_assert(err == nil && !conn.closed, "expect nothing written before Flush over %s, got %v", codecType, err)
+		err = cc.Flush()
+		_assert(errors.Is(err, io.ErrShortWrite) && conn.closed, "expect a short flush to close the connection over %s, got %v", codecType, err)
+	}
+}
+
+func TestCodec_Checksum(t *testing.T) {
+	for _, codecType := range []Type{GobType, GobFramedType, GobBinaryHeaderType, JsonType} {
+		conn := &countConn{}
+		cc := NewCodecFuncMap[codecType](conn)
+		cc.(Checksummer).SetChecksum(true)
+		_ = cc.Write(&Header{ServiceMethod: "Foo.Sum", Seq: 1}, 12345)
+		// flip a bit of the value in transit, JSON is followed by a newline
+		b := conn.Bytes()
+		i := len(b) - 1
+		if codecType == JsonType {
+			i--
+		}
+		b[i] ^= 1
+		_ = cc.Write(&Header{ServiceMethod: "Foo.Sum", Seq: 2}, 12345)
+
+		var h Header
+		var n int
+		err := cc.ReadHeader(&h)
+		_assert(err == nil && h.Checksummed, "expect a checksummed header over %s, got %v", codecType, err)
+		err = cc.ReadBody(&n)
+		_assert(err == ErrChecksum, "expect the corruption detected over %s, got %v with %d", codecType, err, n)
+		err = cc.ReadHeader(&h)
+		_assert(err == nil && h.Seq == 2, "expect the next header read over %s, got %v", codecType, err)
+		err = cc.ReadBody(&n)
+		_assert(err == nil && n == 12345, "failed to read the next body over %s: %v", codecType, err)
+	}
+}
+
+// reverseTransform reverses the bytes of a body, it's its own inverse
+type reverseTransform struct{}
+
+func (reverseTransform) Encode(body []byte) ([]byte, error) {
+	out := make([]byte, len(body))
+	for i, b := range body {
+		out[len(body)-1-i] = b
+	}
+	return out, nil
+}
+
+func (t reverseTransform) Decode(body []byte) ([]byte, error) { return t.Encode(body) }
+
+func TestCodec_Transform(t *testing.T) {
+	for _, codecType := range []Type{GobType, GobFramedType, GobBinaryHeaderType, JsonType} {
+		conn := &countConn{}
+		cc := NewCodecFuncMap[codecType](conn)
+		cc.(Transformer).SetTransform(reverseTransform{})
+		_ = cc.Write(&Header{ServiceMethod: "Foo.Echo", Seq: 1}, "geerpc")
+		_ = cc.Write(&Header{ServiceMethod: "Foo.Echo", Seq: 2}, RawReply(nil))
+		_ = cc.Write(&Header{ServiceMethod: "Foo.Echo", Seq: 3}, "geerpc")
+
+		var h Header
+		var s string
+		err := cc.ReadHeader(&h)
+		_assert(err == nil && h.Transformed, "expect a transformed header over %s, got %v", codecType, err)
+		err = cc.ReadBody(&s)
+		_assert(err == nil && s == "geerpc", "failed to read the transformed body over %s: %v", codecType, err)
+		_ = cc.ReadHeader(&h)
+		_assert(cc.ReadBody(nil) == nil, "failed to discard a transformed body over %s", codecType)
+
+		cc.(Transformer).SetTransform(nil)
+		err = cc.ReadHeader(&h)
+		_assert(err == nil && h.Seq == 3, "expect the next header read over %s, got %v", codecType, err)
+		err = cc.ReadBody(&s)
+		_assert(err == ErrNoTransform, "expect a transformed body rejected without a transform over %s, got %v", codecType, err)
+	}
+}
+
+func TestCodec_MaxBodySize(t *testing.T) {
+	for _, codecType := range []Type{GobType, GobFramedType, GobBinaryHeaderType, JsonType} {
+		for _, compress := range []bool{false, true} {
+			if compress && codecType == JsonType {
+				continue
+			}
+			conn := &countConn{}
+			cc := NewCodecFuncMap[codecType](conn)
+			if compress {
+				cc.(Compressor).SetCompress(true)
+			}
+			cc.(BodyLimiter).SetMaxBodySize(1 << 12)
+			_ = cc.Write(&Header{ServiceMethod: "Foo.Get", Seq: 1}, make([]byte, 1<<10))
+			_ = cc.Write(&Header{ServiceMethod: "Foo.Get", Seq: 2}, make([]byte, 1<<16))
+
+			var h Header
+			var b []byte
+			_ = cc.ReadHeader(&h)
+			err := cc.ReadBody(&b)
+			_assert(err == nil && len(b) == 1<<10, "failed to read a body within the limit over %s: %v", codecType, err)
+			_ = cc.ReadHeader(&h)
+			err = cc.ReadBody(&b)
+			_assert(errors.Is(err, ErrBodyTooLarge), "expect the body too large over %s, compressed %v, got %v", codecType, compress, err)
+		}
+	}
+}
+
+// fragile panics decoding a malformed value, like a GobDecoder indexing bytes it trusts
+type fragile struct{ b []byte }
+
+func (f fragile) GobEncode() ([]byte, error) { return f.b, nil }
+
+func (f *fragile) GobDecode(b []byte) error {
+	f.b = b[:b[0]]
+	return nil
+}
+
+func TestGobCodec_DecodePanic(t *testing.T) {
+	for name, newCodec := range map[string]NewCodecFunc{
+		"plain":     NewGobCodec,
+		"framed":    NewFramedGobCodec,
+		"binheader": func(conn io.ReadWriteCloser) Codec { return NewGobCodecWithHeader(conn, BinaryHeader{}) },
+	} {
+		conn := &countConn{}
+		cc := newCodec(conn)
+		_ = cc.Write(&Header{ServiceMethod: "Foo.Decode", Seq: 1}, fragile{b: []byte{0xff}})
+		var h Header
+		err := cc.ReadHeader(&h)
+		_assert(err == nil, "failed to read header over %s: %v", name, err)
+		var f fragile
+		err = cc.ReadBody(&f)
+		_assert(err != nil && strings.Contains(err.Error(), "gob panicked decoding body"), "expect the panic returned as error over %s, got %v", name, err)
+
+		// garbage where a header starts
+		conn.Reset()
+		conn.Write([]byte{0x05, 0xff, 0x81, 0x03, 0x01, 0x02, 0x07, 0xff, 0xff})
+		err = newCodec(conn).ReadHeader(&h)
+		_assert(err != nil, "expect malformed bytes rejected over %s", name)
+	}
+}
@@ -0,0 +1,192 @@
+package codec
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// HeaderCodec encodes headers in a format of its own, the body is still
+// encoded by the codec it's composed with, see NewGobCodecWithHeader.
+// Headers are small and of the same shape, a format packing their fields
+// by hand costs less than a general purpose one.
+type HeaderCodec interface {
+	ReadHeader(r *bufio.Reader, h *Header) error
+	WriteHeader(w *bufio.Writer, h *Header) error
+}
+
+// BinaryHeader is a HeaderCodec packing a header as
+//
+//	flags byte | seq uvarint | ServiceMethod | Error | Location | code uvarint | priority varint | [checksum] | metadata
+//
+// where a string is its length as a uvarint followed by its bytes, the
+// checksum is 4 bytes big endian present if flagged, and the metadata is the
+// number of pairs as a uvarint followed by the pairs.
+type BinaryHeader struct{}
+
+var _ HeaderCodec = BinaryHeader{}
+
+const (
+	flagRaw = 1 << iota
+	flagCancel
+	flagCompressed
+	flagMore
+	flagChecksum
+	flagErrorBody
+	flagProgress
+	flagTransformed
+)
+
+// maxHeaderString limits the length of a string read in a binary header
+const maxHeaderString = 1 << 20
+
+var errHeaderTooLarge = errors.New("rpc codec: header string too large")
+
+func (BinaryHeader) WriteHeader(w *bufio.Writer, h *Header) error {
+	var flags byte
+	if h.Raw {
+		flags |= flagRaw
+	}
+	if h.Cancel {
+		flags |= flagCancel
+	}
+	if h.Compressed {
+		flags |= flagCompressed
+	}
+	if h.More {
+		flags |= flagMore
+	}
+	if h.Checksummed {
+		flags |= flagChecksum
+	}
+	if h.ErrorBody {
+		flags |= flagErrorBody
+	}
+	if h.Progress {
+		flags |= flagProgress
+	}
+	if h.Transformed {
+		flags |= flagTransformed
+	}
+	_ = w.WriteByte(flags)
+	writeUvarint(w, h.Seq)
+	writeString(w, h.ServiceMethod)
+	writeString(w, h.Error)
+	writeString(w, h.Location)
+	writeUvarint(w, uint64(h.Code))
+	writeVarint(w, int64(h.Priority))
+	if h.Checksummed {
+		var sum [4]byte
+		binary.BigEndian.PutUint32(sum[:], h.Checksum)
+		_, _ = w.Write(sum[:])
+	}
+	writeUvarint(w, uint64(len(h.Metadata)))
+	for k, v := range h.Metadata {
+		writeString(w, k)
+		writeString(w, v)
+	}
+	// bufio.Writer keeps the first error, it's returned by every later call
+	_, err := w.Write(nil)
+	return err
+}
+
+func (BinaryHeader) ReadHeader(r *bufio.Reader, h *Header) (err error) {
+	*h = Header{}
+	flags, err := r.ReadByte()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		// the header is incomplete once its first byte is read
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+	}()
+	h.Raw = flags&flagRaw != 0
+	h.Cancel = flags&flagCancel != 0
+	h.Compressed = flags&flagCompressed != 0
+	h.More = flags&flagMore != 0
+	h.Checksummed = flags&flagChecksum != 0
+	h.ErrorBody = flags&flagErrorBody != 0
+	h.Progress = flags&flagProgress != 0
+	h.Transformed = flags&flagTransformed != 0
+	if h.Seq, err = binary.ReadUvarint(r); err != nil {
+		return err
+	}
+	for _, s := range []*string{&h.ServiceMethod, &h.Error, &h.Location} {
+		if *s, err = readString(r); err != nil {
+			return err
+		}
+	}
+	code, err := binary.ReadUvarint(r)
+	if err != nil {
+		return err
+	}
+	h.Code = int(code)
+	priority, err := binary.ReadVarint(r)
+	if err != nil {
+		return err
+	}
+	h.Priority = int(priority)
+	if h.Checksummed {
+		var sum [4]byte
+		if _, err = io.ReadFull(r, sum[:]); err != nil {
+			return err
+		}
+		h.Checksum = binary.BigEndian.Uint32(sum[:])
+	}
+	n, err := binary.ReadUvarint(r)
+	if err != nil || n == 0 {
+		return err
+	}
+	if n > maxHeaderString {
+		return errHeaderTooLarge
+	}
+	h.Metadata = make(map[string]string)
+	for i := uint64(0); i < n; i++ {
+		k, err := readString(r)
+		if err != nil {
+			return err
+		}
+		if h.Metadata[k], err = readString(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeUvarint writes x like binary.PutUvarint, byte by byte to save the
+// allocation of a slice passed to Write
+func writeUvarint(w *bufio.Writer, x uint64) {
+	for x >= 0x80 {
+		_ = w.WriteByte(byte(x) | 0x80)
+		x >>= 7
+	}
+	_ = w.WriteByte(byte(x))
+}
+
+// writeVarint writes x like binary.PutVarint, zig-zag encoded
+func writeVarint(w *bufio.Writer, x int64) {
+	writeUvarint(w, uint64(x<<1)^uint64(x>>63))
+}
+
+func writeString(w *bufio.Writer, s string) {
+	writeUvarint(w, uint64(len(s)))
+	_, _ = w.WriteString(s)
+}
+
+func readString(r *bufio.Reader) (string, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil || n == 0 {
+		return "", err
+	}
+	if n > maxHeaderString {
+		return "", errHeaderTooLarge
+	}
+	b := make([]byte, n)
+	if _, err = io.ReadFull(r, b); err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
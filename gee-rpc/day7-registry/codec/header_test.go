@@ -0,0 +1,76 @@
+package codec
+
+import (
+	"bufio"
+	"encoding/gob"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+func TestGobCodecWithHeader(t *testing.T) {
+	conn := &countConn{}
+	cc := NewGobCodecWithHeader(conn, BinaryHeader{})
+	type Args struct{ Num1, Num2 int }
+	headers := []*Header{
+		{ServiceMethod: "Foo.Sum", Seq: 1},
+		{ServiceMethod: "Foo.Sum", Seq: 1 << 40, Error: "failed", Location: "foo.go:12", Code: 5, Priority: -3, Metadata: map[string]string{"version": "1.0"}},
+		{ServiceMethod: "Foo.Sum", Seq: 3, Cancel: true, More: true, Progress: true, Transformed: true},
+	}
+	for i, h := range headers {
+		_ = cc.Write(h, &Args{Num1: i, Num2: i * i})
+	}
+	_ = cc.Write(&Header{ServiceMethod: "Foo.Raw", Seq: 4}, RawReply("raw"))
+	for i, want := range headers {
+		var h Header
+		var args Args
+		err := cc.ReadHeader(&h)
+		_assert(err == nil, "failed to read header %d: %v", i, err)
+		_assert(h.ServiceMethod == want.ServiceMethod && h.Seq == want.Seq && h.Error == want.Error &&
+			h.Location == want.Location && h.Code == want.Code && h.Priority == want.Priority && h.Cancel == want.Cancel && h.More == want.More &&
+			h.Progress == want.Progress && h.Transformed == want.Transformed,
+			"expect header %+v, got %+v", want, h)
+		_assert(len(h.Metadata) == len(want.Metadata) && h.Metadata["version"] == want.Metadata["version"],
+			"expect metadata %v, got %v", want.Metadata, h.Metadata)
+		err = cc.ReadBody(&args)
+		_assert(err == nil && args.Num1 == i && args.Num2 == i*i, "failed to read body %d: %v", i, err)
+	}
+	var h Header
+	var raw RawReply
+	_ = cc.ReadHeader(&h)
+	err := cc.ReadBody(&raw)
+	_assert(err == nil && h.Raw && string(raw) == "raw", "failed to read raw body: %v", err)
+
+	err = cc.ReadHeader(&h)
+	_assert(err == io.EOF, "expect EOF after the last message, got %v", err)
+}
+
+func TestBinaryHeader_truncated(t *testing.T) {
+	conn := &countConn{}
+	w := bufio.NewWriter(conn)
+	_ = BinaryHeader{}.WriteHeader(w, &Header{ServiceMethod: "Foo.Sum", Seq: 1})
+	_ = w.Flush()
+	b := conn.Bytes()
+	var h Header
+	err := BinaryHeader{}.ReadHeader(bufio.NewReader(io.LimitReader(&conn.Buffer, int64(len(b)-2))), &h)
+	_assert(err == io.ErrUnexpectedEOF, "expect ErrUnexpectedEOF for a truncated header, got %v", err)
+}
+
+// BenchmarkHeader compares the cost of encoding headers by gob and by BinaryHeader.
+func BenchmarkHeader(b *testing.B) {
+	h := &Header{ServiceMethod: "Foo.Sum", Seq: 12345}
+	b.Run("gob", func(b *testing.B) {
+		enc := gob.NewEncoder(bufio.NewWriter(ioutil.Discard))
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_ = enc.Encode(h)
+		}
+	})
+	b.Run("binary", func(b *testing.B) {
+		w := bufio.NewWriter(ioutil.Discard)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_ = BinaryHeader{}.WriteHeader(w, h)
+		}
+	})
+}
@@ -0,0 +1,254 @@
+package codec
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"hash/crc32"
+	"io"
+	"log"
+	"reflect"
+)
+
+// JsonCodec encodes the header and the body as two JSON values, each ends a
+// line, a streamed body has a line per element.
+// The time.Duration values of bodies are strings like "5s", and time.Time
+// values are RFC 3339 strings, so that a method behaves the same over gob.
+// A body of a slice, e.g. a multi-megabyte list reply, is streamed: it's
+// encoded straight to the buffered connection an element at a time, and
+// decoded an element at a time by the token API of json.Decoder, so that
+// it's never held as a whole besides the slice itself, see writeElements.
+// Other values are marshaled or decoded as a whole by encoding/json, and so
+// are the bodies checksummed or transformed. A value read, or a streamed
+// body, is limited to maxJsonValueSize.
+type JsonCodec struct {
+	conn  io.ReadWriteCloser
+	buf   *bufio.Writer
+	r     *limitReader
+	dec   *json.Decoder
+	enc   *json.Encoder
+	batch bool // don't flush on Write
+
+	maxBody     int64         // limit of the bodies read, 0 means no limit
+	checksum    bool          // checksum the bodies written
+	checksummed bool          // body of the last read header is checksummed
+	sum         uint32        // checksum of the body of the last read header
+	transform   BodyTransform // of the bodies written and read, nil means none
+	transformed bool          // body of the last read header is transformed
+}
+
+var _ Codec = (*JsonCodec)(nil)
+var _ Batcher = (*JsonCodec)(nil)
+var _ Checksummer = (*JsonCodec)(nil)
+var _ BodyLimiter = (*JsonCodec)(nil)
+var _ Transformer = (*JsonCodec)(nil)
+
+// maxJsonValueSize limits the bytes read for a single JSON value, header or body
+const maxJsonValueSize = 1 << 30
+
+var errValueTooLarge = errors.New("rpc codec: json value too large")
+
+// limitReader fails by err, errValueTooLarge if it's nil, once more than n
+// bytes are read, usually n is reset before reading every value
+type limitReader struct {
+	r   io.Reader
+	n   int64
+	err error
+}
+
+func (l *limitReader) Read(p []byte) (int, error) {
+	if l.n <= 0 {
+		if l.err != nil {
+			return 0, l.err
+		}
+		return 0, errValueTooLarge
+	}
+	if int64(len(p)) > l.n {
+		p = p[:l.n]
+	}
+	n, err := l.r.Read(p)
+	l.n -= int64(n)
+	return n, err
+}
+
+func NewJsonCodec(conn io.ReadWriteCloser) Codec {
+	buf := bufio.NewWriterSize(conn, defaultBufferSize)
+	r := &limitReader{r: conn}
+	return &JsonCodec{
+		conn: conn,
+		buf:  buf,
+		r:    r,
+		dec:  json.NewDecoder(r),
+		enc:  json.NewEncoder(buf),
+	}
+}
+
+func (c *JsonCodec) decode(v interface{}) error {
+	c.r.n, c.r.err = maxJsonValueSize, nil
+	return c.dec.Decode(v)
+}
+
+// decodeBody is decode within the limit of the bodies, see limitBody
+func (c *JsonCodec) decodeBody(v interface{}) error {
+	c.limitBody()
+	return c.dec.Decode(v)
+}
+
+// limitBody limits the bytes read from now on to the limit of the bodies if
+// it's set, less the bytes buffered by the decoder already
+func (c *JsonCodec) limitBody() {
+	if c.maxBody <= 0 || c.maxBody >= maxJsonValueSize {
+		c.r.n, c.r.err = maxJsonValueSize, nil
+		return
+	}
+	c.r.n, c.r.err = c.maxBody, ErrBodyTooLarge
+	if b, ok := c.dec.Buffered().(*bytes.Reader); ok {
+		c.r.n -= int64(b.Len())
+	}
+}
+
+func (c *JsonCodec) ReadHeader(h *Header) error {
+	err := c.decode(h)
+	c.checksummed, c.sum = h.Checksummed, h.Checksum
+	c.transformed = h.Transformed
+	return err
+}
+
+func (c *JsonCodec) ReadBody(body interface{}) error {
+	if c.checksummed || c.transformed {
+		return c.readWhole(body)
+	}
+	switch r := body.(type) {
+	case nil:
+		var discard json.RawMessage
+		return c.decodeBody(&discard)
+	case *RawReply:
+		return c.decodeBody((*json.RawMessage)(r))
+	}
+	if sv, ok := sliceBody(body); ok && reflect.ValueOf(body).Kind() == reflect.Ptr {
+		return c.readElements(sv)
+	}
+	return decodeDurations(body, c.decodeBody)
+}
+
+// readWhole reads the body as it's written, the checksum is verified and
+// the transform is reversed before it's decoded
+func (c *JsonCodec) readWhole(body interface{}) error {
+	var raw json.RawMessage
+	if err := c.decodeBody(&raw); err != nil {
+		return err
+	}
+	if c.checksummed && crc32.ChecksumIEEE(raw) != c.sum {
+		return ErrChecksum
+	}
+	if c.transformed {
+		// a transformed body is a JSON string of its bytes in base64
+		if c.transform == nil {
+			return ErrNoTransform
+		}
+		var data []byte
+		if err := json.Unmarshal(raw, &data); err != nil {
+			return err
+		}
+		data, err := c.transform.Decode(data)
+		if err != nil {
+			return err
+		}
+		raw = data
+	}
+	switch r := body.(type) {
+	case nil:
+		return nil
+	case *RawReply:
+		*r = RawReply(raw)
+		return nil
+	}
+	return decodeDurations(body, func(v interface{}) error { return json.Unmarshal(raw, v) })
+}
+
+func (c *JsonCodec) Write(h *Header, body interface{}) (err error) {
+	defer func() {
+		if _, ok := err.(*BodyError); ok {
+			return // nothing is written
+		}
+		if !c.batch || err != nil {
+			if flushErr := c.buf.Flush(); err == nil {
+				err = flushErr
+			}
+		}
+		if err != nil {
+			err = closeOnWriteError(c, err)
+		}
+	}()
+	// a RawReply is JSON already, it's written inline
+	raw, isRaw := body.(RawReply)
+	if r, ok := body.(*RawReply); ok {
+		raw, isRaw = *r, true
+	}
+	h.Raw = isRaw
+	// a nil slice is null, it's marshaled as a whole
+	if sv, ok := sliceBody(body); ok && !sv.IsNil() && !isRaw && c.transform == nil && !c.checksum {
+		h.Transformed, h.Checksummed, h.Checksum = false, false, 0
+		return c.writeElements(h, sv)
+	}
+	if isRaw {
+		body = json.RawMessage(raw)
+	} else {
+		body = marshalDurations(body)
+	}
+	// the body is marshaled first, so that nothing is written if it fails
+	data, err := json.Marshal(body)
+	if err != nil {
+		log.Println("rpc: json error encoding body:", err)
+		return &BodyError{Err: err}
+	}
+	h.Transformed = c.transform != nil
+	if c.transform != nil {
+		if data, err = c.transform.Encode(data); err != nil {
+			log.Println("rpc: json error transforming body:", err)
+			return &BodyError{Err: err}
+		}
+		data, _ = json.Marshal(data)
+	}
+	h.Checksummed, h.Checksum = c.checksum, 0
+	if c.checksum {
+		h.Checksum = crc32.ChecksumIEEE(data)
+	}
+	if err = c.enc.Encode(h); err != nil {
+		log.Println("rpc: json error encoding header:", err)
+		return
+	}
+	if _, err = c.buf.Write(append(data, '\n')); err != nil {
+		return
+	}
+	return
+}
+
+func (c *JsonCodec) Flush() error {
+	if err := c.buf.Flush(); err != nil {
+		return closeOnWriteError(c, err)
+	}
+	return nil
+}
+
+func (c *JsonCodec) SetBatch(batch bool) {
+	c.batch = batch
+}
+
+func (c *JsonCodec) SetChecksum(checksum bool) {
+	c.checksum = checksum
+}
+
+func (c *JsonCodec) SetMaxBodySize(n int64) {
+	c.maxBody = n
+}
+
+func (c *JsonCodec) SetTransform(t BodyTransform) {
+	c.transform = t
+}
+
+// Close flushes the buffered messages and closes the connection, like GobCodec.Close.
+func (c *JsonCodec) Close() error {
+	return joinErrors(c.buf.Flush(), c.conn.Close())
+}
@@ -0,0 +1,235 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJsonCodec(t *testing.T) {
+	conn := &countConn{}
+	cc := NewJsonCodec(conn)
+	type Args struct{ Num1, Num2 int }
+	_ = cc.Write(&Header{ServiceMethod: "Foo.Sum", Seq: 1}, &Args{Num1: 1, Num2: 2})
+	_ = cc.Write(&Header{ServiceMethod: "Foo.Sum", Seq: 2}, &Args{Num1: 3, Num2: 4})
+	_ = cc.Write(&Header{ServiceMethod: "Foo.Raw", Seq: 3}, RawReply(`{"Num1":5}`))
+	_assert(strings.Count(conn.String(), "\n") == 6, "expect header and body on separate lines:\n%s", conn.String())
+
+	var h Header
+	var args Args
+	_ = cc.ReadHeader(&h)
+	err := cc.ReadBody(nil)
+	_assert(err == nil && h.Seq == 1, "failed to discard body: %v", err)
+	_ = cc.ReadHeader(&h)
+	err = cc.ReadBody(&args)
+	_assert(err == nil && h.Seq == 2 && args.Num2 == 4, "failed to read body: %v", err)
+	var raw RawReply
+	_ = cc.ReadHeader(&h)
+	err = cc.ReadBody(&raw)
+	_assert(err == nil && h.Raw && string(raw) == `{"Num1":5}`, "failed to read raw reply: %v", err)
+}
+
+func TestJsonCodec_Durations(t *testing.T) {
+	type Retry struct {
+		Backoff  time.Duration
+		Attempts int
+	}
+	type Policy struct {
+		Timeout time.Duration
+		Retries []Retry
+		ByName  map[string]*time.Duration
+		Since   time.Time
+	}
+	conn := &countConn{}
+	cc := NewJsonCodec(conn)
+	d := time.Millisecond * 1500
+	since := time.Date(2020, 1, 2, 3, 4, 5, 6, time.UTC)
+	policy := &Policy{Timeout: time.Second * 5, Retries: []Retry{{time.Second, 3}}, ByName: map[string]*time.Duration{"read": &d}, Since: since}
+	_ = cc.Write(&Header{Seq: 1}, policy)
+	_assert(strings.Contains(conn.String(), `"Timeout":"5s"`) && strings.Contains(conn.String(), `"read":"1.5s"`),
+		"expect durations as strings:\n%s", conn.String())
+	_assert(strings.Contains(conn.String(), `"2020-01-02T03:04:05.000000006Z"`), "expect time as RFC 3339:\n%s", conn.String())
+
+	var h Header
+	got := Policy{Retries: []Retry{{Attempts: 1}}}
+	_ = cc.ReadHeader(&h)
+	err := cc.ReadBody(&got)
+	_assert(err == nil, "failed to read body: %v", err)
+	_assert(got.Timeout == policy.Timeout && got.Retries[0] == policy.Retries[0] && *got.ByName["read"] == d && got.Since.Equal(since),
+		"expect the policy round-tripped, got %+v", got)
+
+	// a number of nanoseconds is read as well
+	conn = &countConn{}
+	cc = NewJsonCodec(conn)
+	_ = cc.Write(&Header{Seq: 2}, map[string]int64{"Timeout": int64(time.Second)})
+	_ = cc.ReadHeader(&h)
+	err = cc.ReadBody(&got)
+	_assert(err == nil && got.Timeout == time.Second, "expect a nanosecond duration read, got %s: %v", got.Timeout, err)
+}
+
+func TestLimitReader(t *testing.T) {
+	r := &limitReader{r: strings.NewReader(`"0123456789"`), n: 4}
+	err := json.NewDecoder(r).Decode(new(string))
+	_assert(err == errValueTooLarge, "expect value too large, got %v", err)
+}
+
+type benchItem struct {
+	ID    int
+	Name  string
+	Tags  []string
+	Score float64
+}
+
+// benchReply is about 5MB in JSON
+func benchReply() []benchItem {
+	items := make([]benchItem, 50000)
+	for i := range items {
+		items[i] = benchItem{ID: i, Name: strings.Repeat("n", 32), Tags: []string{"alpha", "beta"}, Score: 0.5}
+	}
+	return items
+}
+
+// BenchmarkJsonCodec_Write compares the codec, which streams a 5MB slice
+// reply to the connection an element at a time, with the naive approach of
+// marshaling the whole message before writing it.
+func BenchmarkJsonCodec_Write(b *testing.B) {
+	reply := benchReply()
+	h := &Header{ServiceMethod: "Foo.List"}
+	b.Run("codec", func(b *testing.B) {
+		cc := NewJsonCodec(nopConn{ioutil.Discard})
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_ = cc.Write(h, reply)
+		}
+	})
+	b.Run("naive", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			hb, _ := json.Marshal(h)
+			bb, _ := json.Marshal(reply)
+			msg := make([]byte, 0, len(hb)+len(bb)+2)
+			msg = append(append(append(append(msg, hb...), '\n'), bb...), '\n')
+			_, _ = ioutil.Discard.Write(msg)
+		}
+	})
+}
+
+// BenchmarkJsonCodec_ReadBody compares the codec, which decodes a 5MB slice
+// reply an element at a time, with decoding it as a whole value.
+func BenchmarkJsonCodec_ReadBody(b *testing.B) {
+	var msg bytes.Buffer
+	_ = NewJsonCodec(nopConn{&msg}).Write(&Header{ServiceMethod: "Foo.List"}, benchReply())
+	b.Run("codec", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			cc := NewJsonCodec(readConn{bytes.NewReader(msg.Bytes())})
+			var h Header
+			var reply []benchItem
+			_ = cc.ReadHeader(&h)
+			_ = cc.ReadBody(&reply)
+		}
+	})
+	b.Run("naive", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			dec := json.NewDecoder(bytes.NewReader(msg.Bytes()))
+			var h Header
+			var reply []benchItem
+			_ = dec.Decode(&h)
+			_ = dec.Decode(&reply)
+		}
+	})
+}
+
+// readConn reads from a reader, it's never written
+type readConn struct{ io.Reader }
+
+func (readConn) Write(p []byte) (int, error) { return len(p), nil }
+func (readConn) Close() error                { return nil }
+
+// nopConn writes to a writer, it's never read
+type nopConn struct{ io.Writer }
+
+func (nopConn) Read(p []byte) (int, error) { return 0, io.EOF }
+func (nopConn) Close() error               { return nil }
+
+func TestJsonCodec_StreamSlice(t *testing.T) {
+	type Retry struct {
+		Backoff  time.Duration
+		Attempts int
+	}
+	conn := &countConn{}
+	cc := NewJsonCodec(conn)
+	reply := benchReply()[:2000] // beyond streamThreshold
+	_ = cc.Write(&Header{Seq: 1}, &reply)
+	_ = cc.Write(&Header{Seq: 2}, []Retry{{time.Second, 3}})
+	_assert(strings.Contains(conn.String(), `"Backoff":"1s"`), "expect the durations of elements as strings:\n%s", conn.String()[conn.Len()-100:])
+	_ = cc.Write(&Header{Seq: 3}, json.RawMessage(`[1,"two",3]`))
+	_ = cc.Write(&Header{Seq: 4}, []int(nil))
+
+	var h Header
+	var got []benchItem
+	_ = cc.ReadHeader(&h)
+	err := cc.ReadBody(&got)
+	_assert(err == nil && h.Seq == 1 && len(got) == len(reply) && got[1999].ID == 1999 && got[0].Tags[1] == "beta", "failed to stream the slice: %v", err)
+	var retries []Retry
+	_ = cc.ReadHeader(&h)
+	err = cc.ReadBody(&retries)
+	_assert(err == nil && h.Seq == 2 && len(retries) == 1 && retries[0].Backoff == time.Second, "failed to stream durations: %v %+v", err, retries)
+
+	// like encoding/json, an element which doesn't decode is skipped
+	var nums []int
+	_ = cc.ReadHeader(&h)
+	err = cc.ReadBody(&nums)
+	_, isType := err.(*json.UnmarshalTypeError)
+	_assert(isType && len(nums) == 3 && nums[2] == 3, "expect the element of a wrong type skipped, got %v: %v", nums, err)
+	nums = []int{1}
+	err = cc.ReadHeader(&h)
+	_assert(err == nil && h.Seq == 4, "expect the next message in sync, got seq %d: %v", h.Seq, err)
+	err = cc.ReadBody(&nums)
+	_assert(err == nil && nums == nil, "expect null read as a nil slice, got %v: %v", nums, err)
+}
+
+func TestJsonCodec_StreamSliceError(t *testing.T) {
+	conn := &countConn{}
+	cc := NewJsonCodec(conn)
+	// nothing is written if it fails within streamThreshold
+	err := cc.Write(&Header{Seq: 1}, []float64{1, math.NaN()})
+	_, isBody := err.(*BodyError)
+	_assert(isBody && conn.Len() == 0, "expect a BodyError and nothing written, got %v, %d bytes", err, conn.Len())
+
+	// a part of a large body is written already, the connection is closed
+	large := make([]float64, streamThreshold)
+	large[len(large)-1] = math.NaN()
+	err = cc.Write(&Header{Seq: 2}, large)
+	_, isBody = err.(*BodyError)
+	_assert(err != nil && !isBody && strings.Contains(err.Error(), "connection closed"), "expect the connection closed, got %v", err)
+}
+
+// failing fails to decode itself
+type failing struct{}
+
+func (*failing) UnmarshalJSON([]byte) error { return errors.New("failing") }
+
+func TestJsonCodec_StreamSliceDrain(t *testing.T) {
+	conn := &countConn{}
+	cc := NewJsonCodec(conn)
+	_ = cc.Write(&Header{Seq: 1}, json.RawMessage(`[{},{},{}]`))
+	_ = cc.Write(&Header{Seq: 2}, []int{1, 2})
+
+	var h Header
+	_ = cc.ReadHeader(&h)
+	err := cc.ReadBody(&[]failing{})
+	_assert(err != nil && err.Error() == "failing", "expect the error of the element, got %v", err)
+	var body []int
+	err = cc.ReadHeader(&h)
+	_assert(err == nil && h.Seq == 2, "expect the next message in sync, got seq %d: %v", h.Seq, err)
+	err = cc.ReadBody(&body)
+	_assert(err == nil && len(body) == 2, "expect the next body read, got %v: %v", body, err)
+}
@@ -0,0 +1,182 @@
+package codec
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+)
+
+// streamThreshold is how much of a slice body JsonCodec marshals before it
+// writes the header, a body failing to marshal within it is written as
+// nothing, see BodyError. A larger body is written as it's marshaled, if an
+// element of it fails the connection is closed.
+const streamThreshold = 64 << 10
+
+var (
+	unmarshalerType     = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+	textMarshalerType   = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// customJSON reports whether t or *t encodes or decodes itself
+func customJSON(t reflect.Type) bool {
+	for _, tt := range []reflect.Type{t, reflect.PtrTo(t)} {
+		if tt.Implements(marshalerType) || tt.Implements(unmarshalerType) ||
+			tt.Implements(textMarshalerType) || tt.Implements(textUnmarshalerType) {
+			return true
+		}
+	}
+	return false
+}
+
+// sliceBody returns the slice of body, which is a slice or a pointer to
+// one, if it's streamed element by element: it's not a []byte, which is
+// a base64 string, nor of a type encoding or decoding itself.
+func sliceBody(body interface{}) (reflect.Value, bool) {
+	v := reflect.ValueOf(body)
+	if v.Kind() == reflect.Ptr && !v.IsNil() && !customJSON(v.Type().Elem()) {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Slice || customJSON(v.Type()) || v.Type().Elem().Kind() == reflect.Uint8 {
+		return reflect.Value{}, false
+	}
+	return v, true
+}
+
+// switchWriter writes to w, which is switched once the header is written
+type switchWriter struct{ w io.Writer }
+
+func (s *switchWriter) Write(p []byte) (int, error) { return s.w.Write(p) }
+
+// writeElements writes the header and the slice body sv as a JSON array,
+// an element at a time. The elements are marshaled ahead up to
+// streamThreshold, then the header is written and the rest are encoded
+// straight to the connection, so that a large body is never held whole.
+func (c *JsonCodec) writeElements(h *Header, sv reflect.Value) error {
+	var ahead bytes.Buffer
+	w := &switchWriter{w: &ahead}
+	enc := json.NewEncoder(w)
+	streaming := false
+	start := func() error {
+		if err := c.enc.Encode(h); err != nil {
+			return err
+		}
+		_, err := c.buf.Write(ahead.Bytes())
+		w.w, streaming = c.buf, true
+		return err
+	}
+	_ = ahead.WriteByte('[')
+	for i := 0; i < sv.Len(); i++ {
+		if i > 0 {
+			if _, err := w.Write([]byte{','}); err != nil {
+				return err
+			}
+		}
+		// an element is encoded by its address, like encoding/json does
+		// for the elements of a slice, Encode terminates it with a newline
+		if err := enc.Encode(marshalDurations(sv.Index(i).Addr().Interface())); err != nil {
+			if !streaming {
+				return &BodyError{Err: err}
+			}
+			return fmt.Errorf("rpc codec: json error encoding element %d of body: %w", i, err)
+		}
+		if !streaming && ahead.Len() > streamThreshold {
+			if err := start(); err != nil {
+				return err
+			}
+		}
+	}
+	if !streaming {
+		if err := start(); err != nil {
+			return err
+		}
+	}
+	_, err := w.Write([]byte("]\n"))
+	return err
+}
+
+// readElements decodes the body into the slice sv, an element at a time by
+// the token API, so that the JSON array is never buffered whole. Like
+// encoding/json, an element of a wrong type is skipped and the error is
+// returned once the array is read, other errors stop decoding.
+func (c *JsonCodec) readElements(sv reflect.Value) error {
+	c.limitBody()
+	tok, err := c.dec.Token()
+	if err != nil {
+		return err
+	}
+	if tok == nil {
+		sv.Set(reflect.Zero(sv.Type())) // null
+		return nil
+	}
+	if d, ok := tok.(json.Delim); !ok || d != '[' {
+		err = &json.UnmarshalTypeError{Value: fmt.Sprint(tok), Type: sv.Type()}
+		if ok {
+			// the rest of the object is read through
+			if skipErr := c.skip(); skipErr != nil {
+				return skipErr
+			}
+		}
+		return err
+	}
+	s := reflect.MakeSlice(sv.Type(), 0, 0)
+	if !sv.IsNil() {
+		s = sv.Slice(0, 0) // like encoding/json, the slice is reused
+	}
+	var typeErr error
+	for c.dec.More() {
+		elem := reflect.New(sv.Type().Elem())
+		err := decodeDurations(elem.Interface(), c.dec.Decode)
+		if _, ok := err.(*json.UnmarshalTypeError); ok {
+			if typeErr == nil {
+				typeErr = err
+			}
+		} else if err != nil {
+			return c.drain(err)
+		}
+		s = reflect.Append(s, elem.Elem())
+	}
+	if _, err := c.dec.Token(); err != nil {
+		return err
+	}
+	sv.Set(s)
+	return typeErr
+}
+
+// drain reads the rest of the array after an element failed by err, so
+// that the next message is in sync if the element is read through, e.g.
+// by an UnmarshalJSON failing. It returns the error reading the array if
+// it can't go on, err otherwise.
+func (c *JsonCodec) drain(err error) error {
+	for c.dec.More() {
+		var discard json.RawMessage
+		if readErr := c.dec.Decode(&discard); readErr != nil {
+			return readErr
+		}
+	}
+	if _, readErr := c.dec.Token(); readErr != nil {
+		return readErr
+	}
+	return err
+}
+
+// skip reads the tokens through the end of the object or array whose
+// opening delimiter is read already
+func (c *JsonCodec) skip() error {
+	for depth := 1; depth > 0; {
+		tok, err := c.dec.Token()
+		if err != nil {
+			return err
+		}
+		switch tok {
+		case json.Delim('['), json.Delim('{'):
+			depth++
+		case json.Delim(']'), json.Delim('}'):
+			depth--
+		}
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+package geerpc
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// serverConn wraps a connection being served.
+// It hands the bytes which json.Decoder has read ahead while decoding
+// the Option over to the codec, so that a request sent right after
+// the Option is not lost. It also sets the write deadline of the
+// connection before each write if writeTimeout is set.
+type serverConn struct {
+	io.ReadWriteCloser
+	r            *bufio.Reader
+	writeTimeout time.Duration
+	remoteAddr   net.Addr // nil if conn isn't a network connection
+	start        time.Time
+	pending      int64             // number of requests being handled, accessed atomically
+	clientCert   *x509.Certificate // verified client certificate of a mutual TLS connection
+
+	reading chan struct{} // closed when the server stops reading requests
+	done    chan struct{} // closed when the connection is closed
+
+	mu       sync.Mutex                    // protect following
+	cancels  map[uint64]context.CancelFunc // cancel the calls being handled by seq
+	draining bool                          // stop reading requests, see Server.Shutdown
+}
+
+func newServerConn(conn io.ReadWriteCloser, buffered io.Reader, writeTimeout time.Duration) *serverConn {
+	r := bufio.NewReader(io.MultiReader(buffered, conn))
+	sc := &serverConn{
+		ReadWriteCloser: conn,
+		r:               r,
+		writeTimeout:    writeTimeout,
+		start:           time.Now(),
+		reading:         make(chan struct{}),
+		done:            make(chan struct{}),
+		cancels:         make(map[uint64]context.CancelFunc),
+	}
+	if c, ok := conn.(interface{ RemoteAddr() net.Addr }); ok {
+		sc.remoteAddr = c.RemoteAddr()
+	}
+	// the handshake is done while reading the Option
+	if c, ok := conn.(*tls.Conn); ok {
+		if chains := c.ConnectionState().VerifiedChains; len(chains) > 0 {
+			sc.clientCert = chains[0][0]
+		}
+	}
+	return sc
+}
+
+func (c *serverConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+// setIdleDeadline makes the next read fail if nothing arrives within timeout.
+// It returns false if the connection is draining.
+func (c *serverConn) setIdleDeadline(timeout time.Duration) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.draining {
+		return false
+	}
+	if d, ok := c.ReadWriteCloser.(interface{ SetReadDeadline(time.Time) error }); ok && timeout > 0 {
+		_ = d.SetReadDeadline(time.Now().Add(timeout))
+	}
+	return true
+}
+
+// drain interrupts reading requests, the requests being handled go on.
+// It has no effect on connections without read deadlines until they are closed.
+func (c *serverConn) drain() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.draining = true
+	if d, ok := c.ReadWriteCloser.(interface{ SetReadDeadline(time.Time) error }); ok {
+		_ = d.SetReadDeadline(time.Now())
+	}
+}
+
+func (c *serverConn) isDraining() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.draining
+}
+
+// cancelAll cancels the contexts of all calls being handled
+func (c *serverConn) cancelAll() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for seq, cancel := range c.cancels {
+		cancel()
+		delete(c.cancels, seq)
+	}
+}
+
+func (c *serverConn) Write(p []byte) (int, error) {
+	if d, ok := c.ReadWriteCloser.(interface{ SetWriteDeadline(time.Time) error }); ok && c.writeTimeout > 0 {
+		_ = d.SetWriteDeadline(time.Now().Add(c.writeTimeout))
+	}
+	return c.ReadWriteCloser.Write(p)
+}
+
+// startCall returns the context of the call seq, it's done once cancelCall(seq)
+func (c *serverConn) startCall(seq uint64) context.Context {
+	ctx := context.Background()
+	if c.remoteAddr != nil {
+		ctx = context.WithValue(ctx, remoteAddrKey, c.remoteAddr)
+	}
+	if c.clientCert != nil {
+		ctx = context.WithValue(ctx, clientCertKey, c.clientCert)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cancels[seq] = cancel
+	return ctx
+}
+
+// cancelCall cancels the context of the call seq, it's a no-op if it's done
+func (c *serverConn) cancelCall(seq uint64) {
+	c.mu.Lock()
+	cancel, ok := c.cancels[seq]
+	delete(c.cancels, seq)
+	c.mu.Unlock()
+	if ok {
+		cancel()
+	}
+}
+
+// isTimeout reports whether err is a read timed out, e.g. by IdleTimeout,
+// rather than the connection closed
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// setNoDelay sets TCP_NODELAY on TCP connections, it's a no-op for others
+func setNoDelay(conn net.Conn, noDelay bool) {
+	if c, ok := conn.(*net.TCPConn); ok {
+		_ = c.SetNoDelay(noDelay)
+	}
+}
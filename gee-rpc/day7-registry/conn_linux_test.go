@@ -0,0 +1,43 @@
+package geerpc
+
+import (
+	"net"
+	"syscall"
+	"testing"
+)
+
+func tcpNoDelay(conn net.Conn) int {
+	var v int
+	raw, _ := conn.(*net.TCPConn).SyscallConn()
+	_ = raw.Control(func(fd uintptr) {
+		v, _ = syscall.GetsockoptInt(int(fd), syscall.IPPROTO_TCP, syscall.TCP_NODELAY)
+	})
+	return v
+}
+
+func TestDial_NoDelay(t *testing.T) {
+	l, _ := net.Listen("tcp", ":0")
+	defer func() { _ = l.Close() }()
+	go func() {
+		for {
+			if _, err := l.Accept(); err != nil {
+				return
+			}
+		}
+	}()
+	for _, nagle := range []bool{false, true} {
+		var noDelay int
+		f := func(conn net.Conn, opt *Option) (*Client, error) {
+			noDelay = tcpNoDelay(conn)
+			return nil, nil
+		}
+		_, _ = dialTimeout(f, "tcp", l.Addr().String(), &Option{Nagle: nagle})
+		_assert((noDelay != 0) == !nagle, "expect TCP_NODELAY %v with Nagle %v", !nagle, nagle)
+	}
+
+	// it's a no-op for connections other than TCP
+	c1, c2 := net.Pipe()
+	setNoDelay(c1, true)
+	_ = c1.Close()
+	_ = c2.Close()
+}
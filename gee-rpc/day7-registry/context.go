@@ -0,0 +1,86 @@
+package geerpc
+
+import (
+	"context"
+	"crypto/x509"
+	"net"
+	"sync"
+)
+
+// contextKey is the key type of the values which the server
+// puts into the context passed to methods
+type contextKey int
+
+const (
+	responseMetadataKey contextKey = iota
+	clientCertKey
+	remoteAddrKey
+	priorityKey
+	progressKey
+	progressReporterKey
+)
+
+// WithPriority returns a copy of ctx making the calls of it carry priority,
+// a busy server handles the calls of higher priority first, see
+// Server.MaxWorkers. Calls have priority 0 by default.
+func WithPriority(ctx context.Context, priority int) context.Context {
+	return context.WithValue(ctx, priorityKey, priority)
+}
+
+func priorityOf(ctx context.Context) int {
+	priority, _ := ctx.Value(priorityKey).(int)
+	return priority
+}
+
+// RemoteAddr returns the network address of the client making the call
+// ctx belongs to, nil if the connection isn't a network connection.
+func RemoteAddr(ctx context.Context) net.Addr {
+	addr, _ := ctx.Value(remoteAddrKey).(net.Addr)
+	return addr
+}
+
+// ClientCertificate returns the verified certificate of the client making
+// the call ctx belongs to, e.g. to authorize by its Subject.CommonName or
+// DNSNames. It's nil unless the connection is a mutual TLS connection,
+// i.e. the server is listening by tls.NewListener with a tls.Config
+// verifying client certificates.
+func ClientCertificate(ctx context.Context) *x509.Certificate {
+	cert, _ := ctx.Value(clientCertKey).(*x509.Certificate)
+	return cert
+}
+
+// responseMetadata collects the metadata set by a method
+type responseMetadata struct {
+	mu sync.Mutex
+	md map[string]string
+}
+
+// SetResponseMetadata sets a metadata pair in the response header of the
+// call ctx belongs to, the client reads it by CallWithHeader.
+// It's a no-op if ctx isn't passed in by the server.
+func SetResponseMetadata(ctx context.Context, key, value string) {
+	rm, ok := ctx.Value(responseMetadataKey).(*responseMetadata)
+	if !ok {
+		return
+	}
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	if rm.md == nil {
+		rm.md = make(map[string]string)
+	}
+	rm.md[key] = value
+}
+
+// snapshot returns a copy of the metadata, nil if nothing is set
+func (rm *responseMetadata) snapshot() map[string]string {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	if len(rm.md) == 0 {
+		return nil
+	}
+	md := make(map[string]string, len(rm.md))
+	for k, v := range rm.md {
+		md[k] = v
+	}
+	return md
+}
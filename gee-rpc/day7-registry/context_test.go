@@ -0,0 +1,261 @@
+package geerpc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+type Meta int
+
+func (m Meta) Version(ctx context.Context, args int, reply *int) error {
+	SetResponseMetadata(ctx, "version", "1.0")
+	*reply = args
+	return nil
+}
+
+func TestSetResponseMetadata(t *testing.T) {
+	server := NewServer()
+	var m Meta
+	_ = server.Register(&m)
+	client, _ := Dial("tcp", startTestServer(server))
+	defer func() { _ = client.Close() }()
+
+	var reply int
+	h, err := client.CallWithHeader(context.Background(), "Meta.Version", 1, &reply)
+	_assert(err == nil && reply == 1, "failed to call Meta.Version: %v", err)
+	_assert(h.Metadata["version"] == "1.0", "expect version in the response metadata, got %v", h.Metadata)
+
+	// it's harmless to set metadata out of a call
+	SetResponseMetadata(context.Background(), "version", "1.0")
+}
+
+type Canceler struct{ cancelled chan struct{} }
+
+// Wait waits until the call is cancelled
+func (c *Canceler) Wait(ctx context.Context, args int, reply *int) error {
+	select {
+	case <-ctx.Done():
+		c.cancelled <- struct{}{}
+		return ctx.Err()
+	case <-time.After(time.Second * 2):
+		return nil
+	}
+}
+
+func TestClient_CancelPropagation(t *testing.T) {
+	server := NewServer()
+	c := &Canceler{cancelled: make(chan struct{}, 1)}
+	_ = server.Register(c)
+	client, _ := Dial("tcp", startTestServer(server))
+	defer func() { _ = client.Close() }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(time.Millisecond*50, cancel)
+	var reply int
+	err := client.Call(ctx, "Canceler.Wait", 0, &reply)
+	_assert(err != nil, "expect the call cancelled")
+	select {
+	case <-c.cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("expect the context of the method cancelled")
+	}
+	_assert(client.IsAvailable(), "expect the connection alive after cancellation")
+}
+
+func TestServer_CancelOnHangUp(t *testing.T) {
+	server := NewServer()
+	c := &Canceler{cancelled: make(chan struct{}, 1)}
+	_ = server.Register(c)
+	client, _ := Dial("tcp", startTestServer(server))
+
+	call := client.Go("Canceler.Wait", 0, new(int), nil)
+	time.Sleep(time.Millisecond * 50)
+	_ = client.Close() // the connection drops mid-call
+	select {
+	case <-c.cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("expect the context of the method cancelled once the client hangs up")
+	}
+	<-call.Done
+	_assert(call.Error != nil, "expect the call failed by the closed client")
+}
+
+func TestCall_Cancel(t *testing.T) {
+	server := NewServer()
+	c := &Canceler{cancelled: make(chan struct{}, 1)}
+	var foo Foo
+	_ = server.Register(c)
+	_ = server.Register(&foo)
+	client, _ := Dial("tcp", startTestServer(server))
+	defer func() { _ = client.Close() }()
+
+	call := client.Go("Canceler.Wait", 0, new(int), nil)
+	time.Sleep(time.Millisecond * 50)
+	call.Cancel()
+	call.Cancel()
+	select {
+	case <-call.Done:
+		_assert(call.Error == ErrCancelled, "expect ErrCancelled, got %v", call.Error)
+	case <-time.After(time.Second):
+		t.Fatal("expect the call done once cancelled")
+	}
+	select {
+	case <-c.cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("expect the context of the method cancelled")
+	}
+	select {
+	case <-call.Done:
+		t.Fatal("expect the call done only once")
+	default:
+	}
+
+	// cancelling a completed call is a no-op
+	var reply int
+	call = client.Go("Foo.Sum", &Args{Num1: 1, Num2: 2}, &reply, nil)
+	<-call.Done
+	call.Cancel()
+	_assert(call.Error == nil && reply == 3, "expect the completed call kept, got %v", call.Error)
+	_assert(client.IsAvailable(), "expect the connection alive after cancellation")
+}
+
+type Whoami int
+
+func (w Whoami) Name(ctx context.Context, args int, reply *string) error {
+	if cert := ClientCertificate(ctx); cert != nil {
+		*reply = cert.Subject.CommonName
+	}
+	return nil
+}
+
+// newTestCert issues a certificate for cn signed by parent, it's self-signed if parent is nil
+func newTestCert(cn string, parent *tls.Certificate) tls.Certificate {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: cn},
+		DNSNames:              []string{cn},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  parent == nil,
+	}
+	signer, signerKey := tmpl, interface{}(key)
+	if parent != nil {
+		signer, signerKey = parent.Leaf, parent.PrivateKey
+	}
+	der, _ := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	leaf, _ := x509.ParseCertificate(der)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+func TestClientCertificate(t *testing.T) {
+	ca := newTestCert("test-ca", nil)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Leaf)
+	serverCert := newTestCert("127.0.0.1", &ca)
+	clientCert := newTestCert("alice", &ca)
+
+	server := NewServer()
+	var w Whoami
+	_ = server.Register(&w)
+	l, _ := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientAuth:   tls.VerifyClientCertIfGiven,
+		ClientCAs:    pool,
+	})
+	defer func() { _ = l.Close() }()
+	go server.Accept(l)
+
+	call := func(certs ...tls.Certificate) (string, error) {
+		conn, err := tls.Dial("tcp", l.Addr().String(), &tls.Config{RootCAs: pool, Certificates: certs})
+		if err != nil {
+			return "", err
+		}
+		client, err := NewClient(conn, DefaultOption)
+		if err != nil {
+			return "", err
+		}
+		defer func() { _ = client.Close() }()
+		var reply string
+		err = client.Call(context.Background(), "Whoami.Name", 0, &reply)
+		return reply, err
+	}
+	name, err := call(clientCert)
+	_assert(err == nil && name == "alice", "expect the common name of client certificate, got %q: %v", name, err)
+	name, err = call()
+	_assert(err == nil && name == "", "expect no identity without client certificate, got %q: %v", name, err)
+}
+
+type Echo int
+
+func (e Echo) Addr(ctx context.Context, args int, reply *string) error {
+	if addr := RemoteAddr(ctx); addr != nil {
+		*reply = addr.String()
+	}
+	return nil
+}
+
+func TestRemoteAddr(t *testing.T) {
+	server := NewServer()
+	var e Echo
+	_ = server.Register(&e)
+	conn, _ := net.Dial("tcp", startTestServer(server))
+	client, _ := NewClient(conn, DefaultOption)
+	defer func() { _ = client.Close() }()
+
+	var reply string
+	err := client.Call(context.Background(), "Echo.Addr", 0, &reply)
+	_assert(err == nil && reply == conn.LocalAddr().String(), "expect remote address %s, got %q: %v", conn.LocalAddr(), reply, err)
+
+	// nil for transports other than network
+	c1, c2 := net.Pipe()
+	go server.ServeConn(struct{ io.ReadWriteCloser }{c1})
+	client, _ = NewClient(c2, DefaultOption)
+	defer func() { _ = client.Close() }()
+	reply = "unset"
+	err = client.Call(context.Background(), "Echo.Addr", 0, &reply)
+	_assert(err == nil && reply == "", "expect no remote address over a pipe, got %q: %v", reply, err)
+}
+
+type tenantKey struct{}
+
+func (m Meta) Tenant(ctx context.Context, args int, reply *string) error {
+	*reply, _ = ctx.Value(tenantKey{}).(string)
+	return nil
+}
+
+func TestContextMetadata(t *testing.T) {
+	server := NewServer()
+	server.ContextMetadata = map[string]interface{}{"tenant": tenantKey{}}
+	var m Meta
+	_ = server.Register(&m)
+	client, err := Dial("tcp", startTestServer(server), &Option{
+		ContextMetadata: map[interface{}]string{tenantKey{}: "tenant"},
+	})
+	_assert(err == nil, "failed to dial: %v", err)
+	defer func() { _ = client.Close() }()
+
+	var reply string
+	ctx := context.WithValue(context.Background(), tenantKey{}, "acme")
+	h, err := client.CallWithHeader(ctx, "Meta.Tenant", 0, &reply)
+	_assert(err == nil && reply == "acme", "expect tenant acme in the handler context, got %q: %v", reply, err)
+	_assert(h.Metadata["tenant"] == "", "expect the request metadata not echoed, got %v", h.Metadata)
+
+	reply = "unset"
+	err = client.Call(context.Background(), "Meta.Tenant", 0, &reply)
+	_assert(err == nil && reply == "", "expect no tenant without the context value, got %q: %v", reply, err)
+}
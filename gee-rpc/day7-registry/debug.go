@@ -5,15 +5,22 @@
 package geerpc
 
 import (
+	"compress/gzip"
 	"fmt"
 	"html/template"
+	"io"
 	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
 )
 
 const debugText = `<html>
 	<body>
 	<title>GeeRPC Services</title>
-	{{range .}}
+	{{range .Services}}
 	<hr>
 	Service {{.Name}}
 	<hr>
@@ -27,6 +34,33 @@ const debugText = `<html>
 		{{end}}
 		</table>
 	{{end}}
+	{{if .Aliases}}
+	<hr>
+	Aliases
+	<hr>
+		<table>
+		<th align=center>Alias</th><th align=center>Method</th>
+		{{range .Aliases}}
+			<tr>
+			<td align=left font=fixed>{{.Alias}} (alias)</td>
+			<td align=left font=fixed>{{.Method}}</td>
+			</tr>
+		{{end}}
+		</table>
+	{{end}}
+	<hr>
+	Connections
+	<hr>
+		<table>
+		<th align=center>Remote Address</th><th align=center>Uptime</th><th align=center>Pending</th>
+		{{range .Conns}}
+			<tr>
+			<td align=left font=fixed>{{.RemoteAddr}}</td>
+			<td align=center>{{.Uptime}}</td>
+			<td align=center>{{.Pending}}</td>
+			</tr>
+		{{end}}
+		</table>
 	</body>
 	</html>`
 
@@ -41,6 +75,18 @@ type debugService struct {
 	Method map[string]*methodType
 }
 
+type debugAlias struct {
+	Alias  string
+	Method string
+}
+
+type debugConn struct {
+	RemoteAddr string
+	Uptime     time.Duration
+	Pending    int64
+	start      time.Time
+}
+
 // Runs at /debug/geerpc
 func (server debugHTTP) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	// Build a sorted version of the data.
@@ -53,8 +99,142 @@ func (server debugHTTP) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 		})
 		return true
 	})
-	err := debug.Execute(w, services)
+	var aliases []debugAlias
+	server.aliases.Range(func(aliasi, targeti interface{}) bool {
+		aliases = append(aliases, debugAlias{Alias: aliasi.(string), Method: targeti.(string)})
+		return true
+	})
+	sort.Slice(aliases, func(i, j int) bool { return aliases[i].Alias < aliases[j].Alias })
+	var conns []debugConn
+	server.conns.Range(func(sci, _ interface{}) bool {
+		sc := sci.(*serverConn)
+		conn := debugConn{
+			Uptime:  time.Since(sc.start).Round(time.Second),
+			Pending: atomic.LoadInt64(&sc.pending),
+			start:   sc.start,
+		}
+		if sc.remoteAddr != nil {
+			conn.RemoteAddr = sc.remoteAddr.String()
+		}
+		conns = append(conns, conn)
+		return true
+	})
+	sort.Slice(conns, func(i, j int) bool { return conns[i].start.Before(conns[j].start) })
+	// the page grows with the services, compress it for the clients accepting gzip
+	var out io.Writer = w
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Vary", "Accept-Encoding")
+	if acceptsGzip(req) {
+		w.Header().Set("Content-Encoding", "gzip")
+		gz := gzip.NewWriter(w)
+		defer func() { _ = gz.Close() }()
+		out = gz
+	}
+	err := debug.Execute(out, struct {
+		Services []debugService
+		Aliases  []debugAlias
+		Conns    []debugConn
+	}{services, aliases, conns})
 	if err != nil {
-		_, _ = fmt.Fprintln(w, "rpc: error executing template:", err.Error())
+		_, _ = fmt.Fprintln(out, "rpc: error executing template:", err.Error())
+	}
+}
+
+// acceptsGzip reports whether the Accept-Encoding header of req lists gzip,
+// and doesn't refuse it by q=0.
+func acceptsGzip(req *http.Request) bool {
+	for _, enc := range strings.Split(req.Header.Get("Accept-Encoding"), ",") {
+		params := strings.Split(enc, ";")
+		if name := strings.TrimSpace(params[0]); name != "gzip" && name != "*" {
+			continue
+		}
+		refused := false
+		for _, param := range params[1:] {
+			if q := strings.Replace(param, " ", "", -1); strings.HasPrefix(q, "q=0") && strings.Trim(q[3:], ".0") == "" {
+				refused = true
+			}
+		}
+		return !refused
+	}
+	return false
+}
+
+// redact formats v for logs and the debug page like fmt's %+v verb,
+// but the struct fields tagged with `geerpc:"sensitive"` are masked as "***".
+// It only affects human-facing output, the wire encoding is left untouched.
+func redact(v interface{}) string {
+	var b strings.Builder
+	writeRedacted(&b, reflect.ValueOf(v))
+	return b.String()
+}
+
+func writeRedacted(b *strings.Builder, v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Invalid:
+		b.WriteString("<nil>")
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			b.WriteString("<nil>")
+			return
+		}
+		if v.Kind() == reflect.Ptr {
+			b.WriteByte('&')
+		}
+		writeRedacted(b, v.Elem())
+	case reflect.Struct:
+		t := v.Type()
+		b.WriteByte('{')
+		for i := 0; i < v.NumField(); i++ {
+			if i > 0 {
+				b.WriteByte(' ')
+			}
+			b.WriteString(t.Field(i).Name + ":")
+			if isSensitive(t.Field(i)) {
+				b.WriteString("***")
+				continue
+			}
+			writeRedacted(b, v.Field(i))
+		}
+		b.WriteByte('}')
+	case reflect.Slice, reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			_, _ = fmt.Fprint(b, v)
+			return
+		}
+		b.WriteByte('[')
+		for i := 0; i < v.Len(); i++ {
+			if i > 0 {
+				b.WriteByte(' ')
+			}
+			writeRedacted(b, v.Index(i))
+		}
+		b.WriteByte(']')
+	case reflect.Map:
+		// sort keys to keep the output stable, the same as fmt does
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool {
+			return fmt.Sprint(keys[i]) < fmt.Sprint(keys[j])
+		})
+		b.WriteString("map[")
+		for i, key := range keys {
+			if i > 0 {
+				b.WriteByte(' ')
+			}
+			writeRedacted(b, key)
+			b.WriteByte(':')
+			writeRedacted(b, v.MapIndex(key))
+		}
+		b.WriteByte(']')
+	default:
+		_, _ = fmt.Fprint(b, v)
+	}
+}
+
+func isSensitive(f reflect.StructField) bool {
+	for _, opt := range strings.Split(f.Tag.Get("geerpc"), ",") {
+		if opt == "sensitive" {
+			return true
+		}
 	}
+	return false
 }
@@ -0,0 +1,109 @@
+package geerpc
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+type Account struct {
+	Name     string
+	Password string `geerpc:"sensitive"`
+}
+
+type Vault int
+
+func (v Vault) Echo(args Account, reply *Account) error {
+	*reply = args
+	return nil
+}
+
+func TestRedact(t *testing.T) {
+	acc := &Account{Name: "geektutu", Password: "123456"}
+	_assert(redact(acc) == "&{Name:geektutu Password:***}", "unexpected output %s", redact(acc))
+	_assert(redact([]Account{*acc}) == "[{Name:geektutu Password:***}]", "unexpected output %s", redact([]Account{*acc}))
+	m := map[string]*Account{"a": acc}
+	_assert(redact(m) == "map[a:&{Name:geektutu Password:***}]", "unexpected output %s", redact(m))
+	_assert(redact(nil) == "<nil>", "unexpected output %s", redact(nil))
+}
+
+func TestServer_LogPayload(t *testing.T) {
+	server := NewServer()
+	server.LogPayload = true
+	var v Vault
+	_ = server.Register(&v)
+	client, _ := Dial("tcp", startTestServer(server))
+	defer func() { _ = client.Close() }()
+
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	var reply Account
+	err := client.Call(context.Background(), "Vault.Echo", &Account{Name: "geektutu", Password: "123456"}, &reply)
+	log.SetOutput(os.Stderr)
+
+	_assert(err == nil && reply.Password == "123456", "password should be sent intact on the wire")
+	out := buf.String()
+	_assert(strings.Contains(out, "Password:***"), "password should be masked in logs: %s", out)
+	_assert(!strings.Contains(out, "123456"), "password leaked in logs: %s", out)
+}
+
+func TestDebugHTTP_Conns(t *testing.T) {
+	server := NewServer()
+	var s Sleeper
+	_ = server.Register(&s)
+	conn, _ := net.Dial("tcp", startTestServer(server))
+	client, _ := NewClient(conn, DefaultOption)
+	defer func() { _ = client.Close() }()
+
+	var reply int
+	call := client.Go("Sleeper.Sleep", 200, &reply, nil)
+	time.Sleep(time.Millisecond * 50)
+	w := httptest.NewRecorder()
+	debugHTTP{server}.ServeHTTP(w, httptest.NewRequest("GET", defaultDebugPath, nil))
+	<-call.Done
+
+	body := w.Body.String()
+	row := "<td align=left font=fixed>" + conn.LocalAddr().String() + "</td>"
+	i := strings.Index(body, row)
+	_assert(i >= 0, "expect the connection on the debug page: %s", body)
+	tr := body[i : i+strings.Index(body[i:], "</tr>")]
+	_assert(strings.Contains(tr, "<td align=center>1</td>"), "expect 1 pending request: %s", tr)
+}
+
+func TestDebugHTTP_Gzip(t *testing.T) {
+	server := NewServer()
+	var foo Foo
+	_ = server.Register(&foo)
+	get := func(acceptEncoding string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", defaultDebugPath, nil)
+		if acceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", acceptEncoding)
+		}
+		w := httptest.NewRecorder()
+		debugHTTP{server}.ServeHTTP(w, req)
+		return w
+	}
+
+	plain := get("")
+	_assert(plain.Header().Get("Content-Encoding") == "", "expect plain HTML without Accept-Encoding")
+	_assert(strings.Contains(plain.Body.String(), "Service Foo"), "expect Foo on the debug page: %s", plain.Body)
+	refused := get("gzip;q=0, identity")
+	_assert(refused.Header().Get("Content-Encoding") == "", "expect plain HTML if gzip is refused")
+
+	w := get("deflate, gzip;q=0.8")
+	_assert(w.Header().Get("Content-Encoding") == "gzip", "expect the page gzip encoded")
+	_assert(w.Header().Get("Content-Type") == "text/html; charset=utf-8", "unexpected content type %s", w.Header().Get("Content-Type"))
+	zr, err := gzip.NewReader(w.Body)
+	_assert(err == nil, "failed to read gzip: %v", err)
+	body, err := ioutil.ReadAll(zr)
+	_assert(err == nil, "failed to decompress the page: %v", err)
+	_assert(string(body) == plain.Body.String(), "expect the same page decompressed: %s", body)
+}
@@ -0,0 +1,127 @@
+package geerpc
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/gob"
+	"sync"
+	"time"
+)
+
+// DedupClient coalesces concurrent identical calls of idempotent methods
+// into one request and shares its reply, like singleflight.
+// Calls of other methods go to the Client as they are.
+type DedupClient struct {
+	*Client
+	idempotent map[string]bool
+	ttl        time.Duration
+	mu         sync.Mutex // protect following
+	flights    map[dedupKey]*flight
+	swept      time.Time // when expired flights were last removed
+}
+
+// dedupKey identifies identical calls by the method and the hash of the encoded args
+type dedupKey struct {
+	serviceMethod string
+	argsHash      [sha256.Size]byte
+}
+
+// flight is a call shared by the identical calls
+type flight struct {
+	done   chan struct{} // closed when the call completes
+	reply  []byte        // gob encoded reply
+	err    error
+	expire time.Time
+}
+
+// NewDedupClient wraps client to coalesce the calls of the idempotent methods,
+// in the format "<service>.<method>". The reply of a call is shared by the
+// identical calls in flight, and by those made within ttl after it completes.
+// ttl 0 means the reply isn't kept at all.
+func NewDedupClient(client *Client, ttl time.Duration, idempotent ...string) *DedupClient {
+	dc := &DedupClient{
+		Client:     client,
+		idempotent: make(map[string]bool),
+		ttl:        ttl,
+		flights:    make(map[dedupKey]*flight),
+	}
+	for _, method := range idempotent {
+		dc.idempotent[method] = true
+	}
+	return dc
+}
+
+// Call is like Client.Call, but an identical call in flight is joined instead
+// of sending another request. The joined calls share the fate of the call
+// which sent the request, including its cancellation.
+func (dc *DedupClient) Call(ctx context.Context, serviceMethod string, args, reply interface{}) error {
+	if !dc.idempotent[serviceMethod] {
+		return dc.Client.Call(ctx, serviceMethod, args, reply)
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(args); err != nil {
+		return err
+	}
+	key := dedupKey{serviceMethod, sha256.Sum256(buf.Bytes())}
+
+	dc.mu.Lock()
+	now := time.Now()
+	f, ok := dc.flights[key]
+	if ok && !f.expire.IsZero() && now.After(f.expire) {
+		delete(dc.flights, key)
+		ok = false
+	}
+	if !ok {
+		dc.sweep(now)
+		f = &flight{done: make(chan struct{})}
+		dc.flights[key] = f
+	}
+	dc.mu.Unlock()
+
+	if !ok {
+		dc.do(ctx, key, f, serviceMethod, args, reply)
+		return f.err
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-f.done:
+	}
+	if f.err != nil {
+		return f.err
+	}
+	return gob.NewDecoder(bytes.NewReader(f.reply)).Decode(reply)
+}
+
+// sweep removes the expired flights, so that replies of args never asked
+// again don't pile up. It runs at most once per ttl, dc.mu must be held.
+func (dc *DedupClient) sweep(now time.Time) {
+	if dc.ttl <= 0 || now.Sub(dc.swept) < dc.ttl {
+		return
+	}
+	dc.swept = now
+	for key, f := range dc.flights {
+		if !f.expire.IsZero() && now.After(f.expire) {
+			delete(dc.flights, key)
+		}
+	}
+}
+
+// do sends the request of f and shares the reply
+func (dc *DedupClient) do(ctx context.Context, key dedupKey, f *flight, serviceMethod string, args, reply interface{}) {
+	f.err = dc.Client.Call(ctx, serviceMethod, args, reply)
+	if f.err == nil {
+		var buf bytes.Buffer
+		f.err = gob.NewEncoder(&buf).Encode(reply)
+		f.reply = buf.Bytes()
+	}
+	dc.mu.Lock()
+	if f.err != nil || dc.ttl <= 0 {
+		delete(dc.flights, key)
+	} else {
+		f.expire = time.Now().Add(dc.ttl)
+	}
+	dc.mu.Unlock()
+	close(f.done)
+}
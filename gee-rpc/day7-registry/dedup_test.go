@@ -0,0 +1,86 @@
+package geerpc
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Store counts the requests it receives
+type Store struct{ requests int64 }
+
+func (s *Store) Get(key int, reply *int) error {
+	atomic.AddInt64(&s.requests, 1)
+	time.Sleep(time.Millisecond * 100)
+	*reply = key * 10
+	return nil
+}
+
+func (s *Store) Incr(key int, reply *int) error {
+	*reply = int(atomic.AddInt64(&s.requests, 1))
+	return nil
+}
+
+func TestDedupClient(t *testing.T) {
+	server := NewServer()
+	store := &Store{}
+	_ = server.Register(store)
+	client, _ := Dial("tcp", startTestServer(server))
+	defer func() { _ = client.Close() }()
+	dc := NewDedupClient(client, 0, "Store.Get")
+
+	var wg sync.WaitGroup
+	replies := make([]int, 5)
+	for i := range replies {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_ = dc.Call(context.Background(), "Store.Get", 1, &replies[i])
+		}(i)
+	}
+	wg.Wait()
+	_assert(atomic.LoadInt64(&store.requests) == 1, "expect 1 wire request, got %d", store.requests)
+	for i, reply := range replies {
+		_assert(reply == 10, "expect call %d to share the reply, got %d", i, reply)
+	}
+
+	// without ttl the reply isn't kept after the call
+	var reply int
+	_ = dc.Call(context.Background(), "Store.Get", 1, &reply)
+	_assert(atomic.LoadInt64(&store.requests) == 2, "expect a new request after the call completes, got %d", store.requests)
+
+	// methods not marked idempotent don't participate
+	_ = dc.Call(context.Background(), "Store.Incr", 1, &reply)
+	_ = dc.Call(context.Background(), "Store.Incr", 1, &reply)
+	_assert(atomic.LoadInt64(&store.requests) == 4, "expect every Store.Incr sent, got %d", store.requests)
+}
+
+func TestDedupClient_TTL(t *testing.T) {
+	server := NewServer()
+	store := &Store{}
+	_ = server.Register(store)
+	client, _ := Dial("tcp", startTestServer(server))
+	defer func() { _ = client.Close() }()
+	dc := NewDedupClient(client, time.Millisecond*200, "Store.Get")
+
+	var reply int
+	_ = dc.Call(context.Background(), "Store.Get", 1, &reply)
+	_ = dc.Call(context.Background(), "Store.Get", 1, &reply)
+	_assert(reply == 10 && atomic.LoadInt64(&store.requests) == 1, "expect the reply kept within ttl, got %d requests", store.requests)
+	_ = dc.Call(context.Background(), "Store.Get", 2, &reply)
+	_assert(reply == 20 && atomic.LoadInt64(&store.requests) == 2, "expect different args sent, got %d requests", store.requests)
+
+	time.Sleep(time.Millisecond * 200)
+	_ = dc.Call(context.Background(), "Store.Get", 1, &reply)
+	_assert(atomic.LoadInt64(&store.requests) == 3, "expect a new request after ttl, got %d", store.requests)
+
+	// the expired replies of args not asked again are removed too
+	time.Sleep(time.Millisecond * 300)
+	_ = dc.Call(context.Background(), "Store.Get", 3, &reply)
+	dc.mu.Lock()
+	n := len(dc.flights)
+	dc.mu.Unlock()
+	_assert(n == 1, "expect only the last flight kept, got %d", n)
+}
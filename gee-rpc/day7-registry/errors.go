@@ -0,0 +1,165 @@
+package geerpc
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"geerpc/codec"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"sync"
+)
+
+// Code classifies the error returned by a method
+type Code int
+
+const (
+	Unknown Code = iota
+	InvalidArgument
+	NotFound
+	Unauthenticated
+	PermissionDenied
+	ResourceExhausted
+	DeadlineExceeded
+	Unavailable
+	Internal
+)
+
+var codeNames = map[Code]string{
+	Unknown:           "Unknown",
+	InvalidArgument:   "InvalidArgument",
+	NotFound:          "NotFound",
+	Unauthenticated:   "Unauthenticated",
+	PermissionDenied:  "PermissionDenied",
+	ResourceExhausted: "ResourceExhausted",
+	DeadlineExceeded:  "DeadlineExceeded",
+	Unavailable:       "Unavailable",
+	Internal:          "Internal",
+}
+
+func (c Code) String() string {
+	if name, ok := codeNames[c]; ok {
+		return name
+	}
+	return fmt.Sprintf("Code(%d)", int(c))
+}
+
+// RPCError is an error carrying a Code and where it's created. Methods
+// return it to tell what kind of failure happens, client gets it back as an
+// *RPCError if the error returned by the method has a Code other than Unknown,
+// or a location annotated by WithStack.
+type RPCError struct {
+	Code     Code
+	Message  string
+	Location string // file:line, see WithStack
+}
+
+func (e *RPCError) Error() string {
+	if e.Code == Unknown {
+		return e.Message
+	}
+	return e.Code.String() + ": " + e.Message
+}
+
+// Errorf returns an *RPCError with code and the formatted message
+func Errorf(code Code, format string, a ...interface{}) error {
+	return &RPCError{Code: code, Message: fmt.Sprintf(format, a...)}
+}
+
+// IsResourceExhausted reports whether err is an *RPCError of code
+// ResourceExhausted, e.g. a request rejected by a limit of the server,
+// so that the client may retry later.
+func IsResourceExhausted(err error) bool {
+	var rpcErr *RPCError
+	return errors.As(err, &rpcErr) && rpcErr.Code == ResourceExhausted
+}
+
+// RegisterError registers the concrete type of err, so that an error of the
+// type returned by a method is sent as a value over the gob codecs rather
+// than as its message, and the client gets a value of the type back, e.g.
+// to type-assert it or to read its fields. Like RegisterGobType, both client
+// and server must register it. Only the error returned as it is, not wrapped,
+// is sent as a value.
+func RegisterError(err error) {
+	gob.Register(err)
+	errorTypes.Store(reflect.TypeOf(err), struct{}{})
+}
+
+// errorTypes are the types registered by RegisterError, reflect.Type -> struct{}
+var errorTypes sync.Map
+
+// isRegisteredError reports whether err is of a type registered by RegisterError
+func isRegisteredError(err error) bool {
+	_, ok := errorTypes.Load(reflect.TypeOf(err))
+	return ok
+}
+
+// errorBody is the body of a response carrying the error of a method
+// registered by RegisterError, the interface makes gob send its type
+type errorBody struct {
+	Err error
+}
+
+// headerError returns the error of a response in h, it's an *RPCError if
+// it has a code or a location
+func headerError(h *codec.Header) error {
+	if h.Code != 0 || h.Location != "" {
+		return &RPCError{Code: Code(h.Code), Message: h.Error, Location: h.Location}
+	}
+	return ServerError(h.Error)
+}
+
+// setError sets the error of a response in h, the code and the message
+// of an *RPCError are sent apart so that client rebuilds it
+func setError(h *codec.Header, err error) {
+	h.Error = err.Error()
+	h.Location = errorLocation(err)
+	var rpcErr *RPCError
+	if errors.As(err, &rpcErr) {
+		h.Code = int(rpcErr.Code)
+		if h.Location == "" {
+			h.Location = rpcErr.Location
+		}
+		// not wrapped with more context, the code is not repeated in the message
+		if h.Error == rpcErr.Error() {
+			h.Error = rpcErr.Message
+		}
+	}
+}
+
+// stackError is an error which knows where it's created
+type stackError struct {
+	err      error
+	location string
+}
+
+func (e *stackError) Error() string { return e.err.Error() }
+func (e *stackError) Unwrap() error { return e.err }
+
+// WithStack annotates err with the file:line calling WithStack, e.g.
+//
+//	return geerpc.WithStack(err)
+//
+// If a method returns it, even wrapped, the location is sent to client
+// along with the error, which client gets as an *RPCError.
+// It returns nil if err is nil.
+func WithStack(err error) error {
+	if err == nil {
+		return nil
+	}
+	location := "unknown"
+	if _, file, line, ok := runtime.Caller(1); ok {
+		location = fmt.Sprintf("%s:%d", filepath.Base(file), line)
+	}
+	return &stackError{err: err, location: location}
+}
+
+// errorLocation returns the location of err annotated by WithStack, empty if it's not
+func errorLocation(err error) string {
+	var se *stackError
+	if errors.As(err, &se) {
+		return se.location
+	}
+	return ""
+}
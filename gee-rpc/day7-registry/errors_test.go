@@ -0,0 +1,121 @@
+package geerpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"geerpc/codec"
+	"strings"
+	"testing"
+)
+
+type Faulty int
+
+var errFaulty = errors.New("something went wrong")
+
+func (f Faulty) Fail(wrapped bool, reply *int) error {
+	err := WithStack(errFaulty) // the location expected
+	if wrapped {
+		return fmt.Errorf("faulty: %w", err)
+	}
+	return err
+}
+
+func (f Faulty) Plain(args int, reply *int) error {
+	return errFaulty
+}
+
+func TestWithStack(t *testing.T) {
+	_assert(WithStack(nil) == nil, "expect WithStack(nil) to be nil")
+	_assert(errors.Is(WithStack(errFaulty), errFaulty), "expect WithStack to wrap the error")
+
+	server := NewServer()
+	var f Faulty
+	_ = server.Register(&f)
+	client, _ := Dial("tcp", startTestServer(server))
+	defer func() { _ = client.Close() }()
+
+	var reply int
+	for _, wrapped := range []bool{false, true} {
+		err := client.Call(context.Background(), "Faulty.Fail", wrapped, &reply)
+		rpcErr, ok := err.(*RPCError)
+		_assert(ok, "expect an *RPCError, got %T: %v", err, err)
+		_assert(strings.HasSuffix(rpcErr.Message, errFaulty.Error()), "unexpected message %q", rpcErr.Message)
+		_assert(strings.HasPrefix(rpcErr.Location, "errors_test.go:"), "expect the location in errors_test.go, got %q", rpcErr.Location)
+		_assert(IsServerError(err), "expect an *RPCError to be a server error")
+	}
+
+	err := client.Call(context.Background(), "Faulty.Plain", 0, &reply)
+	_, ok := err.(ServerError)
+	_assert(ok, "expect a ServerError without WithStack, got %T", err)
+}
+
+func (f Faulty) Missing(key string, reply *int) error {
+	return WithStack(Errorf(NotFound, "no such key %s", key))
+}
+
+func TestRPCError_Code(t *testing.T) {
+	server := NewServer()
+	var f Faulty
+	_ = server.Register(&f)
+	client, _ := Dial("tcp", startTestServer(server))
+	defer func() { _ = client.Close() }()
+
+	err := client.Call(context.Background(), "Faulty.Missing", "foo", new(int))
+	rpcErr, ok := err.(*RPCError)
+	_assert(ok, "expect an *RPCError, got %T: %v", err, err)
+	_assert(rpcErr.Code == NotFound && rpcErr.Message == "no such key foo", "expect the code and message kept, got %+v", rpcErr)
+	_assert(strings.HasPrefix(rpcErr.Location, "errors_test.go:"), "expect the location along with the code, got %q", rpcErr.Location)
+	_assert(err.Error() == "NotFound: no such key foo", "unexpected error string %q", err)
+}
+
+// InsufficientFunds is an error value sent to the client, see RegisterError
+type InsufficientFunds struct {
+	Need, Have int
+}
+
+func (e *InsufficientFunds) Error() string {
+	return fmt.Sprintf("insufficient funds: need %d, have %d", e.Need, e.Have)
+}
+
+type Bank int
+
+func (b Bank) Withdraw(amount int, reply *int) error {
+	if amount > 100 {
+		return &InsufficientFunds{Need: amount, Have: 100}
+	}
+	*reply = 100 - amount
+	return nil
+}
+
+func (b Bank) Transfer(amount int, reply *int) error {
+	return fmt.Errorf("transfer: %w", &InsufficientFunds{Need: amount, Have: 100})
+}
+
+func TestRegisterError(t *testing.T) {
+	RegisterError(&InsufficientFunds{})
+	server := NewServer()
+	var b Bank
+	_ = server.Register(&b)
+	addr := startTestServer(server)
+	for _, codecType := range []codec.Type{codec.GobType, codec.GobFramedType, codec.GobBinaryHeaderType, codec.JsonType} {
+		client, _ := Dial("tcp", addr, &Option{CodecType: codecType})
+		var balance int
+		err := client.Call(context.Background(), "Bank.Withdraw", 150, &balance)
+		if codecType == codec.JsonType {
+			_, ok := err.(ServerError)
+			_assert(ok && err.Error() == "insufficient funds: need 150, have 100", "expect the message of the error over json, got %#v", err)
+		} else {
+			funds, ok := err.(*InsufficientFunds)
+			_assert(ok && funds.Need == 150 && funds.Have == 100, "expect the error value over %s, got %#v", codecType, err)
+		}
+		_assert(IsServerError(err), "expect a server error over %s", codecType)
+
+		err = client.Call(context.Background(), "Bank.Transfer", 150, &balance)
+		_, ok := err.(ServerError)
+		_assert(ok && err.Error() == "transfer: insufficient funds: need 150, have 100", "expect a wrapped error sent by its message over %s, got %#v", codecType, err)
+		err = client.Call(context.Background(), "Bank.Withdraw", 30, &balance)
+		_assert(err == nil && balance == 70, "expect the connection usable after an error value over %s: %v", codecType, err)
+		_ = client.Close()
+	}
+}
@@ -0,0 +1,121 @@
+package geerpc
+
+import (
+	"context"
+	"io"
+	"sync"
+)
+
+// FallbackClient is a client of a primary server and fallback servers of the
+// same services, tried in order, for simple high availability without load
+// balancing. A call goes to the first server which can be dialed, starting
+// with the primary every time, so that calls return to it once it's back.
+// A call of an idempotent method failed by the connection is retried on the
+// next server, calls of other methods return the error. See XClient for
+// load balancing across many servers.
+type FallbackClient struct {
+	addrs      []string // primary first, in the format of XDial
+	opt        *Option
+	idempotent map[string]bool
+	mu         sync.Mutex // protect following
+	clients    []*Client  // connected clients by addrs, nil if not dialed
+	closed     bool       // user has called Close
+}
+
+var _ io.Closer = (*FallbackClient)(nil)
+
+// NewFallbackClient returns a client of primary and fallbacks, the servers are
+// dialed on demand. idempotent are the methods safe to retry on another
+// server, in the format "<service>.<method>".
+func NewFallbackClient(primary string, fallbacks []string, opt *Option, idempotent ...string) *FallbackClient {
+	fc := &FallbackClient{
+		addrs:      append([]string{primary}, fallbacks...),
+		opt:        opt,
+		idempotent: make(map[string]bool),
+	}
+	fc.clients = make([]*Client, len(fc.addrs))
+	for _, method := range idempotent {
+		fc.idempotent[method] = true
+	}
+	return fc
+}
+
+// dial returns the connected client of the i-th server, it dials again if the
+// client is unavailable, e.g. its connection is lost.
+func (fc *FallbackClient) dial(i int) (*Client, error) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	if fc.closed {
+		return nil, ErrShutdown
+	}
+	if client := fc.clients[i]; client != nil && client.IsAvailable() {
+		return client, nil
+	}
+	if fc.clients[i] != nil {
+		fc.clients[i].Retire() // e.g. by MaxConnLifetime, its pending calls are drained
+		fc.clients[i] = nil
+	}
+	client, err := XDial(fc.addrs[i], fc.opt)
+	if err != nil {
+		return nil, err
+	}
+	fc.clients[i] = client
+	return client, nil
+}
+
+// drop closes the client of the i-th server if it's still broken, which may
+// not know it yet, so that it's dialed again by the next call
+func (fc *FallbackClient) drop(i int, broken *Client) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	if fc.clients[i] == broken {
+		_ = broken.Close()
+		fc.clients[i] = nil
+	}
+}
+
+// Call invokes the named function like Client.Call on the first server
+// available. A server which fails to be dialed is skipped whatever the
+// method is, since nothing is sent to it. The error of the last server
+// tried is returned if they all fail.
+func (fc *FallbackClient) Call(ctx context.Context, serviceMethod string, args, reply interface{}) error {
+	var err error
+	for i := range fc.addrs {
+		if i > 0 && ctx.Err() != nil {
+			return err
+		}
+		var client *Client
+		if client, err = fc.dial(i); err == ErrShutdown {
+			return err
+		} else if err != nil {
+			continue
+		}
+		err = client.Call(ctx, serviceMethod, args, reply)
+		if err == nil || IsServerError(err) || ctx.Err() != nil || !fc.idempotent[serviceMethod] {
+			return err
+		}
+		fc.drop(i, client)
+	}
+	return err
+}
+
+// Close closes the connections, no more calls can be made.
+func (fc *FallbackClient) Close() error {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	if fc.closed {
+		return ErrShutdown
+	}
+	fc.closed = true
+	var err error
+	for i, client := range fc.clients {
+		if client == nil {
+			continue
+		}
+		if closeErr := client.Close(); err == nil {
+			err = closeErr
+		}
+		fc.clients[i] = nil
+	}
+	return err
+}
@@ -0,0 +1,53 @@
+package geerpc
+
+import (
+	"context"
+	"net"
+	"testing"
+)
+
+// startNamedServer serves Whoami.Name replying name, Whoami.Crash is the same
+// unless crash is set, then the server crashes in the middle of it
+func startNamedServer(name string, crash bool) (addr string, l *killableListener) {
+	server := NewServer()
+	_ = server.RegisterFunc("Whoami.Name", func(args int, reply *string) error {
+		*reply = name
+		return nil
+	})
+	_ = server.RegisterFunc("Whoami.Crash", func(args int, reply *string) error {
+		if crash {
+			l.kill()
+		}
+		*reply = name
+		return nil
+	})
+	inner, _ := net.Listen("tcp", "127.0.0.1:0")
+	l = &killableListener{Listener: inner}
+	go server.Accept(l)
+	return "tcp@" + inner.Addr().String(), l
+}
+
+func TestFallbackClient(t *testing.T) {
+	down, _ := net.Listen("tcp", "127.0.0.1:0")
+	_ = down.Close()
+	first, _ := startNamedServer("first", true)
+	second, _ := startNamedServer("second", false)
+	fc := NewFallbackClient("tcp@"+down.Addr().String(), []string{first, second}, nil, "Whoami.Name", "Whoami.Crash")
+	defer func() { _ = fc.Close() }()
+
+	var reply string
+	err := fc.Call(context.Background(), "Whoami.Name", 0, &reply)
+	_assert(err == nil && reply == "first", "expect the call served by the first fallback, got %q: %v", reply, err)
+
+	// the first fallback crashes while handling, an idempotent call goes on with the next one
+	err = fc.Call(context.Background(), "Whoami.Crash", 0, &reply)
+	_assert(err == nil && reply == "second", "expect the call retried on the second fallback, got %q: %v", reply, err)
+	_ = fc.Close()
+
+	primary, _ := startNamedServer("primary", true)
+	fc = NewFallbackClient(primary, []string{second}, nil, "Whoami.Name")
+	err = fc.Call(context.Background(), "Whoami.Name", 0, &reply)
+	_assert(err == nil && reply == "primary", "expect the call served by the primary, got %q: %v", reply, err)
+	err = fc.Call(context.Background(), "Whoami.Crash", 0, &reply)
+	_assert(err != nil && !IsServerError(err), "expect a non-idempotent call not retried, got %q: %v", reply, err)
+}
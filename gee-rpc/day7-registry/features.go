@@ -0,0 +1,61 @@
+package geerpc
+
+import "geerpc/codec"
+
+// Feature is an optional feature of the protocol. Peers agree on the
+// features active on a connection by the handshake: client sends the
+// features it supports in Option.Features, server acknowledges with the
+// intersection of them and its own, so that a newer peer never uses a
+// feature an older one doesn't understand. A client takes a server which
+// doesn't acknowledge as an older one, with none of them, see
+// Option.AckTimeout.
+type Feature uint32
+
+const (
+	// FeatureFraming allows the codecs of length prefixed messages,
+	// e.g. codec.GobFramedType, the basic codec is used otherwise
+	FeatureFraming Feature = 1 << iota
+	// FeatureCompression allows Option.CompressRequest and Option.CompressResponse
+	FeatureCompression
+	// FeatureMetadata allows the request metadata, e.g. Option.ContextMetadata
+	FeatureMetadata
+	// FeatureCompressionDict allows Option.CompressionDict
+	FeatureCompressionDict
+
+	// AllFeatures are the features supported by this version
+	AllFeatures = FeatureFraming | FeatureCompression | FeatureMetadata | FeatureCompressionDict
+)
+
+// Has reports whether all features of g are in f
+func (f Feature) Has(g Feature) bool {
+	return f&g == g
+}
+
+// framedCodecs maps the codec types requiring FeatureFraming to their basic counterparts
+var framedCodecs = map[codec.Type]codec.Type{
+	codec.GobFramedType: codec.GobType,
+}
+
+// legacyAck is the Option an older server, which doesn't acknowledge, works
+// by: none of the features, so the basic codec and no body transform
+func legacyAck(opt *Option) *Option {
+	ack := *opt
+	ack.Features = 0
+	if basic, ok := framedCodecs[ack.CodecType]; ok {
+		ack.CodecType = basic
+	}
+	ack.BodyTransform = ""
+	return &ack
+}
+
+// negotiate sets the features of opt sent by a client to those active on
+// the connection, and falls back to a basic codec if framing isn't active
+func (server *Server) negotiate(opt *Option) {
+	opt.Features &= AllFeatures &^ server.DisabledFeatures
+	if basic, ok := framedCodecs[opt.CodecType]; ok && !opt.Features.Has(FeatureFraming) {
+		opt.CodecType = basic
+	}
+	if !opt.Features.Has(FeatureCompressionDict) {
+		opt.CompressionDict = nil
+	}
+}
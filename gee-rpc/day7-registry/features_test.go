@@ -0,0 +1,150 @@
+package geerpc
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/gob"
+	"encoding/json"
+	"geerpc/codec"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFeatures_Negotiation(t *testing.T) {
+	t.Run("without framing", func(t *testing.T) {
+		server := NewServer()
+		server.DisabledFeatures = FeatureFraming
+		var foo Foo
+		_ = server.Register(&foo)
+		client, err := Dial("tcp", startTestServer(server), &Option{CodecType: codec.GobFramedType})
+		_assert(err == nil, "failed to dial: %v", err)
+		defer func() { _ = client.Close() }()
+		_assert(client.opt.Features == AllFeatures&^FeatureFraming, "expect framing not active, got %b", client.opt.Features)
+		_assert(client.opt.CodecType == codec.GobType, "expect the basic codec, got %s", client.opt.CodecType)
+
+		var reply int
+		err = client.Call(context.Background(), "Foo.Sum", &Args{Num1: 1, Num2: 2}, &reply)
+		_assert(err == nil && reply == 3, "failed to call Foo.Sum: %v", err)
+	})
+	t.Run("basic protocol", func(t *testing.T) {
+		server := NewServer()
+		server.DisabledFeatures = AllFeatures
+		server.ContextMetadata = map[string]interface{}{"tenant": tenantKey{}}
+		var m Meta
+		_ = server.Register(&m)
+		client, err := Dial("tcp", startTestServer(server), &Option{
+			CodecType:       codec.GobFramedType,
+			CompressRequest: true,
+			ContextMetadata: map[interface{}]string{tenantKey{}: "tenant"},
+		})
+		_assert(err == nil, "failed to dial: %v", err)
+		defer func() { _ = client.Close() }()
+		_assert(client.opt.Features == 0, "expect no feature active, got %b", client.opt.Features)
+
+		var reply string
+		ctx := context.WithValue(context.Background(), tenantKey{}, "acme")
+		err = client.Call(ctx, "Meta.Tenant", 0, &reply)
+		_assert(err == nil && reply == "", "expect no request metadata sent, got %q: %v", reply, err)
+	})
+}
+
+func TestOption_CompressionDict(t *testing.T) {
+	server := NewServer()
+	var foo Foo
+	_ = server.Register(&foo)
+	addr := startTestServer(server)
+	var sample bytes.Buffer
+	_ = gob.NewEncoder(&sample).Encode(&Args{Num1: 1, Num2: 2})
+	// the largest dictionary fits in the Option
+	dict := append(bytes.Repeat([]byte{0}, maxCompressionDict-sample.Len()), sample.Bytes()...)
+	for _, handshake := range []HandshakeType{JSONHandshake, GobHandshake} {
+		opt := &Option{CompressRequest: true, CompressResponse: true, CompressionDict: dict, Handshake: handshake}
+		client, err := Dial("tcp", addr, opt)
+		_assert(err == nil, "failed to dial with a dictionary over the %s handshake: %v", handshake, err)
+		_assert(bytes.Equal(client.opt.CompressionDict, dict), "expect the dictionary acknowledged over the %s handshake", handshake)
+		var reply int
+		h, err := client.CallWithHeader(context.Background(), "Foo.Sum", &Args{Num1: 1, Num2: 2}, &reply)
+		_assert(err == nil && reply == 3 && h.Compressed, "failed to call Foo.Sum with a dictionary over the %s handshake: %v", handshake, err)
+		_ = client.Close()
+	}
+
+	// a server not supporting dictionaries compresses without
+	server.DisabledFeatures = FeatureCompressionDict
+	client, err := Dial("tcp", addr, &Option{CompressRequest: true, CompressResponse: true, CompressionDict: dict})
+	_assert(err == nil, "failed to dial: %v", err)
+	defer func() { _ = client.Close() }()
+	_assert(client.opt.CompressionDict == nil, "expect the dictionary dropped")
+	var reply int
+	h, err := client.CallWithHeader(context.Background(), "Foo.Sum", &Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil && reply == 3 && h.Compressed, "failed to call Foo.Sum without a dictionary: %v", err)
+
+	_, err = Dial("tcp", addr, &Option{CompressionDict: make([]byte, maxCompressionDict+1)})
+	_assert(err != nil, "expect a dictionary too large rejected")
+}
+
+// startLegacyServer serves Foo.Sum by the basic protocol, like a server
+// which reads the Option but doesn't acknowledge it. It acknowledges after
+// lateAck if it's set, like a newer server on a slow link.
+func startLegacyServer(lateAck time.Duration) string {
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer func() { _ = conn.Close() }()
+				br := bufio.NewReader(conn)
+				if _, err := br.ReadBytes('\n'); err != nil {
+					return
+				}
+				if lateAck > 0 {
+					time.Sleep(lateAck)
+					_ = json.NewEncoder(conn).Encode(&Option{MagicNumber: MagicNumber, CodecType: codec.GobType})
+				}
+				cc := codec.NewGobCodec(struct {
+					io.Reader
+					io.WriteCloser
+				}{br, conn})
+				for {
+					var h codec.Header
+					var args Args
+					if cc.ReadHeader(&h) != nil || cc.ReadBody(&args) != nil {
+						return
+					}
+					_ = cc.Write(&h, args.Num1+args.Num2)
+				}
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+func TestFeatures_LegacyServer(t *testing.T) {
+	addr := startLegacyServer(0)
+	client, err := Dial("tcp", addr, &Option{CodecType: codec.GobFramedType, CompressRequest: true, AckTimeout: 50 * time.Millisecond})
+	_assert(err == nil, "expect an older server not to fail dialing: %v", err)
+	defer func() { _ = client.Close() }()
+	_assert(client.opt.Features == 0, "expect no feature active, got %b", client.opt.Features)
+	_assert(client.opt.CodecType == codec.GobType, "expect the basic codec, got %s", client.opt.CodecType)
+	var reply int
+	err = client.Call(context.Background(), "Foo.Sum", &Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil && reply == 3, "failed to call Foo.Sum of an older server: %v", err)
+
+	// the transform can't be left out
+	RegisterBodyTransform("xor", xorTransform(0x5a))
+	_, err = Dial("tcp", addr, &Option{BodyTransform: "xor", AckTimeout: 50 * time.Millisecond})
+	_assert(err != nil && strings.Contains(err.Error(), "doesn't support body transform"), "expect a body transform refused by an older server, got %v", err)
+
+	// a late ack isn't taken as a response
+	client, err = Dial("tcp", startLegacyServer(100*time.Millisecond), &Option{AckTimeout: 20 * time.Millisecond})
+	_assert(err == nil, "failed to dial: %v", err)
+	defer func() { _ = client.Close() }()
+	err = client.Call(context.Background(), "Foo.Sum", &Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err != nil && strings.Contains(err.Error(), "after AckTimeout"), "expect the call failed by the late ack, got %v", err)
+}
@@ -0,0 +1,82 @@
+package geerpc
+
+import (
+	"errors"
+	"fmt"
+	"go/ast"
+	"reflect"
+	"strings"
+)
+
+// RegisterFunc publishes fn as the method name, of format "Service.Method",
+// without a receiver type to declare, e.g. for a one-off handler or a test.
+// fn must look like a method accepted by Register without the receiver:
+//
+//	func(args T, reply *R) error
+//	func(ctx context.Context, args T, reply *R) error
+//
+// The functions registered under the same Service make up a pseudo-service,
+// which can't be mixed with the methods of a type registered by Register.
+func (server *Server) RegisterFunc(name string, fn interface{}) error {
+	dot := strings.Index(name, ".")
+	if dot < 0 || !ast.IsExported(name[:dot]) || !ast.IsExported(name[dot+1:]) || strings.Contains(name[dot+1:], ".") {
+		return errors.New("rpc: can't register func " + name + ", expect an exported Service.Method name")
+	}
+	serviceName, methodName := name[:dot], name[dot+1:]
+	m, err := newFuncMethod(methodName, fn)
+	if err != nil {
+		return fmt.Errorf("rpc: can't register func %s: %v", name, err)
+	}
+	server.funcMu.Lock()
+	defer server.funcMu.Unlock()
+	s := &service{name: serviceName, method: map[string]*methodType{methodName: m}, funcs: true}
+	svci, loaded := server.serviceMap.LoadOrStore(serviceName, s)
+	if !loaded {
+		return nil
+	}
+	old := svci.(*service)
+	if !old.funcs {
+		return errors.New("rpc: service already defined: " + serviceName)
+	}
+	if old.method[methodName] != nil {
+		return errors.New("rpc: func already defined: " + name)
+	}
+	// a copy is stored, the service being served is never modified
+	for n, om := range old.method {
+		s.method[n] = om
+	}
+	server.serviceMap.Store(serviceName, s)
+	return nil
+}
+
+// RegisterFunc publishes fn as the method name in the DefaultServer.
+func RegisterFunc(name string, fn interface{}) error { return DefaultServer.RegisterFunc(name, fn) }
+
+// newFuncMethod returns the method of fn, or an error if fn isn't a function
+// of the signature accepted by RegisterFunc
+func newFuncMethod(name string, fn interface{}) (*methodType, error) {
+	v := reflect.ValueOf(fn)
+	if v.Kind() != reflect.Func || v.IsNil() {
+		return nil, fmt.Errorf("expect a func, got %T", fn)
+	}
+	t := v.Type()
+	numIn := t.NumIn()
+	withCtx := numIn == 3 && t.In(0) == typeOfContext
+	if (numIn != 2 && !withCtx) || t.NumOut() != 1 || t.Out(0) != typeOfError {
+		return nil, fmt.Errorf("expect a func(args T, reply *R) error, optionally with a context.Context first, got %s", t)
+	}
+	argType, replyType := t.In(numIn-2), t.In(numIn-1)
+	if !isExportedOrBuiltinType(argType) || !isExportedOrBuiltinType(replyType) {
+		return nil, fmt.Errorf("args and reply of %s must be of exported types", t)
+	}
+	if err := checkKinds(argType, replyType); err != nil {
+		return nil, err
+	}
+	return &methodType{
+		method:    reflect.Method{Name: name, Type: t, Func: v},
+		ArgType:   argType,
+		ReplyType: replyType,
+		withCtx:   withCtx,
+		fn:        true,
+	}, nil
+}
@@ -0,0 +1,49 @@
+package geerpc
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestServer_RegisterFunc(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	_assert(server.RegisterFunc("Math.Add", func(args Args, reply *int) error {
+		*reply = args.Num1 + args.Num2
+		return nil
+	}) == nil, "failed to register Math.Add")
+	_assert(server.RegisterFunc("Math.Tenant", func(ctx context.Context, args int, reply *string) error {
+		*reply, _ = ctx.Value(tenantKey{}).(string)
+		return nil
+	}) == nil, "failed to register Math.Tenant")
+	server.ContextMetadata = map[string]interface{}{"tenant": tenantKey{}}
+
+	for name, fn := range map[string]interface{}{
+		"Math.Add":  func(args Args, reply *int) error { return nil },
+		"Math":      func(args Args, reply *int) error { return nil },
+		"math.Add":  func(args Args, reply *int) error { return nil },
+		"Math.Sub":  "not a func",
+		"Math.Mul":  func(args Args, reply int) error { return nil },
+		"Math.Div":  func(args Args, reply *int) {},
+		"Math.Pipe": func(args chan int, reply *int) error { return nil },
+	} {
+		_assert(server.RegisterFunc(name, fn) != nil, "expect %s rejected", name)
+	}
+	var foo Foo
+	_ = server.Register(&foo)
+	err := server.RegisterFunc("Foo.Add", func(args Args, reply *int) error { return nil })
+	_assert(err != nil && strings.Contains(err.Error(), "already defined"), "expect a func rejected on a registered type, got %v", err)
+
+	client, _ := Dial("tcp", startTestServer(server), &Option{
+		ContextMetadata: map[interface{}]string{tenantKey{}: "tenant"},
+	})
+	defer func() { _ = client.Close() }()
+	var sum int
+	err = client.Call(context.Background(), "Math.Add", Args{Num1: 1, Num2: 2}, &sum)
+	_assert(err == nil && sum == 3, "failed to call Math.Add: %v", err)
+	var tenant string
+	ctx := context.WithValue(context.Background(), tenantKey{}, "acme")
+	err = client.Call(ctx, "Math.Tenant", 0, &tenant)
+	_assert(err == nil && tenant == "acme", "failed to call Math.Tenant with its context: %v", err)
+}
@@ -0,0 +1,112 @@
+package geerpc
+
+import (
+	"encoding/gob"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// RegisterGobType registers the concrete type of v for gob, like gob.Register.
+// gob sends a value held by an interface, e.g. a reply of type *interface{}
+// or a field of type interface{} or error, along with the name of its
+// concrete type, so the type must be registered by both client and server
+// before such a value is sent. Otherwise the call fails with an error naming it.
+func RegisterGobType(v interface{}) {
+	gob.Register(v)
+}
+
+// interfaceTypes caches holdsInterface, reflect.Type -> bool
+var interfaceTypes sync.Map
+
+// holdsInterface reports whether a value of t may hold an interface value
+func holdsInterface(t reflect.Type) bool {
+	if v, ok := interfaceTypes.Load(t); ok {
+		return v.(bool)
+	}
+	v := walkInterface(t, make(map[reflect.Type]bool))
+	interfaceTypes.Store(t, v)
+	return v
+}
+
+func walkInterface(t reflect.Type, visiting map[reflect.Type]bool) bool {
+	if visiting[t] {
+		return false
+	}
+	visiting[t] = true
+	switch t.Kind() {
+	case reflect.Interface:
+		return true
+	case reflect.Ptr, reflect.Slice, reflect.Array:
+		return walkInterface(t.Elem(), visiting)
+	case reflect.Map:
+		return walkInterface(t.Key(), visiting) || walkInterface(t.Elem(), visiting)
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			if f := t.Field(i); f.PkgPath == "" && walkInterface(f.Type, visiting) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// unregisteredType returns the concrete type of an interface value in v
+// which gob fails to encode since it isn't registered, nil if there's none
+func unregisteredType(v reflect.Value) reflect.Type {
+	switch v.Kind() {
+	case reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		if t := unregisteredType(v.Elem()); t != nil {
+			return t
+		}
+		holder := struct{ V interface{} }{v.Interface()}
+		if err := gob.NewEncoder(ioutil.Discard).Encode(holder); err != nil && isUnregistered(err) {
+			return v.Elem().Type()
+		}
+	case reflect.Ptr:
+		if !v.IsNil() {
+			return unregisteredType(v.Elem())
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if t := unregisteredType(v.Index(i)); t != nil {
+				return t
+			}
+		}
+	case reflect.Map:
+		iter := v.MapRange()
+		for iter.Next() {
+			if t := unregisteredType(iter.Value()); t != nil {
+				return t
+			}
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).PkgPath == "" {
+				if t := unregisteredType(v.Field(i)); t != nil {
+					return t
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// isUnregistered reports whether err is a gob error of an unregistered type,
+// encoding and decoding report it in different words
+func isUnregistered(err error) bool {
+	return strings.Contains(err.Error(), "not registered for interface")
+}
+
+// gobTypeError explains err of gob encoding v, what is "args" or "reply"
+func gobTypeError(what string, v interface{}, err error) error {
+	if t := unregisteredType(reflect.ValueOf(v)); t != nil {
+		return fmt.Errorf("can't encode %s of type %T: type %s held by an interface isn't registered, see RegisterGobType", what, v, t)
+	}
+	return fmt.Errorf("can't encode %s of type %T: %v", what, v, err)
+}
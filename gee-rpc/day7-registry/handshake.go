@@ -0,0 +1,219 @@
+package geerpc
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// HandshakeType is the encoding of the Option exchanged when a connection is
+// set up, before the codec of the bodies is known. A connection bootstraps by
+//
+//  1. client sends the Option encoded by Option.Handshake
+//  2. server recognizes the handshake type by the first byte, decodes the
+//     Option and chooses the codec, see Server.FallbackCodec
+//  3. server acknowledges with the Option chosen, in the same handshake type
+//  4. both switch to the codec of the acknowledged Option.CodecType
+//
+// An older server doesn't acknowledge, a client which gets no ack within
+// Option.AckTimeout speaks the basic protocol of the codec it asked for.
+//
+// Nothing sent after the Option is lost, a client may write requests before
+// the acknowledgement arrives.
+type HandshakeType string
+
+const (
+	// JSONHandshake is a line of JSON, the default
+	JSONHandshake HandshakeType = "json"
+	// GobHandshake is gobHandshakeMarker, then the length of the
+	// encoded Option as a uvarint, then the Option encoded by gob
+	GobHandshake HandshakeType = "gob"
+)
+
+// gobHandshakeMarker starts a gob handshake, a JSON Option starts with '{'
+const gobHandshakeMarker = 'G'
+
+var errOptionTooLarge = errors.New("option is too large")
+
+// writeOption sends opt encoded by the handshake type t
+func writeOption(w io.Writer, t HandshakeType, opt *Option) error {
+	switch t {
+	case "", JSONHandshake:
+		return json.NewEncoder(w).Encode(opt)
+	case GobHandshake:
+		o := *opt
+		o.ContextMetadata = nil // local to client, and its keys aren't encodable
+		o.Backoff = nil         // local to client, and of any type
+		var buf bytes.Buffer
+		if err := gob.NewEncoder(&buf).Encode(&o); err != nil {
+			return err
+		}
+		var size [binary.MaxVarintLen64]byte
+		b := append([]byte{gobHandshakeMarker}, size[:binary.PutUvarint(size[:], uint64(buf.Len()))]...)
+		_, err := w.Write(append(b, buf.Bytes()...))
+		return err
+	}
+	return fmt.Errorf("invalid handshake type %s", t)
+}
+
+// byteReader reads one byte at a time, so that nothing after the Option is consumed
+type byteReader struct{ io.Reader }
+
+func (r byteReader) ReadByte() (byte, error) {
+	var b [1]byte
+	_, err := io.ReadFull(r.Reader, b[:])
+	return b[0], err
+}
+
+// readOptionAs reads an Option encoded by the handshake type t,
+// nothing after the Option is consumed
+func readOptionAs(r io.Reader, t HandshakeType) (*Option, error) {
+	switch t {
+	case "", JSONHandshake:
+		return readOption(r)
+	case GobHandshake:
+		br := byteReader{r}
+		marker, err := br.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if marker != gobHandshakeMarker {
+			return nil, fmt.Errorf("invalid gob handshake marker %q", marker)
+		}
+		return readGobOption(br)
+	}
+	return nil, fmt.Errorf("invalid handshake type %s", t)
+}
+
+// readGobOption reads the length and the Option of a gob handshake after the marker
+func readGobOption(r io.ByteReader) (*Option, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if n > maxOptionSize {
+		return nil, errOptionTooLarge
+	}
+	b := make([]byte, n)
+	for i := range b {
+		if b[i], err = r.ReadByte(); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+	}
+	var opt Option
+	if err := gob.NewDecoder(bytes.NewReader(b)).Decode(&opt); err != nil {
+		return nil, err
+	}
+	return &opt, nil
+}
+
+// readHandshake reads the Option sent by client in any handshake type. rest
+// reads what's after the Option, including the bytes buffered by r.
+func readHandshake(r *bufio.Reader) (opt *Option, t HandshakeType, rest io.Reader, err error) {
+	b, err := r.Peek(1)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	if b[0] == gobHandshakeMarker {
+		_, _ = r.Discard(1)
+		opt, err = readGobOption(r)
+		return opt, GobHandshake, r, err
+	}
+	opt = new(Option)
+	dec := json.NewDecoder(io.LimitReader(r, maxOptionSize))
+	if err = dec.Decode(opt); err != nil {
+		return nil, JSONHandshake, nil, err
+	}
+	// json.Encoder terminates the Option with a newline in the same write,
+	// consume it before acknowledging, or a client writing to a synchronous
+	// transport like net.Pipe is blocked while the ack waits for it to read
+	br := bufio.NewReader(io.MultiReader(dec.Buffered(), r))
+	if nl, err := br.Peek(1); err == nil && nl[0] == '\n' {
+		_, _ = br.Discard(1)
+	}
+	return opt, JSONHandshake, br, nil
+}
+
+// defaultAckTimeout is how long client waits for the acknowledgement if
+// Option.AckTimeout is 0
+const defaultAckTimeout = 3 * time.Second
+
+var errLateAck = errors.New("rpc client: server acknowledged the options after AckTimeout")
+
+// readAck reads the Option acknowledged by server in the handshake type t.
+// A server which sends nothing within timeout is taken as an older one,
+// which never acknowledges, ack is nil then. The older servers only know
+// JSONHandshake, a client of another handshake type waits for the ack.
+func readAck(conn net.Conn, t HandshakeType, timeout time.Duration) (*Option, error) {
+	if timeout < 0 || (t != "" && t != JSONHandshake) {
+		return readOptionAs(conn, t)
+	}
+	if timeout == 0 {
+		timeout = defaultAckTimeout
+	}
+	r := &countingReader{Reader: conn}
+	_ = conn.SetReadDeadline(time.Now().Add(timeout))
+	ack, err := readOptionAs(r, t)
+	_ = conn.SetReadDeadline(time.Time{})
+	if err != nil && r.n == 0 && isTimeout(err) {
+		return nil, nil
+	}
+	return ack, err
+}
+
+// countingReader counts the bytes read
+type countingReader struct {
+	io.Reader
+	n int
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.n += n
+	return n, err
+}
+
+// jsonAckPrefix starts the JSON encoding of an Option, MagicNumber is its
+// first field, a response of any codec starts otherwise
+var jsonAckPrefix = []byte(`{"MagicNumber":`)
+
+// lateAckConn is the connection of a client which has taken the server as
+// an older one. Its first read fails with errLateAck if an acknowledgement
+// arrives after all, the client speaking the basic protocol can't switch to
+// the Option chosen by server anymore.
+type lateAckConn struct {
+	net.Conn
+	checked bool
+	head    []byte // read by the check, yet to be returned
+}
+
+func (c *lateAckConn) Read(p []byte) (int, error) {
+	if !c.checked {
+		c.checked = true
+		head := make([]byte, len(jsonAckPrefix))
+		n, err := io.ReadFull(c.Conn, head)
+		if bytes.Equal(head[:n], jsonAckPrefix) {
+			return 0, errLateAck
+		}
+		if n == 0 {
+			return 0, err
+		}
+		c.head = head[:n]
+	}
+	if len(c.head) > 0 {
+		n := copy(p, c.head)
+		c.head = c.head[n:]
+		return n, nil
+	}
+	return c.Conn.Read(p)
+}
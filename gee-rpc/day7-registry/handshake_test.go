@@ -0,0 +1,59 @@
+package geerpc
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"geerpc/codec"
+	"io/ioutil"
+	"net"
+	"testing"
+)
+
+// trickleConn reads a byte at a time, as if every byte arrived in its own packet
+type trickleConn struct{ net.Conn }
+
+func (c trickleConn) Read(p []byte) (int, error) {
+	if len(p) > 1 {
+		p = p[:1]
+	}
+	return c.Conn.Read(p)
+}
+
+func TestHandshake(t *testing.T) {
+	server := NewServer()
+	var f Foo
+	_ = server.Register(&f)
+	cases := []struct {
+		handshake HandshakeType
+		codecType codec.Type
+	}{
+		{JSONHandshake, codec.GobType},
+		{GobHandshake, codec.JsonType},
+		{GobHandshake, codec.GobType},
+	}
+	for _, c := range cases {
+		c1, c2 := net.Pipe()
+		go server.ServeConn(trickleConn{c1})
+		client, err := NewClient(trickleConn{c2}, &Option{MagicNumber: MagicNumber, CodecType: c.codecType, Handshake: c.handshake})
+		_assert(err == nil, "failed to handshake by %s: %v", c.handshake, err)
+		for i := 0; i < 3; i++ {
+			var reply int
+			err = client.Call(context.Background(), "Foo.Sum", Args{Num1: i, Num2: i}, &reply)
+			_assert(err == nil && reply == 2*i, "failed to call by %s handshake and %s body: %v", c.handshake, c.codecType, err)
+		}
+		_ = client.Close()
+	}
+}
+
+func TestReadHandshake_trailing(t *testing.T) {
+	for _, handshake := range []HandshakeType{JSONHandshake, GobHandshake} {
+		var buf bytes.Buffer
+		_ = writeOption(&buf, handshake, &Option{MagicNumber: MagicNumber, CodecType: codec.GobType})
+		buf.WriteString("body")
+		opt, typ, rest, err := readHandshake(bufio.NewReader(&buf))
+		_assert(err == nil && typ == handshake && opt.MagicNumber == MagicNumber, "failed to read the %s handshake: %v", handshake, err)
+		b, _ := ioutil.ReadAll(rest)
+		_assert(string(b) == "body", "expect the bytes after the %s Option kept, got %q", handshake, b)
+	}
+}
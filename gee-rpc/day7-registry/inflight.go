@@ -0,0 +1,59 @@
+package geerpc
+
+import (
+	"context"
+	"net"
+)
+
+// clientIdentity identifies the client of the call ctx belongs to,
+// by ClientIdentity or the IP of its address
+func (server *Server) clientIdentity(ctx context.Context) string {
+	if server.ClientIdentity != nil {
+		return server.ClientIdentity(ctx)
+	}
+	addr := RemoteAddr(ctx)
+	if addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
+// acquireInFlight counts a request of the client of ctx in, it returns false
+// if the client has MaxInFlightPerClient requests being handled already.
+// The identity returned is to be passed to releaseInFlight, "" if the request
+// isn't counted.
+func (server *Server) acquireInFlight(ctx context.Context) (string, bool) {
+	if server.MaxInFlightPerClient <= 0 {
+		return "", true
+	}
+	id := server.clientIdentity(ctx)
+	if id == "" {
+		return "", true
+	}
+	server.inFlightMu.Lock()
+	defer server.inFlightMu.Unlock()
+	if server.inFlight[id] >= server.MaxInFlightPerClient {
+		return id, false
+	}
+	if server.inFlight == nil {
+		server.inFlight = make(map[string]int)
+	}
+	server.inFlight[id]++
+	return id, true
+}
+
+// releaseInFlight counts a request acquired by acquireInFlight out
+func (server *Server) releaseInFlight(id string) {
+	if id == "" {
+		return
+	}
+	server.inFlightMu.Lock()
+	defer server.inFlightMu.Unlock()
+	if server.inFlight[id]--; server.inFlight[id] <= 0 {
+		delete(server.inFlight, id)
+	}
+}
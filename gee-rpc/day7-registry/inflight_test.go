@@ -0,0 +1,70 @@
+package geerpc
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServer_MaxInFlightPerClient(t *testing.T) {
+	server := NewServer()
+	server.MaxInFlightPerClient = 2
+	// both clients are on localhost, tell them apart by port
+	server.ClientIdentity = func(ctx context.Context) string { return RemoteAddr(ctx).String() }
+	var s Sleeper
+	_ = server.Register(&s)
+	addr := startTestServer(server)
+	greedy, _ := Dial("tcp", addr)
+	defer func() { _ = greedy.Close() }()
+	other, _ := Dial("tcp", addr)
+	defer func() { _ = other.Close() }()
+
+	calls := []*Call{
+		greedy.Go("Sleeper.Sleep", 300, new(int), nil),
+		greedy.Go("Sleeper.Sleep", 300, new(int), nil),
+	}
+	time.Sleep(100 * time.Millisecond)
+	var reply int
+	err := greedy.Call(context.Background(), "Sleeper.Sleep", 0, &reply)
+	_assert(IsResourceExhausted(err), "expect the call beyond the quota rejected, got %v", err)
+	err = other.Call(context.Background(), "Sleeper.Sleep", 0, &reply)
+	_assert(err == nil, "expect the call of another client to succeed, got %v", err)
+
+	for _, call := range calls {
+		<-call.Done
+		_assert(call.Error == nil, "expect the calls within the quota to succeed, got %v", call.Error)
+	}
+	// the quota is released right after the responses are sent
+	time.Sleep(50 * time.Millisecond)
+	err = greedy.Call(context.Background(), "Sleeper.Sleep", 0, &reply)
+	_assert(err == nil, "expect the quota released, got %v", err)
+}
+
+func TestServer_clientIdentity(t *testing.T) {
+	server := NewServer()
+	ctx := context.WithValue(context.Background(), remoteAddrKey, &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 7001})
+	_assert(server.clientIdentity(ctx) == "10.0.0.1", "expect the IP as the identity, got %q", server.clientIdentity(ctx))
+	_assert(server.clientIdentity(context.Background()) == "", "expect no identity without an address")
+}
+
+func TestServer_MaxInFlightPerClientHandleTimeout(t *testing.T) {
+	server := NewServer()
+	server.MaxInFlightPerClient = 1
+	_ = server.RegisterFunc("Slow.Run", func(args int, reply *int) error {
+		time.Sleep(200 * time.Millisecond) // ignores the timeout
+		return nil
+	})
+	client, _ := Dial("tcp", startTestServer(server), &Option{HandleTimeout: 20 * time.Millisecond})
+	defer func() { _ = client.Close() }()
+
+	err := client.Call(context.Background(), "Slow.Run", 0, new(int))
+	_assert(err != nil && strings.Contains(err.Error(), "handle timeout"), "expect the call timed out, got %v", err)
+	// the method timed out is still running, it keeps the quota
+	err = client.Call(context.Background(), "Slow.Run", 0, new(int))
+	_assert(IsResourceExhausted(err), "expect the call beyond the quota rejected, got %v", err)
+	time.Sleep(250 * time.Millisecond)
+	err = client.Call(context.Background(), "Slow.Run", 0, new(int))
+	_assert(!IsResourceExhausted(err), "expect the quota released once the method returns, got %v", err)
+}
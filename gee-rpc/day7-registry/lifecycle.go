@@ -0,0 +1,81 @@
+package geerpc
+
+import (
+	"fmt"
+	"io"
+	"log"
+)
+
+// Initializer is implemented by a receiver which needs setup before its
+// methods are called, e.g. to open a database pool. Register calls Init
+// before the service is published, and fails with its error. A receiver
+// registered more than once, e.g. under several namespaces, is initialized
+// by the first Register only.
+// A receiver may implement io.Closer as well to be torn down, its Close
+// is called once by Server.Shutdown after the connections are closed.
+// Neither of them is published as a method.
+type Initializer interface {
+	Init() error
+}
+
+// initService calls Init of the receiver of s if it has one
+func initService(s *service) error {
+	if i, ok := s.rcvr.Interface().(Initializer); ok {
+		if err := i.Init(); err != nil {
+			return fmt.Errorf("rpc: init service %s: %w", s.name, err)
+		}
+	}
+	return nil
+}
+
+// closeService calls Close of the receiver of s if it has one, the
+// services of RegisterFunc have no receiver
+func closeService(s *service) error {
+	if s.funcs {
+		return nil
+	}
+	if c, ok := s.rcvr.Interface().(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// published reports whether the receiver of s is registered already
+func (server *Server) published(s *service) (found bool) {
+	server.serviceMap.Range(func(_, svci interface{}) bool {
+		found = sameReceiver(svci.(*service), s)
+		return !found
+	})
+	return
+}
+
+// sameReceiver reports whether a and b are registered by the same receiver,
+// a receiver which isn't a pointer is copied, it's never the same
+func sameReceiver(a, b *service) bool {
+	return !a.funcs && !b.funcs && a.typ == b.typ && a.rcvr.Pointer() == b.rcvr.Pointer()
+}
+
+// closeServices closes the receivers of the registered services once, the
+// errors are logged and the first of them is returned
+func (server *Server) closeServices() (err error) {
+	server.closeOnce.Do(func() {
+		var closed []*service
+		server.serviceMap.Range(func(_, svci interface{}) bool {
+			s := svci.(*service)
+			for _, c := range closed {
+				if sameReceiver(c, s) {
+					return true // under another name
+				}
+			}
+			closed = append(closed, s)
+			if closeErr := closeService(s); closeErr != nil {
+				log.Printf("rpc server: close service %s error: %v", s.name, closeErr)
+				if err == nil {
+					err = fmt.Errorf("close service %s: %w", s.name, closeErr)
+				}
+			}
+			return true
+		})
+	})
+	return
+}
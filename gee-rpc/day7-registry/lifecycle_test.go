@@ -0,0 +1,85 @@
+package geerpc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Pool has to be opened by Init before it's queried, and is closed by Close
+type Pool struct {
+	opened, closed int
+	initErr        error
+}
+
+func (p *Pool) Init() error {
+	if p.initErr != nil {
+		return p.initErr
+	}
+	p.opened++
+	return nil
+}
+
+func (p *Pool) Close() error {
+	p.closed++
+	return nil
+}
+
+func (p *Pool) Query(ms int, reply *int) error {
+	if p.opened == 0 || p.closed > 0 {
+		return errors.New("pool isn't open")
+	}
+	time.Sleep(time.Duration(ms) * time.Millisecond)
+	*reply = ms
+	return nil
+}
+
+func TestServer_ServiceLifecycle(t *testing.T) {
+	server := NewServer()
+	pool := new(Pool)
+	_assert(server.Register(pool) == nil, "failed to register Pool")
+	_assert(pool.opened == 1 && pool.closed == 0, "expect Pool initialized once by Register, got opened %d closed %d", pool.opened, pool.closed)
+	_, mtype, _ := server.findService("Pool.Init")
+	_assert(mtype == nil, "expect Init not published as a method")
+
+	// a duplicate is refused before it's initialized
+	dup := new(Pool)
+	_assert(server.Register(dup) != nil, "expect the duplicate Pool refused")
+	_assert(dup.opened == 0 && dup.closed == 0, "expect the duplicate Pool untouched, got opened %d closed %d", dup.opened, dup.closed)
+	_assert(server.Register(pool) != nil, "expect Pool registered again refused")
+	_assert(pool.opened == 1 && pool.closed == 0, "expect Pool registered again untouched, got opened %d closed %d", pool.opened, pool.closed)
+	// the same receiver under another namespace is initialized only once
+	_assert(server.RegisterNamespaced("replica", pool) == nil, "failed to register Pool under a namespace")
+	_assert(pool.opened == 1, "expect Pool initialized once, got %d", pool.opened)
+
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	go server.Accept(l)
+	client, _ := Dial("tcp", l.Addr().String())
+	defer func() { _ = client.Close() }()
+	call := client.Go("Pool.Query", 50, new(int), nil)
+	time.Sleep(10 * time.Millisecond)
+	err := server.Shutdown(ShutdownOption{Drain: time.Second})
+	_assert(err == nil, "failed to shut down: %v", err)
+	<-call.Done
+	// Close is called once the in-flight call is drained
+	_assert(call.Error == nil, "expect the call drained before Pool is closed: %v", call.Error)
+	_assert(pool.closed == 1, "expect Pool closed once by Shutdown under both names, got %d", pool.closed)
+	_ = server.Shutdown(ShutdownOption{})
+	_assert(pool.closed == 1, "expect Pool closed only once, got %d", pool.closed)
+}
+
+func TestServer_ServiceInitError(t *testing.T) {
+	server := NewServer()
+	pool := &Pool{initErr: errors.New("database unreachable")}
+	err := server.Register(pool)
+	_assert(err != nil && strings.Contains(err.Error(), "database unreachable"), "expect the Init error of Pool, got %v", err)
+	_assert(errors.Is(err, pool.initErr), "expect the Init error wrapped, got %v", err)
+
+	client, _ := Dial("tcp", startTestServer(server))
+	defer func() { _ = client.Close() }()
+	err = client.Call(context.Background(), "Pool.Query", 0, new(int))
+	_assert(err != nil && strings.Contains(err.Error(), "can't find service"), "expect Pool not registered, got %v", err)
+}
@@ -0,0 +1,36 @@
+package geerpc
+
+import (
+	"context"
+	"net"
+)
+
+// ListenOption configures the listener created by Listen.
+type ListenOption struct {
+	// DisableReuseAddr clears SO_REUSEADDR, which net.Listen sets on Unix
+	// systems so that the address can be bound again right after the
+	// listener is closed, even if connections of it are still in TIME_WAIT.
+	// Without it a rebind fails with "address already in use" as long as
+	// the old connections linger. It's only supported on Linux, elsewhere
+	// SO_REUSEADDR is left as net.Listen sets it.
+	DisableReuseAddr bool
+	// Backlog is the size of the queue of pending connections,
+	// 0 means the system default. It's only supported on Linux.
+	Backlog int
+}
+
+// Listen announces on the local network address like net.Listen,
+// configured by opt. A nil opt is the same as net.Listen.
+func Listen(network, address string, opt *ListenOption) (net.Listener, error) {
+	if opt == nil {
+		return net.Listen(network, address)
+	}
+	if opt.Backlog > 0 {
+		return listenBacklog(network, address, opt)
+	}
+	var lc net.ListenConfig
+	if opt.DisableReuseAddr {
+		lc.Control = reuseAddrControl(false)
+	}
+	return lc.Listen(context.Background(), network, address)
+}
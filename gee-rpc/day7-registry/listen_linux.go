@@ -0,0 +1,96 @@
+package geerpc
+
+import (
+	"errors"
+	"net"
+	"os"
+	"syscall"
+)
+
+// reuseAddrControl returns the Control setting SO_REUSEADDR to on, it runs
+// after the Go runtime sets it by default and before the socket is bound.
+func reuseAddrControl(on bool) func(network, address string, c syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) error {
+		var err error
+		if cerr := c.Control(func(fd uintptr) {
+			err = setReuseAddr(int(fd), on)
+		}); cerr != nil {
+			return cerr
+		}
+		return err
+	}
+}
+
+func setReuseAddr(fd int, on bool) error {
+	v := 0
+	if on {
+		v = 1
+	}
+	return os.NewSyscallError("setsockopt", syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_REUSEADDR, v))
+}
+
+// listenBacklog creates the listening socket by itself, since net.Listen
+// always uses the backlog of net.core.somaxconn.
+// Like net.Listen, "tcp" with a wildcard address listens on both IPv4 and
+// IPv6 if the system supports it, "tcp6" on IPv6 only.
+func listenBacklog(network, address string, opt *ListenOption) (net.Listener, error) {
+	addr, err := net.ResolveTCPAddr(network, address)
+	if err != nil {
+		return nil, err
+	}
+	wildcard := addr.IP == nil || addr.IP.IsUnspecified()
+	ip4 := addr.IP.To4()
+	if network == "tcp4" && wildcard {
+		ip4 = net.IPv4zero.To4()
+	}
+	if network == "tcp" && wildcard {
+		// a dual stack socket, or IPv4 only if IPv6 is not supported
+		l, err := listenSocket(syscall.AF_INET6, ipv6Sockaddr(nil, addr.Port), false, opt)
+		if !errors.Is(err, syscall.EAFNOSUPPORT) {
+			return l, err
+		}
+		ip4 = net.IPv4zero.To4()
+	}
+	if ip4 != nil && network != "tcp6" {
+		sa := &syscall.SockaddrInet4{Port: addr.Port}
+		copy(sa.Addr[:], ip4)
+		return listenSocket(syscall.AF_INET, sa, false, opt)
+	}
+	return listenSocket(syscall.AF_INET6, ipv6Sockaddr(addr.IP, addr.Port), network == "tcp6", opt)
+}
+
+func ipv6Sockaddr(ip net.IP, port int) *syscall.SockaddrInet6 {
+	sa := &syscall.SockaddrInet6{Port: port}
+	copy(sa.Addr[:], ip.To16())
+	return sa
+}
+
+// listenSocket binds a socket of family to sa and listens with the backlog of opt
+func listenSocket(family int, sa syscall.Sockaddr, v6only bool, opt *ListenOption) (net.Listener, error) {
+	fd, err := syscall.Socket(family, syscall.SOCK_STREAM|syscall.SOCK_CLOEXEC, syscall.IPPROTO_TCP)
+	if err != nil {
+		return nil, os.NewSyscallError("socket", err)
+	}
+	f := os.NewFile(uintptr(fd), "geerpc-listener")
+	defer func() { _ = f.Close() }() // net.FileListener duplicates fd
+	// on unless it's disabled, like net.Listen
+	if err = setReuseAddr(fd, !opt.DisableReuseAddr); err != nil {
+		return nil, err
+	}
+	if family == syscall.AF_INET6 {
+		v := 0
+		if v6only {
+			v = 1
+		}
+		if err = syscall.SetsockoptInt(fd, syscall.IPPROTO_IPV6, syscall.IPV6_V6ONLY, v); err != nil {
+			return nil, os.NewSyscallError("setsockopt", err)
+		}
+	}
+	if err = syscall.Bind(fd, sa); err != nil {
+		return nil, os.NewSyscallError("bind", err)
+	}
+	if err = syscall.Listen(fd, opt.Backlog); err != nil {
+		return nil, os.NewSyscallError("listen", err)
+	}
+	return net.FileListener(f)
+}
@@ -0,0 +1,86 @@
+package geerpc
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// lingerAddr listens on 127.0.0.1 with opt, accepts a connection and closes it
+// first, so that it lingers in TIME_WAIT, then closes the listener
+func lingerAddr(opt *ListenOption) string {
+	l, err := Listen("tcp", "127.0.0.1:0", opt)
+	_assert(err == nil, "failed to listen with %+v: %v", opt, err)
+	addr := l.Addr().String()
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		conn, _ := net.Dial("tcp", addr)
+		if conn != nil {
+			buf := make([]byte, 1)
+			_, _ = conn.Read(buf)
+			_ = conn.Close()
+		}
+	}()
+	conn, err := l.Accept()
+	_assert(err == nil, "failed to accept: %v", err)
+	_ = conn.Close()
+	<-closed
+	_ = l.Close()
+	return addr
+}
+
+func TestListen_NoReuseAddr(t *testing.T) {
+	for _, opt := range []*ListenOption{{DisableReuseAddr: true}, {DisableReuseAddr: true, Backlog: 16}} {
+		// the socket in TIME_WAIT has SO_REUSEADDR, only the new one lacks it
+		addr := lingerAddr(&ListenOption{Backlog: opt.Backlog})
+		l, err := Listen("tcp", addr, opt)
+		if err == nil {
+			_ = l.Close()
+		}
+		_assert(errors.Is(err, syscall.EADDRINUSE), "expect %s in use with %+v, got %v", addr, opt, err)
+
+		// SO_REUSEADDR is on by default
+		opt.DisableReuseAddr = false
+		l, err = Listen("tcp", addr, opt)
+		_assert(err == nil, "failed to rebind %s with %+v: %v", addr, opt, err)
+		_ = l.Close()
+	}
+}
+
+func TestListen_BacklogFamily(t *testing.T) {
+	if l, err := net.Listen("tcp6", "[::1]:0"); err != nil {
+		t.Skip("IPv6 is not supported:", err)
+	} else {
+		_ = l.Close()
+	}
+	opt := &ListenOption{Backlog: 16}
+
+	l, err := Listen("tcp6", "[::1]:0", opt)
+	_assert(err == nil, "failed to listen on tcp6: %v", err)
+	_assert(strings.HasPrefix(l.Addr().String(), "[::1]:"), "expect an IPv6 listener, got %s", l.Addr())
+	_ = l.Close()
+
+	// like net.Listen, a wildcard address of "tcp" accepts both IPv4 and IPv6
+	l, err = Listen("tcp", ":0", opt)
+	_assert(err == nil, "failed to listen on :0: %v", err)
+	defer func() { _ = l.Close() }()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			_ = conn.Close()
+		}
+	}()
+	port := l.Addr().(*net.TCPAddr).Port
+	for _, host := range []string{"127.0.0.1", "::1"} {
+		conn, err := net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+		_assert(err == nil, "failed to dial %s of a wildcard listener: %v", host, err)
+		_ = conn.Close()
+	}
+}
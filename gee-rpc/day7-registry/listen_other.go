@@ -0,0 +1,20 @@
+//go:build !linux
+// +build !linux
+
+package geerpc
+
+import (
+	"context"
+	"net"
+	"syscall"
+)
+
+// reuseAddrControl returns nil, SO_REUSEADDR is left as the Go runtime sets it.
+func reuseAddrControl(on bool) func(network, address string, c syscall.RawConn) error {
+	return nil
+}
+
+// listenBacklog ignores the backlog, which is not supported on this system.
+func listenBacklog(network, address string, opt *ListenOption) (net.Listener, error) {
+	return (&net.ListenConfig{}).Listen(context.Background(), network, address)
+}
@@ -0,0 +1,44 @@
+package geerpc
+
+import (
+	"context"
+	"net"
+	"testing"
+)
+
+func TestListen_Rebind(t *testing.T) {
+	for _, opt := range []*ListenOption{{}, {Backlog: 16}} {
+		l, err := Listen("tcp", "127.0.0.1:0", opt)
+		_assert(err == nil, "failed to listen with %+v: %v", opt, err)
+		addr := l.Addr().String()
+
+		// close the accepted connection first, so that it lingers in TIME_WAIT
+		go func() {
+			conn, _ := net.Dial("tcp", addr)
+			if conn != nil {
+				buf := make([]byte, 1)
+				_, _ = conn.Read(buf)
+				_ = conn.Close()
+			}
+		}()
+		conn, err := l.Accept()
+		_assert(err == nil, "failed to accept: %v", err)
+		_ = conn.Close()
+		_ = l.Close()
+
+		l, err = Listen("tcp", addr, opt)
+		_assert(err == nil, "failed to rebind %s with %+v: %v", addr, opt, err)
+
+		server := NewServer()
+		var foo Foo
+		_ = server.Register(&foo)
+		go server.Accept(l)
+		client, err := Dial("tcp", addr)
+		_assert(err == nil, "failed to dial the rebound listener: %v", err)
+		var reply int
+		err = client.Call(context.Background(), "Foo.Sum", &Args{Num1: 1, Num2: 2}, &reply)
+		_assert(err == nil && reply == 3, "failed to call Foo.Sum: %v", err)
+		_ = client.Close()
+		_ = l.Close()
+	}
+}
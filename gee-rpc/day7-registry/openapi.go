@@ -0,0 +1,82 @@
+package geerpc
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"sort"
+)
+
+const defaultOpenAPIPath = "/openapi.json"
+
+// OpenAPI describes the registered methods by an OpenAPI 3 document, each
+// one as a POST operation on /Service.Method, whose request and response
+// bodies are the JSON of its args and reply. Named structs are described in
+// the components of the document, the fields which are pointers or omitted
+// if empty are optional. Streaming methods and the methods not exposed by
+// AllowMethods and DenyMethods are left out.
+func (server *Server) OpenAPI() ([]byte, error) {
+	g := newSchemaGen()
+	g.components = make(map[string]interface{})
+	g.names = make(map[reflect.Type]string)
+	paths := make(map[string]interface{})
+	var names []string
+	server.serviceMap.Range(func(namei, _ interface{}) bool {
+		names = append(names, namei.(string))
+		return true
+	})
+	sort.Strings(names)
+	for _, name := range names {
+		svci, _ := server.serviceMap.Load(name)
+		for methodName, mtype := range svci.(*service).method {
+			serviceMethod := name + "." + methodName
+			if mtype.isStream() || mtype.isChanStream() || mtype.isReader() || !server.exposed(serviceMethod) {
+				continue
+			}
+			paths["/"+serviceMethod] = map[string]interface{}{
+				"post": map[string]interface{}{
+					"operationId": serviceMethod,
+					"tags":        []string{name},
+					"requestBody": map[string]interface{}{
+						"required": true,
+						"content":  jsonContent(g.schema(mtype.ArgType)),
+					},
+					"responses": map[string]interface{}{
+						"200": map[string]interface{}{
+							"description": "the reply of " + serviceMethod,
+							"content":     jsonContent(g.schema(mtype.ReplyType.Elem())),
+						},
+					},
+				},
+			}
+		}
+	}
+	return json.Marshal(map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "GeeRPC Services",
+			"version": "1.0.0",
+		},
+		"paths":      paths,
+		"components": map[string]interface{}{"schemas": g.components},
+	})
+}
+
+func jsonContent(schema map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{"application/json": map[string]interface{}{"schema": schema}}
+}
+
+// openAPIHTTP serves the document of Server.OpenAPI
+type openAPIHTTP struct {
+	*Server
+}
+
+func (server openAPIHTTP) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	doc, err := server.OpenAPI()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(doc)
+}
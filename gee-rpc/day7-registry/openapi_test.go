@@ -0,0 +1,126 @@
+package geerpc
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type Audit struct {
+	At time.Time `json:"at"`
+	By string    `json:"by,omitempty"`
+}
+
+type Order struct {
+	Audit
+	ID    int    `json:"id"`
+	Buyer Person `json:"buyer"`
+	Note  *string
+}
+
+type Shop int
+
+func (s Shop) Place(o Order, reply *Order) error        { return nil }
+func (s Shop) Watch(id int, stream *ServerStream) error { return nil }
+
+// openAPIDoc is the part of an OpenAPI 3 document checked by the test
+type openAPIDoc struct {
+	OpenAPI string `json:"openapi"`
+	Info    struct {
+		Title   string `json:"title"`
+		Version string `json:"version"`
+	} `json:"info"`
+	Paths map[string]map[string]struct {
+		OperationID string `json:"operationId"`
+		RequestBody struct {
+			Content map[string]struct{ Schema json.RawMessage } `json:"content"`
+		} `json:"requestBody"`
+		Responses map[string]struct {
+			Description string                                      `json:"description"`
+			Content     map[string]struct{ Schema json.RawMessage } `json:"content"`
+		} `json:"responses"`
+	} `json:"paths"`
+	Components struct {
+		Schemas map[string]struct {
+			Type       string                     `json:"type"`
+			Properties map[string]json.RawMessage `json:"properties"`
+			Required   []string                   `json:"required"`
+		} `json:"schemas"`
+	} `json:"components"`
+}
+
+// validateOpenAPI checks the required fields of doc are present and every
+// $ref in it refers to a schema of its components
+func validateOpenAPI(t *testing.T, raw []byte) *openAPIDoc {
+	doc := new(openAPIDoc)
+	err := json.Unmarshal(raw, doc)
+	_assert(err == nil, "expect the document parsed: %v", err)
+	_assert(strings.HasPrefix(doc.OpenAPI, "3."), "expect an OpenAPI 3 document, got %q", doc.OpenAPI)
+	_assert(doc.Info.Title != "" && doc.Info.Version != "", "expect the info title and version")
+	for path, ops := range doc.Paths {
+		_assert(strings.HasPrefix(path, "/"), "expect a path starting with /, got %s", path)
+		for method, op := range ops {
+			_assert(method == "post", "expect POST operations only, got %s", method)
+			_assert(len(op.Responses) > 0 && op.Responses["200"].Description != "", "expect a described response of %s", path)
+		}
+	}
+	var refs []string
+	var walk func(v interface{})
+	walk = func(v interface{}) {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			if ref, ok := v["$ref"].(string); ok {
+				refs = append(refs, ref)
+			}
+			for _, e := range v {
+				walk(e)
+			}
+		case []interface{}:
+			for _, e := range v {
+				walk(e)
+			}
+		}
+	}
+	var tree interface{}
+	_ = json.Unmarshal(raw, &tree)
+	walk(tree)
+	for _, ref := range refs {
+		name := strings.TrimPrefix(ref, "#/components/schemas/")
+		_, ok := doc.Components.Schemas[name]
+		_assert(ok && name != ref, "expect %s resolved in the components", ref)
+	}
+	return doc
+}
+
+func TestServer_OpenAPI(t *testing.T) {
+	server := NewServer()
+	var d Directory
+	var s Shop
+	_ = server.Register(&d)
+	_ = server.Register(&s)
+	server.DenyMethods = []string{"Directory.*"}
+
+	raw, err := server.OpenAPI()
+	_assert(err == nil, "failed to generate the document: %v", err)
+	doc := validateOpenAPI(t, raw)
+	_assert(len(doc.Paths) == 1 && doc.Paths["/Shop.Place"] != nil, "expect only Shop.Place described, got %v", doc.Paths)
+	op := doc.Paths["/Shop.Place"]["post"]
+	_assert(op.OperationID == "Shop.Place", "unexpected operation id %s", op.OperationID)
+	_assert(strings.Contains(string(op.RequestBody.Content["application/json"].Schema), "#/components/schemas/Order"),
+		"expect the args referred to, got %s", op.RequestBody.Content["application/json"].Schema)
+
+	order := doc.Components.Schemas["Order"]
+	_assert(order.Type == "object" && order.Properties["at"] != nil && order.Properties["by"] != nil,
+		"expect the embedded fields promoted, got %v", order.Properties)
+	_assert(strings.Join(order.Required, ",") == "at,buyer,id", "expect the optional fields left out of required, got %v", order.Required)
+	person := doc.Components.Schemas["Person"]
+	_assert(strings.Contains(string(person.Properties["friends"]), "#/components/schemas/Person"),
+		"expect the recursive field referred to, got %s", person.Properties["friends"])
+	_assert(strings.Contains(string(doc.Components.Schemas["Address"].Properties["Lines"]), "array"), "expect the nested struct described")
+
+	rec := httptest.NewRecorder()
+	openAPIHTTP{server}.ServeHTTP(rec, httptest.NewRequest("GET", defaultOpenAPIPath, nil))
+	_assert(rec.Code == 200 && rec.Body.String() == string(raw), "expect the document served, got %d", rec.Code)
+}
@@ -0,0 +1,48 @@
+//go:build (linux && cgo) || (darwin && cgo) || (freebsd && cgo)
+// +build linux,cgo darwin,cgo freebsd,cgo
+
+package geerpc
+
+import (
+	"errors"
+	"go/ast"
+	"plugin"
+	"reflect"
+)
+
+// RegisterFromPlugin opens the Go plugin at path and registers the
+// receiver value of the exported symbol Service, e.g. the plugin has
+//
+//	var Service = new(Calc)
+//
+// so that methods are added without recompiling the server.
+// Go plugins are only supported on Linux, FreeBSD and macOS with cgo.
+func (server *Server) RegisterFromPlugin(path string) error {
+	p, err := plugin.Open(path)
+	if err != nil {
+		return err
+	}
+	sym, err := p.Lookup("Service")
+	if err != nil {
+		return err
+	}
+	// sym points to the variable Service, register what it holds if it's
+	// a pointer or an interface, otherwise the variable itself
+	rcvr := reflect.ValueOf(sym)
+	if rcvr.Kind() != reflect.Ptr {
+		return errors.New("rpc server: plugin symbol Service is not a variable: " + path)
+	}
+	if k := rcvr.Elem().Kind(); k == reflect.Ptr || k == reflect.Interface {
+		rcvr = rcvr.Elem()
+	}
+	if rcvr.IsNil() {
+		return errors.New("rpc server: plugin symbol Service is nil: " + path)
+	}
+	if name := reflect.Indirect(rcvr.Elem()).Type().Name(); !ast.IsExported(name) {
+		return errors.New("rpc server: plugin " + path + " has an invalid service name: " + name)
+	}
+	return server.Register(rcvr.Interface())
+}
+
+// RegisterFromPlugin registers the service of the plugin at path in the DefaultServer.
+func RegisterFromPlugin(path string) error { return DefaultServer.RegisterFromPlugin(path) }
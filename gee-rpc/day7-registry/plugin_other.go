@@ -0,0 +1,15 @@
+//go:build (!linux && !darwin && !freebsd) || !cgo
+// +build !linux,!darwin,!freebsd !cgo
+
+package geerpc
+
+import "errors"
+
+// RegisterFromPlugin returns an error, Go plugins are only supported
+// on Linux, FreeBSD and macOS with cgo.
+func (server *Server) RegisterFromPlugin(path string) error {
+	return errors.New("rpc server: plugins are not supported on this platform")
+}
+
+// RegisterFromPlugin registers the service of the plugin at path in the DefaultServer.
+func RegisterFromPlugin(path string) error { return DefaultServer.RegisterFromPlugin(path) }
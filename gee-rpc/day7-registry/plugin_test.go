@@ -0,0 +1,34 @@
+//go:build linux && cgo
+// +build linux,cgo
+
+package geerpc
+
+import (
+	"context"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestServer_RegisterFromPlugin(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "calc.so")
+	if out, err := exec.Command("go", "build", "-buildmode=plugin", "-o", path, "./testdata/calc").CombinedOutput(); err != nil {
+		t.Skipf("can't build the plugin: %v\n%s", err, out)
+	}
+	server := NewServer()
+	err := server.RegisterFromPlugin(path)
+	if err != nil && strings.Contains(err.Error(), "different version") {
+		t.Skipf("the plugin doesn't match the test binary, e.g. built without -race: %v", err)
+	}
+	_assert(err == nil, "failed to register from plugin: %v", err)
+	client, _ := Dial("tcp", startTestServer(server))
+	defer func() { _ = client.Close() }()
+
+	var reply int
+	err = client.Call(context.Background(), "Calc.Mul", &Args{Num1: 3, Num2: 4}, &reply)
+	_assert(err == nil && reply == 12, "failed to call Calc.Mul of the plugin: %v", err)
+
+	err = server.RegisterFromPlugin(filepath.Join(t.TempDir(), "missing.so"))
+	_assert(err != nil, "expect error for a missing plugin")
+}
@@ -0,0 +1,85 @@
+package geerpc
+
+import (
+	"context"
+	"geerpc/codec"
+	"sync"
+)
+
+// Progress is an update of a long call reported by its method, e.g. to show
+// a progress bar, see ReportProgress.
+type Progress struct {
+	Percent float64 // of the work done, from 0 to 100
+	Message string
+}
+
+// WithProgress returns a copy of ctx making the calls of it ask for the
+// progress of the method, f is called with each update before the call
+// returns. Like a slow receiver of Stream, a slow f holds up the other
+// calls of the client.
+func WithProgress(ctx context.Context, f func(Progress)) context.Context {
+	return context.WithValue(ctx, progressKey, f)
+}
+
+func progressOf(ctx context.Context) func(Progress) {
+	f, _ := ctx.Value(progressKey).(func(Progress))
+	return f
+}
+
+// ReportProgress sends an update of the call ctx belongs to, as a frame
+// ahead of the reply. It's a no-op if the client doesn't ask for the
+// progress by WithProgress, the method has returned, or ctx isn't passed
+// in by the server.
+func ReportProgress(ctx context.Context, percent float64, message string) error {
+	r, ok := ctx.Value(progressReporterKey).(*progressReporter)
+	if !ok {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return nil
+	}
+	return r.send(&Progress{Percent: percent, Message: message})
+}
+
+// progressReporter sends the progress frames of a call
+type progressReporter struct {
+	mu     sync.Mutex // protect following
+	send   func(p *Progress) error
+	closed bool // the call is responded
+}
+
+func newProgressReporter(cc codec.Codec, h *codec.Header, sending *sender) *progressReporter {
+	serviceMethod, seq := h.ServiceMethod, h.Seq
+	return &progressReporter{send: func(p *Progress) error {
+		return sending.write(cc, &codec.Header{ServiceMethod: serviceMethod, Seq: seq, Progress: true}, p)
+	}}
+}
+
+// close makes ReportProgress a no-op, it's called before the response is sent
+func (r *progressReporter) close() {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.closed = true
+}
+
+// receiveProgress delivers a progress frame to the call, the call stays
+// pending until the response
+func (client *Client) receiveProgress(h *codec.Header) error {
+	client.mu.Lock()
+	call := client.pending[h.Seq]
+	client.mu.Unlock()
+	if call == nil || call.progress == nil {
+		return client.cc.ReadBody(nil)
+	}
+	var p Progress
+	if err := client.cc.ReadBody(&p); err != nil {
+		return err
+	}
+	call.progress(p)
+	return nil
+}
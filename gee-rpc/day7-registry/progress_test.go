@@ -0,0 +1,39 @@
+package geerpc
+
+import (
+	"context"
+	"fmt"
+	"geerpc/codec"
+	"testing"
+)
+
+func TestReportProgress(t *testing.T) {
+	server := NewServer()
+	_ = server.RegisterFunc("Job.Run", func(ctx context.Context, steps int, reply *int) error {
+		for i := 1; i <= steps; i++ {
+			if err := ReportProgress(ctx, float64(i*100/steps), fmt.Sprintf("step %d", i)); err != nil {
+				return err
+			}
+		}
+		*reply = steps
+		return nil
+	})
+	addr := startTestServer(server)
+	for _, codecType := range []codec.Type{codec.GobType, codec.GobBinaryHeaderType, codec.JsonType} {
+		client, _ := Dial("tcp", addr, &Option{CodecType: codecType})
+		var updates []Progress
+		ctx := WithProgress(context.Background(), func(p Progress) { updates = append(updates, p) })
+		var reply int
+		err := client.Call(ctx, "Job.Run", 4, &reply)
+		_assert(err == nil && reply == 4, "failed to call Job.Run over %s: %v", codecType, err)
+		_assert(len(updates) == 4, "expect 4 updates before the reply over %s, got %v", codecType, updates)
+		for i, p := range updates {
+			_assert(p.Percent == float64((i+1)*25) && p.Message == fmt.Sprintf("step %d", i+1), "unexpected update %+v", p)
+		}
+
+		// without WithProgress the method reports to nobody
+		err = client.Call(context.Background(), "Job.Run", 2, &reply)
+		_assert(err == nil && reply == 2, "failed to call Job.Run without progress: %v", err)
+		_ = client.Close()
+	}
+}
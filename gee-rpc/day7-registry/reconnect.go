@@ -0,0 +1,96 @@
+package geerpc
+
+import (
+	"context"
+	"io"
+	"sync"
+)
+
+// ReconnectingClient is a client of a single server which dials again
+// when the connection is lost, e.g. the server restarts.
+// A call of an idempotent method failed by the connection is retried
+// once on the new connection, calls of other methods return the error.
+type ReconnectingClient struct {
+	rpcAddr    string
+	opt        *Option
+	idempotent map[string]bool
+	mu         sync.Mutex // protect following
+	client     *Client
+	closed     bool // user has called Close
+}
+
+var _ io.Closer = (*ReconnectingClient)(nil)
+
+// NewReconnectingClient connects to the server at rpcAddr like XDial.
+// idempotent are the methods safe to retry, in the format "<service>.<method>".
+func NewReconnectingClient(rpcAddr string, opt *Option, idempotent ...string) (*ReconnectingClient, error) {
+	rc := &ReconnectingClient{
+		rpcAddr:    rpcAddr,
+		opt:        opt,
+		idempotent: make(map[string]bool),
+	}
+	for _, method := range idempotent {
+		rc.idempotent[method] = true
+	}
+	if _, err := rc.dial(nil); err != nil {
+		return nil, err
+	}
+	return rc, nil
+}
+
+// dial returns the connected client, it dials again if the client is
+// unavailable or it's the broken one, which may not know it yet.
+func (rc *ReconnectingClient) dial(broken *Client) (*Client, error) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if rc.closed {
+		return nil, ErrShutdown
+	}
+	if rc.client != nil && rc.client != broken && rc.client.IsAvailable() {
+		return rc.client, nil
+	}
+	if rc.client != nil && rc.client == broken {
+		_ = rc.client.Close()
+	} else if rc.client != nil {
+		rc.client.Retire() // e.g. by MaxConnLifetime, its pending calls are drained
+	}
+	rc.client = nil
+	client, err := XDial(rc.rpcAddr, rc.opt)
+	if err != nil {
+		return nil, err
+	}
+	rc.client = client
+	return client, nil
+}
+
+// Call invokes the named function like Client.Call, reconnecting if needed.
+func (rc *ReconnectingClient) Call(ctx context.Context, serviceMethod string, args, reply interface{}) error {
+	client, err := rc.dial(nil)
+	if err != nil {
+		return err
+	}
+	err = client.Call(ctx, serviceMethod, args, reply)
+	if err == nil || IsServerError(err) || ctx.Err() != nil || !rc.idempotent[serviceMethod] {
+		return err
+	}
+	if client, err = rc.dial(client); err != nil {
+		return err
+	}
+	return client.Call(ctx, serviceMethod, args, reply)
+}
+
+// Close closes the connection, no more calls can be made.
+func (rc *ReconnectingClient) Close() error {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if rc.closed {
+		return ErrShutdown
+	}
+	rc.closed = true
+	if rc.client == nil {
+		return nil
+	}
+	err := rc.client.Close()
+	rc.client = nil
+	return err
+}
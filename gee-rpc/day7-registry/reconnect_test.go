@@ -0,0 +1,132 @@
+package geerpc
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// killableListener closes the connections it accepted on kill, like the server process exits
+type killableListener struct {
+	net.Listener
+	mu    sync.Mutex
+	conns []net.Conn
+}
+
+func (l *killableListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err == nil {
+		l.mu.Lock()
+		l.conns = append(l.conns, conn)
+		l.mu.Unlock()
+	}
+	return conn, err
+}
+
+func (l *killableListener) kill() {
+	_ = l.Close()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, conn := range l.conns {
+		_ = conn.Close()
+	}
+}
+
+func startKillableServer(addr string) *killableListener {
+	server := NewServer()
+	store := &Store{}
+	_ = server.Register(store)
+	inner, err := Listen("tcp", addr, &ListenOption{})
+	_assert(err == nil, "failed to listen on %s: %v", addr, err)
+	l := &killableListener{Listener: inner}
+	go server.Accept(l)
+	return l
+}
+
+// restart starts a new server on the address of l and kills l, the clients of l
+// see the connection lost when the new server is listening already.
+func (l *killableListener) restart() *killableListener {
+	_ = l.Listener.Close()
+	nl := startKillableServer(l.Addr().String())
+	l.kill()
+	return nl
+}
+
+func TestReconnectingClient(t *testing.T) {
+	l := startKillableServer("127.0.0.1:0")
+	rc, err := NewReconnectingClient("tcp@"+l.Addr().String(), nil, "Store.Get")
+	_assert(err == nil, "failed to connect: %v", err)
+	defer func() { _ = rc.Close() }()
+
+	var reply int
+	err = rc.Call(context.Background(), "Store.Get", 1, &reply)
+	_assert(err == nil && reply == 10, "failed to call Store.Get: %v", err)
+
+	// the server restarts between calls
+	l = l.restart()
+	err = rc.Call(context.Background(), "Store.Get", 2, &reply)
+	_assert(err == nil && reply == 20, "expect Store.Get to succeed after restart: %v", err)
+
+	// the server restarts during the call, Store.Get takes 100ms
+	restarted := make(chan *killableListener)
+	time.AfterFunc(time.Millisecond*50, func() { restarted <- l.restart() })
+	err = rc.Call(context.Background(), "Store.Get", 3, &reply)
+	l = <-restarted
+	defer l.kill()
+	_assert(err == nil && reply == 30, "expect Store.Get retried on the new connection: %v", err)
+
+	_ = rc.Close()
+	err = rc.Call(context.Background(), "Store.Get", 2, &reply)
+	_assert(err == ErrShutdown, "expect ErrShutdown after Close, got %v", err)
+}
+
+func TestReconnectingClient_NotIdempotent(t *testing.T) {
+	l := startKillableServer("127.0.0.1:0")
+	rc, _ := NewReconnectingClient("tcp@"+l.Addr().String(), nil)
+	defer func() { _ = rc.Close() }()
+
+	restarted := make(chan *killableListener)
+	time.AfterFunc(time.Millisecond*50, func() { restarted <- l.restart() })
+	var reply int
+	err := rc.Call(context.Background(), "Store.Get", 1, &reply)
+	l = <-restarted
+	defer l.kill()
+	_assert(err != nil, "expect the failed call of non-idempotent method returned")
+
+	err = rc.Call(context.Background(), "Store.Get", 1, &reply)
+	_assert(err == nil && reply == 10, "expect the next call on a new connection: %v", err)
+}
+
+func TestReconnectingClient_MaxConnLifetime(t *testing.T) {
+	server := NewServer()
+	var e Echo
+	var s Sleeper
+	_ = server.Register(&e)
+	_ = server.Register(&s)
+	rc, err := NewReconnectingClient("tcp@"+startTestServer(server), &Option{MaxConnLifetime: time.Millisecond * 100})
+	_assert(err == nil, "failed to connect: %v", err)
+	defer func() { _ = rc.Close() }()
+
+	// a call pending when the lifetime passes is drained
+	rc.mu.Lock()
+	first := rc.client
+	rc.mu.Unlock()
+	slow := first.Go("Sleeper.Sleep", 200, new(int), nil)
+
+	conns := make(map[string]bool)
+	for deadline := time.Now().Add(time.Millisecond * 350); time.Now().Before(deadline); {
+		var addr string
+		err := rc.Call(context.Background(), "Echo.Addr", 0, &addr)
+		_assert(err == nil, "expect calls to keep succeeding: %v", err)
+		conns[addr] = true
+		time.Sleep(time.Millisecond * 10)
+	}
+	_assert(len(conns) >= 3, "expect the connection recycled every lifetime, got %d connections", len(conns))
+	<-slow.Done
+	_assert(slow.Error == nil, "expect the pending call drained: %v", slow.Error)
+	time.Sleep(time.Millisecond * 10)
+	_assert(!first.IsAvailable(), "expect the retired client unavailable")
+	_assert(first.Close() == ErrShutdown, "expect the retired client closed once drained")
+}
@@ -0,0 +1,157 @@
+package geerpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"geerpc/codec"
+	"io"
+	"log"
+	"reflect"
+	"sync"
+)
+
+// RecordedCall is a call recorded by a Recorder. The args and the reply are
+// encoded in JSON whatever the codec of the connection is, so that they are
+// replayed without the types of the service and compared as decoded values.
+type RecordedCall struct {
+	ServiceMethod string
+	Args          []byte
+	Error         string
+	Reply         []byte // empty if Error is set
+}
+
+// Recorder records the calls handled by a server to a writer, e.g. a file,
+// to reproduce a bug by Replay. It's enabled by Server.Recorder, recording
+// costs an extra encoding of every args and reply. Streams aren't recorded.
+type Recorder struct {
+	mu      sync.Mutex // protect following
+	enc     *gob.Encoder
+	pending map[recordKey]*RecordedCall // requests waiting for their responses
+}
+
+// recordKey identifies a request by the codec of its connection and its seq
+type recordKey struct {
+	cc  codec.Codec
+	seq uint64
+}
+
+// NewRecorder returns a Recorder writing the calls to w, one gob value
+// per call in the order they are completed.
+func NewRecorder(w io.Writer) *Recorder {
+	return &Recorder{enc: gob.NewEncoder(w), pending: make(map[recordKey]*RecordedCall)}
+}
+
+// recordRequest keeps the request until recordResponse records the call, it's a no-op on nil
+func (r *Recorder) recordRequest(cc codec.Codec, h *codec.Header, argv interface{}) {
+	if r == nil {
+		return
+	}
+	args, err := json.Marshal(argv)
+	if err != nil {
+		log.Println("rpc server: record error:", err)
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.pending[recordKey{cc, h.Seq}] = &RecordedCall{ServiceMethod: h.ServiceMethod, Args: args}
+}
+
+// recordResponse records the call of the request h responds to, it's a no-op on nil
+func (r *Recorder) recordResponse(cc codec.Codec, h *codec.Header, body interface{}) {
+	if r == nil || h.More {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := recordKey{cc, h.Seq}
+	call, ok := r.pending[key]
+	if !ok {
+		return
+	}
+	delete(r.pending, key)
+	call.Error = h.Error
+	if h.Error == "" {
+		reply, err := json.Marshal(body)
+		if err != nil {
+			log.Println("rpc server: record error:", err)
+			return
+		}
+		call.Reply = reply
+	}
+	if err := r.enc.Encode(call); err != nil {
+		log.Println("rpc server: record error:", err)
+	}
+}
+
+// Divergence is a replayed call whose response differs from the recorded one.
+type Divergence struct {
+	Call  RecordedCall // as recorded
+	Error string       // of the replay
+	Reply []byte       // of the replay
+}
+
+func (d *Divergence) String() string {
+	if d.Error != d.Call.Error {
+		return fmt.Sprintf("%s: error %q, recorded %q", d.Call.ServiceMethod, d.Error, d.Call.Error)
+	}
+	return fmt.Sprintf("%s: reply %s, recorded %s", d.Call.ServiceMethod, d.Reply, d.Call.Reply)
+}
+
+// sameJSON reports whether a and b encode the same value, regardless of
+// the order of object keys and the white spaces
+func sameJSON(a, b []byte) bool {
+	if len(a) == 0 || len(b) == 0 {
+		return len(a) == len(b)
+	}
+	var va, vb interface{}
+	if err := decodeJSON(a, &va); err != nil {
+		return false
+	}
+	if err := decodeJSON(b, &vb); err != nil {
+		return false
+	}
+	return reflect.DeepEqual(va, vb)
+}
+
+func decodeJSON(data []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
+
+// Replay calls the server at rpcAddr, in the format of XDial, with the calls
+// recorded by a Recorder one by one, and returns those whose responses differ.
+func Replay(record io.Reader, rpcAddr string) ([]*Divergence, error) {
+	// the JSON codec sends and reads the recorded bodies as they are
+	client, err := XDial(rpcAddr, &Option{CodecType: codec.JsonType})
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = client.Close() }()
+	dec := gob.NewDecoder(record)
+	var divergences []*Divergence
+	for {
+		var call RecordedCall
+		if err := dec.Decode(&call); err == io.EOF {
+			return divergences, nil
+		} else if err != nil {
+			return divergences, err
+		}
+		var reply codec.RawReply
+		err := client.Call(context.Background(), call.ServiceMethod, codec.RawReply(call.Args), &reply)
+		if err != nil && !IsServerError(err) {
+			return divergences, errors.New("rpc client: replay " + call.ServiceMethod + ": " + err.Error())
+		}
+		d := &Divergence{Call: call, Reply: reply}
+		if err != nil {
+			d.Error, d.Reply = err.Error(), nil
+		}
+		if d.Error != call.Error || !sameJSON(d.Reply, call.Reply) {
+			divergences = append(divergences, d)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package geerpc
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"geerpc/codec"
+	"testing"
+)
+
+type Adder struct{ offset int }
+
+type AddArgs struct{ Num1, Num2 int }
+
+func (a *Adder) Add(args AddArgs, reply *int) error {
+	*reply = args.Num1 + args.Num2 + a.offset
+	return nil
+}
+
+// Table returns a map, whose keys are encoded by gob in a random order
+func (a *Adder) Table(n int, reply *map[string]int) error {
+	*reply = make(map[string]int)
+	for i := 0; i < n; i++ {
+		(*reply)[fmt.Sprint("key", i)] = i + a.offset
+	}
+	return nil
+}
+
+func (a *Adder) Fail(args AddArgs, reply *int) error {
+	return errors.New("adder: failed")
+}
+
+func TestReplay(t *testing.T) {
+	var record bytes.Buffer
+	server := NewServer()
+	server.Recorder = NewRecorder(&record)
+	_ = server.Register(&Adder{})
+	// the calls are recorded whatever the codec is
+	client, err := Dial("tcp", startTestServer(server), &Option{CodecType: codec.JsonType})
+	_assert(err == nil, "failed to dial: %v", err)
+	for i := 0; i < 3; i++ {
+		var reply int
+		err := client.Call(context.Background(), "Adder.Add", AddArgs{Num1: i, Num2: i * i}, &reply)
+		_assert(err == nil && reply == i+i*i, "failed to call Adder.Add: %v", err)
+	}
+	var table map[string]int
+	err = client.Call(context.Background(), "Adder.Table", 16, &table)
+	_assert(err == nil && len(table) == 16, "failed to call Adder.Table: %v", err)
+	err = client.Call(context.Background(), "Adder.Fail", AddArgs{}, new(int))
+	_assert(err != nil, "expect Adder.Fail to fail")
+	_ = client.Close()
+
+	t.Run("identical", func(t *testing.T) {
+		server := NewServer()
+		_ = server.Register(&Adder{})
+		divergences, err := Replay(bytes.NewReader(record.Bytes()), "tcp@"+startTestServer(server))
+		_assert(err == nil, "failed to replay: %v", err)
+		_assert(len(divergences) == 0, "expect identical responses, got %v", divergences)
+	})
+	t.Run("divergent", func(t *testing.T) {
+		server := NewServer()
+		_ = server.Register(&Adder{offset: 1})
+		divergences, err := Replay(bytes.NewReader(record.Bytes()), "tcp@"+startTestServer(server))
+		_assert(err == nil, "failed to replay: %v", err)
+		_assert(len(divergences) == 4, "expect the calls of Adder.Add and Adder.Table divergent, got %v", divergences)
+		_assert(divergences[0].Call.ServiceMethod == "Adder.Add", "unexpected divergence %v", divergences[0])
+	})
+}
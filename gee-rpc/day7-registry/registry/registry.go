@@ -1,6 +1,7 @@
 package registry
 
 import (
+	"context"
 	"log"
 	"net/http"
 	"sort"
@@ -24,8 +25,12 @@ type ServerItem struct {
 }
 
 const (
-	defaultPath    = "/_geerpc_/registry"
-	defaultTimeout = time.Minute * 5
+	defaultPath         = "/_geerpc_/registry"
+	defaultTimeout      = time.Minute * 5
+	defaultReapInterval = time.Minute
+	// reapAfter is the multiple of the timeout a server is kept after its
+	// last heartbeat, so that a late heartbeat finds it still there
+	reapAfter = 3
 )
 
 // New create a registry instance with timeout setting
@@ -64,6 +69,40 @@ func (r *GeeRegistry) aliveServers() []string {
 	return alive
 }
 
+// Reap deletes the servers without a heartbeat for reapAfter times the
+// timeout every interval, until ctx is done. The dead servers are only
+// deleted by a listing otherwise, so the ones which are never listed pile
+// up as servers come and go. An interval of 0 or less means a minute.
+func (r *GeeRegistry) Reap(ctx context.Context, interval time.Duration) {
+	if r.timeout == 0 {
+		return // servers never expire
+	}
+	if interval <= 0 {
+		interval = defaultReapInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		r.reap(time.Now().Add(-r.timeout * reapAfter))
+	}
+}
+
+// reap deletes the servers without a heartbeat since deadline
+func (r *GeeRegistry) reap(deadline time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for addr, s := range r.servers {
+		if s.start.Before(deadline) {
+			delete(r.servers, addr)
+		}
+	}
+}
+
 // Runs at /_geerpc_/registry
 func (r *GeeRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	switch req.Method {
@@ -89,8 +128,11 @@ func (r *GeeRegistry) HandleHTTP(registryPath string) {
 	log.Println("rpc registry path:", registryPath)
 }
 
+// HandleHTTP registers the DefaultGeeRegister on the default path,
+// and reaps its dead servers in the background
 func HandleHTTP() {
 	DefaultGeeRegister.HandleHTTP(defaultPath)
+	go DefaultGeeRegister.Reap(context.Background(), defaultReapInterval)
 }
 
 // Heartbeat send a heartbeat message every once in a while
@@ -0,0 +1,44 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func _assert(condition bool, msg string, v ...interface{}) {
+	if !condition {
+		panic(fmt.Sprintf("assertion failed: "+msg, v...))
+	}
+}
+
+func TestGeeRegistry_Reap(t *testing.T) {
+	r := New(time.Millisecond * 20)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go r.Reap(ctx, time.Millisecond*10)
+	r.putServer("tcp@127.0.0.1:1")
+
+	size := func() int {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		return len(r.servers)
+	}
+	// expired but not reaped yet, within reapAfter times the timeout
+	time.Sleep(time.Millisecond * 30)
+	_assert(size() == 1, "expect the server kept for a while after it's expired")
+	deadline := time.Now().Add(time.Second)
+	for size() > 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond * 10)
+	}
+	_assert(size() == 0, "expect the expired server deleted from the registry")
+}
+
+func TestGeeRegistry_ReapDefaultInterval(t *testing.T) {
+	r := New(time.Millisecond * 20)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	// returns at once, rather than panicking on a ticker of no interval
+	r.Reap(ctx, 0)
+}
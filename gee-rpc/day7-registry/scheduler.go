@@ -0,0 +1,100 @@
+package geerpc
+
+import (
+	"container/heap"
+	"sync"
+)
+
+// scheduler runs the requests of all connections on at most Server.MaxWorkers
+// goroutines, the requests waiting for a worker are taken by priority, the
+// ones of the same priority in the order they arrive
+type scheduler struct {
+	mu      sync.Mutex // protect following
+	running int
+	order   uint64 // of the task submitted last
+	queue   taskQueue
+	notFull *sync.Cond // signaled once a task is taken off the queue
+}
+
+type task struct {
+	priority int
+	order    uint64
+	run      func()
+}
+
+// taskQueue implements heap.Interface, the task of the highest priority first
+type taskQueue []*task
+
+func (q taskQueue) Len() int { return len(q) }
+func (q taskQueue) Less(i, j int) bool {
+	if q[i].priority != q[j].priority {
+		return q[i].priority > q[j].priority
+	}
+	return q[i].order < q[j].order
+}
+func (q taskQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *taskQueue) Push(x interface{}) { *q = append(*q, x.(*task)) }
+func (q *taskQueue) Pop() interface{} {
+	old := *q
+	t := old[len(old)-1]
+	old[len(old)-1] = nil
+	*q = old[:len(old)-1]
+	return t
+}
+
+// submit runs f on a worker, it's queued if all max workers are busy. It
+// blocks while maxQueue tasks are queued already, 0 means no limit.
+func (s *scheduler) submit(max, maxQueue, priority int, f func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for {
+		if s.running < max {
+			s.running++
+			go s.work(f)
+			return
+		}
+		if maxQueue <= 0 || s.queue.Len() < maxQueue {
+			break
+		}
+		if s.notFull == nil {
+			s.notFull = sync.NewCond(&s.mu)
+		}
+		s.notFull.Wait()
+	}
+	s.order++
+	heap.Push(&s.queue, &task{priority: priority, order: s.order, run: f})
+}
+
+// work runs f, then the queued tasks until the queue is empty
+func (s *scheduler) work(f func()) {
+	for f != nil {
+		f()
+		s.mu.Lock()
+		f = nil
+		if s.queue.Len() > 0 {
+			f = heap.Pop(&s.queue).(*task).run
+			if s.notFull != nil {
+				s.notFull.Signal()
+			}
+		} else {
+			s.running--
+		}
+		s.mu.Unlock()
+	}
+}
+
+// dispatch handles f on a goroutine of its own, or on a worker if MaxWorkers
+// is set. It blocks the connection reading while the queue is full.
+// f calls done once the method it calls returns, a worker is held until
+// then, so that a method timed out by HandleTimeout still takes one.
+func (server *Server) dispatch(priority int, f func(done func())) {
+	if server.MaxWorkers <= 0 {
+		go f(func() {})
+		return
+	}
+	server.scheduler.submit(server.MaxWorkers, server.MaxQueue, priority, func() {
+		returned := make(chan struct{})
+		f(func() { close(returned) })
+		<-returned
+	})
+}
@@ -0,0 +1,175 @@
+package geerpc
+
+import (
+	"context"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Queue records the order the calls of Record are handled
+type Queue struct {
+	gate  chan struct{}
+	mu    sync.Mutex
+	order []int
+}
+
+func (q *Queue) Block(args int, reply *int) error {
+	<-q.gate
+	return nil
+}
+
+func (q *Queue) Record(args int, reply *int) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.order = append(q.order, args)
+	return nil
+}
+
+func TestServer_Priority(t *testing.T) {
+	server := NewServer()
+	server.MaxWorkers = 1
+	q := &Queue{gate: make(chan struct{})}
+	_ = server.Register(q)
+	client, _ := Dial("tcp", startTestServer(server))
+	defer func() { _ = client.Close() }()
+
+	// the only worker is busy until the gate opens
+	blocked := client.Go("Queue.Block", 0, new(int), nil)
+	time.Sleep(time.Millisecond * 50)
+	var wg sync.WaitGroup
+	call := func(priority, args int) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := client.Call(WithPriority(context.Background(), priority), "Queue.Record", args, new(int))
+			_assert(err == nil, "failed to call Queue.Record: %v", err)
+		}()
+		time.Sleep(time.Millisecond * 10) // keep the order of arrival
+	}
+	// args are the order expected, by priority then by arrival
+	call(0, 3)
+	call(0, 4)
+	call(5, 0)
+	call(-1, 5)
+	call(5, 1)
+	call(1, 2)
+	close(q.gate)
+	<-blocked.Done
+	wg.Wait()
+	for i, args := range q.order {
+		_assert(args == i, "expect calls handled by priority, got %v", q.order)
+	}
+}
+
+// stats returns the workers running and the tasks queued
+func (s *scheduler) stats() (running, queued int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.running, s.queue.Len()
+}
+
+func TestServer_MaxQueue(t *testing.T) {
+	server := NewServer()
+	server.MaxWorkers, server.MaxQueue = 2, 2
+	gate := make(chan struct{})
+	var entered int32
+	_ = server.RegisterFunc("Pool.Block", func(args int, reply *int) error {
+		atomic.AddInt32(&entered, 1)
+		<-gate
+		return nil
+	})
+	client, _ := Dial("tcp", startTestServer(server))
+	defer func() { _ = client.Close() }()
+
+	calls := make([]*Call, 10)
+	for i := range calls {
+		calls[i] = client.Go("Pool.Block", i, new(int), nil)
+	}
+	time.Sleep(time.Millisecond * 100)
+	running, queued := server.scheduler.stats()
+	_assert(running == 2 && atomic.LoadInt32(&entered) == 2, "expect 2 workers running, got %d", running)
+	_assert(queued == 2, "expect 2 requests queued, got %d", queued)
+	var pending int64
+	server.conns.Range(func(sci, _ interface{}) bool {
+		pending = atomic.LoadInt64(&sci.(*serverConn).pending)
+		return true
+	})
+	// one more request is read, it waits for room, the others are left unread
+	_assert(pending == 5, "expect reading paused by the full queue, got %d requests read", pending)
+
+	close(gate)
+	for _, call := range calls {
+		<-call.Done
+		_assert(call.Error == nil, "failed to call Pool.Block: %v", call.Error)
+	}
+	_assert(atomic.LoadInt32(&entered) == 10, "expect all calls handled")
+}
+
+func BenchmarkServer_Dispatch(b *testing.B) {
+	const burst = 512
+	for _, bm := range []struct {
+		name                 string
+		maxWorkers, maxQueue int
+	}{
+		{"goroutine-per-request", 0, 0},
+		{"pool", 16, 64},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			server := NewServer()
+			server.MaxWorkers, server.MaxQueue = bm.maxWorkers, bm.maxQueue
+			_ = server.RegisterFunc("Burst.Work", func(args int, reply *int) error {
+				time.Sleep(time.Millisecond)
+				return nil
+			})
+			client, _ := Dial("tcp", startTestServer(server))
+			defer func() { _ = client.Close() }()
+			done := make(chan *Call, burst)
+			peak := 0
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				for j := 0; j < burst; j++ {
+					client.Go("Burst.Work", j, new(int), done)
+				}
+				for j := 0; j < burst; j++ {
+					<-done
+					// sampled while the burst is handled
+					if n := runtime.NumGoroutine(); j%16 == 0 && n > peak {
+						peak = n
+					}
+				}
+			}
+			b.ReportMetric(float64(peak), "peak-goroutines")
+		})
+	}
+}
+
+func TestServer_MaxWorkersHandleTimeout(t *testing.T) {
+	server := NewServer()
+	server.MaxWorkers = 1
+	var running, maxRunning int32
+	_ = server.RegisterFunc("Slow.Run", func(args int, reply *int) error {
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for m := atomic.LoadInt32(&maxRunning); n > m && !atomic.CompareAndSwapInt32(&maxRunning, m, n); m = atomic.LoadInt32(&maxRunning) {
+		}
+		time.Sleep(100 * time.Millisecond) // ignores the timeout
+		return nil
+	})
+	client, _ := Dial("tcp", startTestServer(server), &Option{HandleTimeout: 20 * time.Millisecond})
+	defer func() { _ = client.Close() }()
+
+	var calls []*Call
+	for i := 0; i < 5; i++ {
+		calls = append(calls, client.Go("Slow.Run", i, new(int), nil))
+	}
+	for i, call := range calls {
+		<-call.Done
+		_assert(call.Error != nil && strings.Contains(call.Error.Error(), "handle timeout"), "expect call %d timed out, got %v", i, call.Error)
+	}
+	// a method timed out holds its worker until it returns
+	_assert(atomic.LoadInt32(&maxRunning) == 1, "expect at most 1 method running, got %d", maxRunning)
+}
@@ -0,0 +1,182 @@
+package geerpc
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// JSONSchema describes the args and the reply of a registered method by
+// JSON Schema, e.g. to generate clients or docs. The reply schema is of
+// the type the reply points to. Struct fields are named as encoding/json
+// names them.
+func (server *Server) JSONSchema(serviceMethod string) (argSchema, replySchema json.RawMessage, err error) {
+	_, mtype, err := server.findService(serviceMethod)
+	if err != nil {
+		return nil, nil, err
+	}
+	if argSchema, err = json.Marshal(newSchemaGen().schema(mtype.ArgType)); err != nil {
+		return nil, nil, err
+	}
+	replySchema, err = json.Marshal(newSchemaGen().schema(mtype.ReplyType.Elem()))
+	return
+}
+
+var typeOfTime = reflect.TypeOf(time.Time{})
+
+// schemaGen reflects over a type, visiting are the structs being described,
+// a recursive struct is described as a plain object where it recurs.
+// If components is set, named structs are described once in components
+// and referred to by $ref, as OpenAPI does, see Server.OpenAPI.
+type schemaGen struct {
+	visiting   map[reflect.Type]bool
+	components map[string]interface{}
+	names      map[reflect.Type]string // names of the structs in components
+}
+
+func newSchemaGen() *schemaGen {
+	return &schemaGen{visiting: make(map[reflect.Type]bool)}
+}
+
+func (g *schemaGen) schema(t reflect.Type) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == typeOfTime {
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 && t.Kind() == reflect.Slice {
+			// encoding/json encodes []byte as a base64 string
+			if g.components != nil {
+				return map[string]interface{}{"type": "string", "format": "byte"}
+			}
+			return map[string]interface{}{"type": "string", "contentEncoding": "base64"}
+		}
+		s := map[string]interface{}{"type": "array", "items": g.schema(t.Elem())}
+		if t.Kind() == reflect.Array {
+			s["minItems"], s["maxItems"] = t.Len(), t.Len()
+		}
+		return s
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": g.schema(t.Elem())}
+	case reflect.Struct:
+		if g.components != nil && t.Name() != "" {
+			return map[string]interface{}{"$ref": "#/components/schemas/" + g.component(t)}
+		}
+		return g.structSchema(t)
+	}
+	// interfaces, and types encoding/json doesn't support, may be anything
+	return map[string]interface{}{}
+}
+
+// component describes the named struct t in components once, and returns its
+// name there, qualified by its package if another struct has the same name
+func (g *schemaGen) component(t reflect.Type) string {
+	if name, ok := g.names[t]; ok {
+		return name
+	}
+	name := t.Name()
+	if _, dup := g.components[name]; dup {
+		name = strings.NewReplacer("/", "_", ".", "_").Replace(t.PkgPath()) + "_" + name
+	}
+	g.names[t] = name
+	g.components[name] = nil // reserved, t may recur in its fields
+	g.components[name] = g.structSchema(t)
+	return name
+}
+
+func (g *schemaGen) structSchema(t reflect.Type) map[string]interface{} {
+	s := map[string]interface{}{"type": "object"}
+	if g.visiting[t] {
+		return s
+	}
+	g.visiting[t] = true
+	defer delete(g.visiting, t)
+	properties := make(map[string]interface{})
+	var required []string
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" && !f.Anonymous {
+			continue // unexported
+		}
+		name, optional := f.Name, f.Type.Kind() == reflect.Ptr
+		if tag := f.Tag.Get("json"); tag != "" {
+			if tag == "-" {
+				continue
+			}
+			opts := strings.Split(tag, ",")
+			if opts[0] != "" {
+				name = opts[0]
+			}
+			for _, opt := range opts[1:] {
+				optional = optional || opt == "omitempty"
+			}
+		}
+		embedded := f.Anonymous && f.Tag.Get("json") == ""
+		var fs map[string]interface{}
+		if ft := indirectType(f.Type); embedded && ft.Kind() == reflect.Struct && ft != typeOfTime {
+			fs = g.structSchema(ft) // described inline rather than by $ref, to promote the fields
+		} else {
+			fs = g.schema(f.Type)
+		}
+		// the fields of an embedded struct are promoted like encoding/json does
+		if embedded {
+			if props, ok := fs["properties"].(map[string]interface{}); ok {
+				promoted, _ := fs["required"].([]string)
+				for k, v := range props {
+					if _, dup := properties[k]; !dup {
+						properties[k] = v
+						if contains(promoted, k) {
+							required = append(required, k)
+						}
+					}
+				}
+				continue
+			}
+			if f.PkgPath != "" {
+				continue
+			}
+		}
+		properties[name] = fs
+		if !optional {
+			required = append(required, name)
+		}
+	}
+	s["properties"] = properties
+	if g.components != nil && len(required) > 0 {
+		// OpenAPI tells the fields always present, the pointers and the
+		// fields omitted if empty are optional
+		sort.Strings(required)
+		s["required"] = required
+	}
+	return s
+}
+
+func indirectType(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
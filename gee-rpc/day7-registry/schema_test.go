@@ -0,0 +1,62 @@
+package geerpc
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+type Address struct {
+	City  string `json:"city"`
+	Lines []string
+}
+
+type Person struct {
+	Name     string            `json:"name"`
+	Age      int               `json:"age,omitempty"`
+	Address  *Address          `json:"address"`
+	Tags     map[string]string `json:"tags"`
+	Friends  []*Person         `json:"friends"`
+	Password string            `json:"-"`
+	internal int
+}
+
+type Directory int
+
+func (d Directory) Add(p Person, reply *[]Person) error { return nil }
+
+func TestServer_JSONSchema(t *testing.T) {
+	server := NewServer()
+	var d Directory
+	_ = server.Register(&d)
+
+	argSchema, replySchema, err := server.JSONSchema("Directory.Add")
+	_assert(err == nil, "failed to generate the schema: %v", err)
+	var arg struct {
+		Type       string
+		Properties map[string]struct {
+			Type                 string
+			Properties           map[string]json.RawMessage
+			Items                map[string]interface{}
+			AdditionalProperties map[string]interface{}
+		}
+	}
+	_ = json.Unmarshal(argSchema, &arg)
+	_assert(arg.Type == "object" && len(arg.Properties) == 5, "expect an object of 5 properties, got %s", argSchema)
+	_assert(arg.Properties["name"].Type == "string" && arg.Properties["age"].Type == "integer", "unexpected fields in %s", argSchema)
+	address := arg.Properties["address"]
+	_assert(address.Type == "object" && address.Properties["city"] != nil && address.Properties["Lines"] != nil,
+		"expect the nested struct described, got %s", argSchema)
+	_assert(arg.Properties["tags"].AdditionalProperties["type"] == "string", "expect a map of strings, got %s", argSchema)
+	friends := arg.Properties["friends"]
+	_assert(friends.Type == "array" && friends.Items["type"] == "object", "expect the recursive slice described, got %s", argSchema)
+
+	var reply struct {
+		Type  string
+		Items struct{ Type string }
+	}
+	_ = json.Unmarshal(replySchema, &reply)
+	_assert(reply.Type == "array" && reply.Items.Type == "object", "expect an array of objects, got %s", replySchema)
+
+	_, _, err = server.JSONSchema("Directory.Unknown")
+	_assert(err != nil, "expect an error for an unknown method")
+}
@@ -0,0 +1,108 @@
+package geerpc
+
+import (
+	"context"
+	"geerpc/codec"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync"
+	"testing"
+)
+
+// discardConn returns a TCP connection whose peer discards what it reads
+func discardConn(b *testing.B) net.Conn {
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	go func() {
+		conn, err := l.Accept()
+		_ = l.Close()
+		if err == nil {
+			_, _ = io.Copy(ioutil.Discard, conn)
+		}
+	}()
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		b.Fatal(err)
+	}
+	return conn
+}
+
+type response struct {
+	h    *codec.Header
+	body interface{}
+	done chan error
+}
+
+// BenchmarkSender compares the ways to serialize the responses written to a
+// connection by concurrent calls: a mutex flushing every response, a writer
+// goroutine fed by a channel, flushing once the channel is empty, and sender.
+func BenchmarkSender(b *testing.B) {
+	run := func(b *testing.B, batch bool, write func(cc codec.Codec, h *codec.Header, body interface{}) error) {
+		conn := discardConn(b)
+		cc := codec.NewGobCodec(conn)
+		cc.(codec.Batcher).SetBatch(batch)
+		defer func() { _ = cc.Close() }()
+		b.SetParallelism(64)
+		b.ResetTimer()
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				_ = write(cc, &codec.Header{ServiceMethod: "Foo.Sum", Seq: 1}, 3)
+			}
+		})
+	}
+	b.Run("mutex", func(b *testing.B) {
+		var mu sync.Mutex
+		run(b, false, func(cc codec.Codec, h *codec.Header, body interface{}) error {
+			mu.Lock()
+			defer mu.Unlock()
+			return cc.Write(h, body)
+		})
+	})
+	b.Run("channel", func(b *testing.B) {
+		responses := make(chan *response, 64)
+		defer close(responses)
+		var cc codec.Codec
+		var once sync.Once
+		run(b, true, func(c codec.Codec, h *codec.Header, body interface{}) error {
+			once.Do(func() {
+				cc = c
+				go func() {
+					for r := range responses {
+						err := cc.Write(r.h, r.body)
+						if len(responses) == 0 {
+							if flushErr := cc.Flush(); err == nil {
+								err = flushErr
+							}
+						}
+						r.done <- err
+					}
+				}()
+			})
+			r := &response{h: h, body: body, done: make(chan error, 1)}
+			responses <- r
+			return <-r.done
+		})
+	})
+	b.Run("sender", func(b *testing.B) {
+		sending := new(sender)
+		run(b, true, sending.write)
+	})
+}
+
+// BenchmarkServer_ConcurrentCalls makes many concurrent small calls on a
+// single connection, which contend for writing the responses.
+func BenchmarkServer_ConcurrentCalls(b *testing.B) {
+	server := NewServer()
+	var foo Foo
+	_ = server.Register(&foo)
+	client, _ := Dial("tcp", startTestServer(server))
+	defer func() { _ = client.Close() }()
+	b.SetParallelism(64)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		var reply int
+		for pb.Next() {
+			_ = client.Call(context.Background(), "Foo.Sum", &Args{Num1: 1, Num2: 2}, &reply)
+		}
+	})
+}
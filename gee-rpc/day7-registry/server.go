@@ -5,17 +5,20 @@
 package geerpc
 
 import (
+	"bufio"
+	"context"
 	"encoding/json"
 	"errors"
-	"fmt"
 	"geerpc/codec"
 	"io"
 	"log"
 	"net"
 	"net/http"
+	"path"
 	"reflect"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -26,17 +29,173 @@ type Option struct {
 	CodecType      codec.Type    // client may choose different Codec to encode body
 	ConnectTimeout time.Duration // 0 means no limit
 	HandleTimeout  time.Duration
+	IdleTimeout    time.Duration // server closes the connection if no request arrives within it, 0 means no limit
+	// Nagle enables Nagle's algorithm on the TCP connection dialed.
+	// Otherwise it's left disabled as the Go runtime does by default for
+	// TCP connections, dialed or accepted, since RPC calls are latency-sensitive.
+	Nagle bool
+	// MaxRequestsPerConn makes the server close the connection once it has
+	// served that many requests, the client has to reconnect, e.g. to be
+	// rebalanced by a load balancer. In-flight requests are finished first.
+	// 0 means no limit.
+	MaxRequestsPerConn int
+	// CompressRequest and CompressResponse compress the bodies of requests and
+	// responses respectively, e.g. only responses if they are large while
+	// requests are small. They take effect if the codec is a codec.Compressor.
+	CompressRequest  bool
+	CompressResponse bool
+	// CompressionDict is a preset dictionary of the compression both ways,
+	// e.g. a sample of the messages, which compresses small structured ones
+	// much better. It's sent to server along with the Option, at most 32KB
+	// as the window of deflate. The compression falls back to no dictionary
+	// if server doesn't support it, see FeatureCompressionDict. It takes
+	// effect if the codec is a codec.DictionaryCompressor.
+	CompressionDict []byte
+	// ChecksumEnabled appends a CRC32 checksum of the body to every message
+	// both ways, a body which doesn't match it is rejected as corrupted.
+	// It takes effect if the codec is a codec.Checksummer.
+	ChecksumEnabled bool
+	// BodyTransform names the transform of the bodies both ways, e.g. to
+	// encrypt them, see RegisterBodyTransform. The server acknowledges it
+	// if it has the transform, the connection fails otherwise, so that the
+	// bodies are never sent untransformed. It takes effect if the codec is
+	// a codec.Transformer.
+	BodyTransform string
+	// DialRetries makes Dial try again that many times if it fails to connect,
+	// waiting DialBackoff before the first retry and twice as long before each
+	// next one, with jitter. DialBackoff defaults to 100ms. They only cover
+	// establishing the connection, calls are never retried.
+	DialRetries int
+	DialBackoff time.Duration
+	// Backoff overrides the delays between dial retries, e.g. by
+	// DecorrelatedBackoff, nil means JitteredBackoff from DialBackoff.
+	Backoff Backoff `json:"-"`
+	// ContextMetadata maps context keys to metadata keys, the string values
+	// found in the context of a call are sent in the request metadata, so that
+	// cross-cutting values like trace IDs flow without being passed by hand.
+	// It isn't sent to the server, which maps them back by Server.ContextMetadata.
+	ContextMetadata map[interface{}]string `json:"-"`
+	// ReplyTypes are factories of the values the replies of methods are
+	// decoded into, by "Service.Method", for a reply pointing to an interface,
+	// e.g. func() interface{} { return &Circle{} } for a *Shape. The values
+	// of codecs like JSON don't carry their types, so the concrete type must
+	// be told. The gob codecs send the type of an interface value along, see
+	// RegisterGobType, they ignore ReplyTypes. It's local to client.
+	ReplyTypes ReplyTypes `json:"-"`
+	// Handshake is the encoding of the Option itself, JSONHandshake by default
+	Handshake HandshakeType `json:"-"`
+	// OrderedExecution makes the server handle the requests of the connection
+	// one at a time in the order they arrive, so that their side effects aren't
+	// reordered, at the cost of throughput. The next request isn't read until
+	// a call completes or times out by HandleTimeout, so cancelling it by the
+	// context doesn't reach the server either.
+	OrderedExecution bool
+	// MaxConnLifetime retires the connection of a client once it's that old,
+	// e.g. to rebalance or to pick up DNS changes. A retired client takes no
+	// new calls, it's closed once the pending calls are done, and IsAvailable
+	// reports false so that ReconnectingClient and XClient dial again on the
+	// next call. 0 means no limit. It's local to client.
+	MaxConnLifetime time.Duration `json:"-"`
+	// MaxReplySize limits the size of the encoded body of a reply, a reply
+	// larger than it fails the call with a "reply too large" error before it's
+	// decoded, and the connection is closed. It takes effect if the codec is a
+	// codec.BodyLimiter. 0 means no limit. It's local to client.
+	MaxReplySize int64 `json:"-"`
+	// ServerTimestamps asks server to stamp the response header of every call
+	// with the time it received the request and the time it sent the response,
+	// e.g. to tell the time handled from the round trip or to estimate clock
+	// skew, see ServerTimes. An older server sends no timestamps.
+	ServerTimestamps bool
+	// AckTimeout is how long client waits for server to acknowledge the
+	// Option of a JSONHandshake. A server which sends nothing within it is
+	// taken as an older one, which never acknowledges, the codec asked for
+	// is used without any of the Features. If the ack arrives later, the
+	// connection fails. 0 means 3s, negative means no limit but
+	// ConnectTimeout. It's local to client.
+	AckTimeout time.Duration `json:"-"`
+	// Features are the optional features client supports, AllFeatures if 0.
+	// The Option acknowledged by server carries the features active on the
+	// connection, an older server doesn't acknowledge, none of them is
+	// active then. See Feature and AckTimeout.
+	Features Feature
 }
 
 var DefaultOption = &Option{
 	MagicNumber:    MagicNumber,
 	CodecType:      codec.GobType,
 	ConnectTimeout: time.Second * 10,
+	Features:       AllFeatures,
 }
 
 // Server represents an RPC Server.
 type Server struct {
 	serviceMap sync.Map
+	aliases    sync.Map // see RegisterAlias, alias -> target "Service.Method"
+	conns      sync.Map // live connections, *serverConn -> struct{}
+	listeners  sync.Map // listeners being accepted, net.Listener -> struct{}
+	shutdown   int32    // set by Shutdown, accessed atomically
+	paused     int32    // set by Pause, accessed atomically
+
+	// LogPayload logs the args and reply of every call,
+	// struct fields tagged with `geerpc:"sensitive"` are masked.
+	LogPayload bool
+	// SlowThreshold logs a warning for calls handled longer than it, 0 means disabled
+	SlowThreshold time.Duration
+	// FallbackCodec is used when a client asks for an unsupported codec type,
+	// e.g. codec.GobType. The connection is refused if it's empty.
+	FallbackCodec codec.Type
+	// WriteTimeout is the deadline of writing a response, 0 means no limit.
+	// The connection is closed if a write times out, e.g. the client stops reading.
+	WriteTimeout time.Duration
+	// AllowMethods and DenyMethods filter the methods exposed by the server,
+	// e.g. to serve different surfaces on different listeners. They are
+	// "Service.Method" patterns in the syntax of path.Match, e.g. "Admin.*".
+	// If AllowMethods isn't empty, only the methods matching it are exposed,
+	// the methods matching DenyMethods are never exposed. A "*" doesn't
+	// match the "/" of a namespace, e.g. "billing/*.*" matches its services.
+	AllowMethods []string
+	DenyMethods  []string
+	// ContextMetadata maps metadata keys of requests to context keys, the
+	// values sent by clients are put into the context passed to methods,
+	// e.g. a tenant ID. See Option.ContextMetadata.
+	ContextMetadata map[string]interface{}
+	// MaxInFlightPerClient limits the requests handled at the same time for
+	// a single client, identified by ClientIdentity, so that a client can't
+	// monopolize the server. Requests beyond it fail with code ResourceExhausted.
+	// 0 means no limit.
+	MaxInFlightPerClient int
+	// ClientIdentity identifies the client of a call from the context passed
+	// to methods, e.g. by ClientCertificate. It defaults to the IP of
+	// RemoteAddr. Clients identified as "" aren't limited.
+	ClientIdentity func(ctx context.Context) string
+
+	// Recorder records the calls handled, see Replay. nil means disabled.
+	Recorder *Recorder
+	// MaxWorkers limits the requests handled at the same time across all
+	// connections, the requests beyond it wait for a worker, the ones of
+	// higher priority first, see WithPriority. A request timed out by
+	// HandleTimeout holds its worker until the method returns. 0 means every
+	// request is handled by a goroutine of its own at once.
+	// MaxQueue bounds the requests waiting for a worker, once it's full the
+	// connections stop reading requests until there's room, so that a burst
+	// is pushed back to the clients rather than buffered. 0 means no bound.
+	MaxWorkers int
+	MaxQueue   int
+	// DisabledFeatures are the optional features of the protocol the server
+	// doesn't support, clients fall back to the basic protocol for them.
+	DisabledFeatures Feature
+
+	scheduler scheduler // of the requests, see MaxWorkers
+
+	inFlightMu sync.Mutex     // protect following
+	inFlight   map[string]int // requests being handled per client identity
+
+	funcMu sync.Mutex // serializes RegisterFunc, see registerFunc
+
+	introspectOnce sync.Once
+	introspect     *service // the built-in service of IntrospectServiceMethod
+
+	closeOnce sync.Once // closes the services once, see closeServices
 }
 
 // NewServer returns a new Server.
@@ -47,62 +206,197 @@ func NewServer() *Server {
 // DefaultServer is the default instance of *Server.
 var DefaultServer = NewServer()
 
+// maxOptionSize limits the size of an encoded Option, a CompressionDict
+// encoded in base64 as JSON included
+const maxOptionSize = 64 << 10
+
+// maxCompressionDict limits Option.CompressionDict to the window of deflate,
+// the bytes before it wouldn't be referenced anyway
+const maxCompressionDict = 32 << 10
+
+// readOption reads an Option encoded by json.Encoder. It reads byte by byte
+// until the trailing newline, so that nothing after the Option is consumed.
+func readOption(r io.Reader) (*Option, error) {
+	var line []byte
+	b := make([]byte, 1)
+	for {
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, err
+		}
+		if b[0] == '\n' {
+			break
+		}
+		if line = append(line, b[0]); len(line) > maxOptionSize {
+			return nil, errors.New("option is too large")
+		}
+	}
+	var opt Option
+	if err := json.Unmarshal(line, &opt); err != nil {
+		return nil, err
+	}
+	return &opt, nil
+}
+
 // ServeConn runs the server on a single connection.
-// ServeConn blocks, serving the connection until the client hangs up.
+// ServeConn blocks, serving any number of requests on the connection until
+// the client hangs up. Reading io.EOF where a request header starts is the
+// normal end of the connection and isn't logged, any other read error, e.g.
+// a request cut off in the middle, is logged, then the connection is closed.
+// The contexts of the calls being handled are cancelled once the client hangs
+// up, since there's no one to reply to, unless OrderedExecution is set, then
+// the connection isn't read while a call is handled.
 func (server *Server) ServeConn(conn io.ReadWriteCloser) {
 	defer func() { _ = conn.Close() }()
-	var opt Option
-	if err := json.NewDecoder(conn).Decode(&opt); err != nil {
+	o, handshake, rest, err := readHandshake(bufio.NewReader(conn))
+	if err != nil {
 		log.Println("rpc server: options error: ", err)
 		return
 	}
+	opt := *o
 	if opt.MagicNumber != MagicNumber {
 		log.Printf("rpc server: invalid magic number %x", opt.MagicNumber)
 		return
 	}
+	server.negotiate(&opt)
+	transform := bodyTransform(opt.BodyTransform)
+	if opt.BodyTransform != "" && transform == nil {
+		log.Printf("rpc server: unknown body transform %s", opt.BodyTransform)
+		opt.BodyTransform = "" // acknowledged without it, client gives up
+	}
 	f := codec.NewCodecFuncMap[opt.CodecType]
+	if f == nil && server.FallbackCodec != "" {
+		log.Printf("rpc server: unsupported codec type %s, fall back to %s", opt.CodecType, server.FallbackCodec)
+		opt.CodecType = server.FallbackCodec
+		f = codec.NewCodecFuncMap[opt.CodecType]
+	}
 	if f == nil {
 		log.Printf("rpc server: invalid codec type %s", opt.CodecType)
 		return
 	}
-	server.serveCodec(f(conn), &opt)
+	// acknowledge the Option, so that client knows the codec chosen
+	ack := opt
+	ack.CompressionDict = nil // client has it, acknowledged by the features
+	if err := writeOption(conn, handshake, &ack); err != nil {
+		log.Println("rpc server: options ack error: ", err)
+		return
+	}
+	sc := newServerConn(conn, rest, server.WriteTimeout)
+	server.conns.Store(sc, struct{}{})
+	defer server.conns.Delete(sc)
+	defer close(sc.done)
+	// checked after Store, so that Shutdown either sees sc or is seen here
+	if atomic.LoadInt32(&server.shutdown) != 0 {
+		close(sc.reading)
+		return
+	}
+	cc := f(sc)
+	if c, ok := cc.(codec.Compressor); ok {
+		c.SetCompress(opt.CompressResponse && opt.Features.Has(FeatureCompression))
+	}
+	if c, ok := cc.(codec.DictionaryCompressor); ok && opt.Features.Has(FeatureCompression) {
+		c.SetDictionary(opt.CompressionDict)
+	}
+	if c, ok := cc.(codec.Checksummer); ok {
+		c.SetChecksum(opt.ChecksumEnabled)
+	}
+	if c, ok := cc.(codec.Transformer); ok {
+		c.SetTransform(transform)
+	}
+	server.serveCodec(sc, cc, &opt)
 }
 
+var errNilArgument = errors.New("rpc server: nil argument")
+
 // invalidRequest is a placeholder for response argv when error occurs
 var invalidRequest = struct{}{}
 
-func (server *Server) serveCodec(cc codec.Codec, opt *Option) {
-	sending := new(sync.Mutex) // make sure to send a complete response
+func (server *Server) serveCodec(sc *serverConn, cc codec.Codec, opt *Option) {
+	sending := new(sender) // make sure to send a complete response
+	if b, ok := cc.(codec.Batcher); ok {
+		b.SetBatch(true) // flushed by sending
+	}
 	wg := new(sync.WaitGroup)  // wait until all request are handled
-	for {
+	served := 0
+	var readErr error // the connection is given up by
+	for opt.MaxRequestsPerConn <= 0 || served < opt.MaxRequestsPerConn {
+		// the deadline is reset for every request
+		if !sc.setIdleDeadline(opt.IdleTimeout) {
+			break
+		}
 		req, err := server.readRequest(cc)
 		if err != nil {
-			if req == nil {
+			if req == nil || sc.isDraining() {
+				readErr = err
 				break // it's not possible to recover, so close the connection
 			}
 			req.h.Error = err.Error()
 			server.sendResponse(cc, req.h, invalidRequest, sending)
 			continue
 		}
+		if req.h.Cancel {
+			sc.cancelCall(req.h.Seq)
+			continue
+		}
+		if !opt.Features.Has(FeatureMetadata) {
+			req.md = nil
+		}
+		if !opt.ServerTimestamps {
+			req.received = time.Time{}
+		}
+		ctx := sc.startCall(req.h.Seq)
+		id, ok := server.acquireInFlight(ctx)
+		if !ok {
+			sc.cancelCall(req.h.Seq)
+			setError(req.h, Errorf(ResourceExhausted, "rpc server: too many in-flight requests of client %s", id))
+			server.sendResponse(cc, req.h, invalidRequest, sending)
+			continue
+		}
+		served++
 		wg.Add(1)
-		go server.handleRequest(cc, req, sending, wg, opt.HandleTimeout)
+		atomic.AddInt64(&sc.pending, 1)
+		// done is called once the method returns, which may be long after
+		// the request is replied if it timed out
+		handle := func(req *request, done func()) {
+			defer atomic.AddInt64(&sc.pending, -1)
+			defer sc.cancelCall(req.h.Seq)
+			server.handleRequest(ctx, cc, req, sending, wg, req.mtype.handleTimeout(opt.HandleTimeout), func() {
+				server.releaseInFlight(id)
+				done()
+			})
+		}
+		if opt.OrderedExecution {
+			handle(req, func() {})
+		} else {
+			server.dispatch(req.h.Priority, func(done func()) { handle(req, done) })
+		}
+	}
+	close(sc.reading)
+	if readErr != nil && !sc.isDraining() && !isTimeout(readErr) {
+		// the client hung up, the calls being handled have no one to reply to
+		sc.cancelAll()
 	}
 	wg.Wait()
+	// a method timed out may still be writing its response
+	sending.Lock()
 	_ = cc.Close()
+	sending.Unlock()
 }
 
 // request stores all information of a call
 type request struct {
-	h            *codec.Header // header of request
-	argv, replyv reflect.Value // argv and replyv of request
+	h            *codec.Header     // header of request
+	md           map[string]string // metadata of request
+	argv, replyv reflect.Value     // argv and replyv of request
 	mtype        *methodType
 	svc          *service
+	received     time.Time // when the header is read, zero if it isn't stamped
 }
 
 func (server *Server) readRequestHeader(cc codec.Codec) (*codec.Header, error) {
 	var h codec.Header
 	if err := cc.ReadHeader(&h); err != nil {
-		if err != io.EOF && err != io.ErrUnexpectedEOF {
+		// the client closed the connection between two requests
+		if err != io.EOF {
 			log.Println("rpc server: read header error:", err)
 		}
 		return nil, err
@@ -110,13 +404,38 @@ func (server *Server) readRequestHeader(cc codec.Codec) (*codec.Header, error) {
 	return &h, nil
 }
 
+// findService finds the method of serviceMethod, which may be an alias of
+// it, see RegisterAlias
 func (server *Server) findService(serviceMethod string) (svc *service, mtype *methodType, err error) {
+	svc, mtype, err = server.lookupMethod(serviceMethod)
+	if mtype == nil {
+		if target, ok := server.aliases.Load(serviceMethod); ok {
+			return server.lookupMethod(target.(string))
+		}
+	}
+	return
+}
+
+// lookupMethod finds the method registered as serviceMethod, aliases aside.
+// The method is returned along with the error if it isn't exposed.
+func (server *Server) lookupMethod(serviceMethod string) (svc *service, mtype *methodType, err error) {
+	// the method follows the last dot, the service name before it may be
+	// namespaced like "billing/Invoice", see ServiceOption.Namespace
 	dot := strings.LastIndex(serviceMethod, ".")
 	if dot < 0 {
 		err = errors.New("rpc server: service/method request ill-formed: " + serviceMethod)
 		return
 	}
 	serviceName, methodName := serviceMethod[:dot], serviceMethod[dot+1:]
+	if svc = server.builtinService(serviceName); svc != nil {
+		if mtype = svc.method[methodName]; mtype == nil {
+			err = errors.New("rpc server: can't find method " + methodName)
+		} else if serviceMethod != PingServiceMethod && !server.exposed(serviceMethod) {
+			// only ping isn't filtered, the others may be hidden
+			err = errors.New("rpc server: method not available: " + serviceMethod)
+		}
+		return
+	}
 	svci, ok := server.serviceMap.Load(serviceName)
 	if !ok {
 		err = errors.New("rpc server: can't find service " + serviceName)
@@ -126,16 +445,42 @@ func (server *Server) findService(serviceMethod string) (svc *service, mtype *me
 	mtype = svc.method[methodName]
 	if mtype == nil {
 		err = errors.New("rpc server: can't find method " + methodName)
+		return
+	}
+	if !server.exposed(serviceMethod) {
+		err = errors.New("rpc server: method not available: " + serviceMethod)
 	}
 	return
 }
 
+// exposed reports whether serviceMethod passes AllowMethods and DenyMethods
+func (server *Server) exposed(serviceMethod string) bool {
+	if len(server.AllowMethods) > 0 && !matchAny(server.AllowMethods, serviceMethod) {
+		return false
+	}
+	return !matchAny(server.DenyMethods, serviceMethod)
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
 func (server *Server) readRequest(cc codec.Codec) (*request, error) {
 	h, err := server.readRequestHeader(cc)
 	if err != nil {
 		return nil, err
 	}
-	req := &request{h: h}
+	// the header is reused by the response, which has its own metadata
+	req := &request{h: h, md: h.Metadata, received: time.Now()}
+	h.Metadata = nil
+	if h.Cancel {
+		return req, cc.ReadBody(nil)
+	}
 	req.svc, req.mtype, err = server.findService(h.ServiceMethod)
 	if err != nil {
 		return req, err
@@ -145,38 +490,149 @@ func (server *Server) readRequest(cc codec.Codec) (*request, error) {
 
 	// make sure that argvi is a pointer, ReadBody need a pointer as parameter
 	argvi := req.argv.Interface()
+	var argvp reflect.Value
 	if req.argv.Type().Kind() != reflect.Ptr {
 		argvi = req.argv.Addr().Interface()
+	} else {
+		// decode through a pointer to argv, so that a null body
+		// leaves argv nil rather than pointing to a zero value
+		argvp = reflect.New(req.argv.Type())
+		argvp.Elem().Set(req.argv)
+		argvi = argvp.Interface()
 	}
 	if err = cc.ReadBody(argvi); err != nil {
 		log.Println("rpc server: read body err:", err)
 		return req, err
 	}
+	if argvp.IsValid() {
+		if argvp.Elem().IsNil() {
+			return req, errNilArgument
+		}
+		req.argv = argvp.Elem()
+	}
+	server.Recorder.recordRequest(cc, h, argvi)
 	return req, nil
 }
 
-func (server *Server) sendResponse(cc codec.Codec, h *codec.Header, body interface{}, sending *sync.Mutex) {
-	sending.Lock()
-	defer sending.Unlock()
-	if err := cc.Write(h, body); err != nil {
+// sendResponse writes a response, if its body can't be encoded an error
+// response of code Internal is written instead, the connection is kept.
+func (server *Server) sendResponse(cc codec.Codec, h *codec.Header, body interface{}, sending *sender) {
+	server.Recorder.recordResponse(cc, h, body)
+	err := sending.write(cc, h, body)
+	var bodyErr *codec.BodyError
+	if errors.As(err, &bodyErr) && h.ErrorBody {
+		// the error is still sent by its message
+		log.Printf("rpc server: can't encode error value of %s(seq %d): %v", h.ServiceMethod, h.Seq, bodyErr.Err)
+		h.ErrorBody = false
+		err = sending.write(cc, h, invalidRequest)
+	} else if errors.As(err, &bodyErr) {
+		log.Printf("rpc server: can't encode reply of %s(seq %d): %v", h.ServiceMethod, h.Seq, bodyErr.Err)
+		setError(h, Errorf(Internal, "rpc server: can't encode reply: %v", bodyErr.Err))
+		err = sending.write(cc, h, invalidRequest)
+	}
+	if err != nil {
 		log.Println("rpc server: write response error:", err)
 	}
 }
 
-func (server *Server) handleRequest(cc codec.Codec, req *request, sending *sync.Mutex, wg *sync.WaitGroup, timeout time.Duration) {
+// handleRequest calls the method of req and replies, it returns once the
+// reply is sent or the call times out. done is called once the method
+// returns, a method timed out keeps running until it does.
+func (server *Server) handleRequest(ctx context.Context, cc codec.Codec, req *request, sending *sender, wg *sync.WaitGroup, timeout time.Duration, done func()) {
 	defer wg.Done()
 	called := make(chan struct{})
 	sent := make(chan struct{})
+	timedOut := make(chan struct{}) // closed if the timeout is replied
+	rm := new(responseMetadata)
+	ctx = context.WithValue(ctx, responseMetadataKey, rm)
+	for name, key := range server.ContextMetadata {
+		if value, ok := req.md[name]; ok {
+			ctx = context.WithValue(ctx, key, value)
+		}
+	}
+	var progress *progressReporter
+	if req.h.Progress {
+		// the header is reused by the response, which isn't a progress frame
+		req.h.Progress = false
+		progress = newProgressReporter(cc, req.h, sending)
+		ctx = context.WithValue(ctx, progressReporterKey, progress)
+	}
+	var stream *ServerStream
+	returned := make(chan struct{})
+	var drained <-chan struct{}
+	switch {
+	case req.mtype.isStream():
+		stream = req.replyv.Interface().(*ServerStream)
+		stream.start(cc, req.h, rm, sending)
+	case req.mtype.isChanStream():
+		stream = new(ServerStream)
+		stream.start(cc, req.h, rm, sending)
+		drained = stream.drain(req.replyv, returned)
+	case req.mtype.isReader():
+		stream = new(ServerStream)
+		stream.start(cc, req.h, rm, sending)
+	}
 	go func() {
-		err := req.svc.call(req.mtype, req.argv, req.replyv)
-		called <- struct{}{}
+		defer done()
+		start := time.Now()
+		key, hit := req.mtype.cache.lookup(req.argv, req.replyv)
+		var err error
+		if !hit {
+			err = req.mtype.acquireSlot(ctx)
+			if err == nil {
+				err = req.svc.callContext(ctx, req.mtype, req.argv, req.replyv)
+				req.mtype.releaseSlot()
+			}
+			if err == nil {
+				req.mtype.cache.store(key, req.replyv)
+			}
+		}
+		close(returned)
+		progress.close()
+		if drained != nil {
+			<-drained
+		}
+		if err == nil && req.mtype.isReader() {
+			err = stream.sendReader(req.replyv.Elem().Interface())
+		}
+		if stream != nil {
+			stream.close()
+		}
+		if _, isGob := cc.(*codec.GobCodec); isGob && err == nil {
+			err = req.mtype.checkGobReply(req.replyv)
+		}
+		md := rm.snapshot()
+		if d := time.Since(start); server.SlowThreshold > 0 && d > server.SlowThreshold {
+			log.Printf("rpc server: warn: slow call %s(seq %d) took %s", req.h.ServiceMethod, req.h.Seq, d)
+		}
+		if server.LogPayload {
+			server.logPayload(req, err)
+		}
+		select {
+		case called <- struct{}{}:
+		case <-timedOut:
+			return // the timeout is replied instead
+		}
+		// req.h is only touched once called is received, the timeout
+		// may be sending it otherwise
+		req.h.Metadata = stampTimes(md, req.received)
 		if err != nil {
-			req.h.Error = err.Error()
-			server.sendResponse(cc, req.h, invalidRequest, sending)
+			setError(req.h, err)
+			var body interface{} = invalidRequest
+			if _, isGob := cc.(*codec.GobCodec); isGob && isRegisteredError(err) {
+				req.h.ErrorBody = true
+				body = &errorBody{Err: err}
+			}
+			server.sendResponse(cc, req.h, body, sending)
 			sent <- struct{}{}
 			return
 		}
-		server.sendResponse(cc, req.h, req.replyv.Interface(), sending)
+		if stream != nil {
+			// the final frame of a stream carries the trailer only
+			server.sendResponse(cc, req.h, invalidRequest, sending)
+		} else {
+			server.sendResponse(cc, req.h, req.replyv.Interface(), sending)
+		}
 		sent <- struct{}{}
 	}()
 
@@ -187,39 +643,213 @@ func (server *Server) handleRequest(cc codec.Codec, req *request, sending *sync.
 	}
 	select {
 	case <-time.After(timeout):
-		req.h.Error = fmt.Sprintf("rpc server: request handle timeout: expect within %s", timeout)
+		close(timedOut)
+		setError(req.h, Errorf(DeadlineExceeded, "rpc server: request handle timeout: expect within %s", timeout))
+		req.h.Metadata = stampTimes(nil, req.received)
+		progress.close()
+		if stream != nil {
+			stream.close()
+		}
 		server.sendResponse(cc, req.h, invalidRequest, sending)
 	case <-called:
 		<-sent
 	}
 }
 
+func (server *Server) logPayload(req *request, err error) {
+	if err != nil {
+		log.Printf("rpc server: %s(seq %d) args: %s, error: %v",
+			req.h.ServiceMethod, req.h.Seq, redact(req.argv.Interface()), err)
+		return
+	}
+	log.Printf("rpc server: %s(seq %d) args: %s, reply: %s",
+		req.h.ServiceMethod, req.h.Seq, redact(req.argv.Interface()), redact(req.replyv.Interface()))
+}
+
 // Accept accepts connections on the listener and serves requests
-// for each incoming connection.
+// for each incoming connection. It may be called concurrently with
+// several listeners, which serve the same services, Shutdown closes all.
 func (server *Server) Accept(lis net.Listener) {
+	server.listeners.Store(lis, struct{}{})
+	defer server.listeners.Delete(lis)
+	if atomic.LoadInt32(&server.shutdown) != 0 {
+		_ = lis.Close()
+		return
+	}
 	for {
 		conn, err := lis.Accept()
 		if err != nil {
-			log.Println("rpc server: accept error:", err)
+			if atomic.LoadInt32(&server.shutdown) == 0 {
+				log.Println("rpc server: accept error:", err)
+			}
 			return
 		}
+		if atomic.LoadInt32(&server.paused) != 0 {
+			_ = conn.Close()
+			continue
+		}
 		go server.ServeConn(conn)
 	}
 }
 
+// Serve accepts connections on each of the listeners, e.g. a public TLS
+// one and an internal plain one, until they are all closed by Shutdown
+// or fail.
+func (server *Server) Serve(listeners ...net.Listener) {
+	var wg sync.WaitGroup
+	for _, lis := range listeners {
+		wg.Add(1)
+		go func(lis net.Listener) {
+			defer wg.Done()
+			server.Accept(lis)
+		}(lis)
+	}
+	wg.Wait()
+}
+
+// Pause makes Accept close new connections right after accepting them,
+// until Resume is called. The listeners stay open and the connections
+// accepted before keep being served.
+func (server *Server) Pause() {
+	atomic.StoreInt32(&server.paused, 1)
+}
+
+// Resume makes Accept serve new connections again after Pause.
+func (server *Server) Resume() {
+	atomic.StoreInt32(&server.paused, 0)
+}
+
 // Accept accepts connections on the listener and serves requests
 // for each incoming connection.
 func Accept(lis net.Listener) { DefaultServer.Accept(lis) }
 
+// Serve accepts connections on each of the listeners for the DefaultServer.
+func Serve(listeners ...net.Listener) { DefaultServer.Serve(listeners...) }
+
 // Register publishes in the server the set of methods of the
 // receiver value that satisfy the following conditions:
 //	- exported method of exported type
 //	- two arguments, both of exported type
 //	- the second argument is a pointer
 //	- one return value, of type error
+// A reply starts as the zero value of its type, except that maps and slices
+// are empty rather than nil, and pointers point to a zero value. A reply of
+// interface type must hold a type registered by gob.Register for gob codecs,
+// otherwise the call fails.
+// A method may also take a context.Context as the first argument,
+// it carries the values of the call, see SetResponseMetadata.
+// Methods with both value and pointer receivers are published.
+// If rcvr is not a pointer, the server calls the methods on its own
+// copy of rcvr, so pointer receiver methods don't modify rcvr itself.
+// A receiver implementing Initializer or io.Closer is set up and torn down
+// by them, see Initializer.
 func (server *Server) Register(rcvr interface{}) error {
+	return server.RegisterWithOption(rcvr, ServiceOption{})
+}
+
+// ServiceOption configures a service registered by RegisterWithOption.
+type ServiceOption struct {
+	// HandleTimeout is the handle timeout of the methods of the service,
+	// overriding Option.HandleTimeout of the client. 0 means unset.
+	HandleTimeout time.Duration
+	// MethodTimeouts override HandleTimeout for single methods, by method name.
+	MethodTimeouts map[string]time.Duration
+	// MaxConcurrent caps the calls of single methods running at the same
+	// time across all connections, by method name, e.g. to protect a shared
+	// downstream resource. A call beyond the cap waits up to ConcurrencyWait
+	// for a slot, then fails with code ResourceExhausted. 0 means no wait.
+	MaxConcurrent   map[string]int
+	ConcurrencyWait time.Duration
+	// CacheTTL enables caching the replies of idempotent methods for a TTL,
+	// by method name. A call with the same args within the TTL is replied
+	// from the cache without calling the method. The replies are kept in an
+	// LRU of at most CacheSize per method, 0 means 1024. The cache key is
+	// the args only, not metadata, and errors are never cached.
+	CacheTTL  map[string]time.Duration
+	CacheSize int
+	// Namespace prefixes the name of the service, e.g. the Invoice service
+	// registered under the namespace "billing" is called as
+	// "billing/Invoice.Method", so that services of the same name don't
+	// collide. Namespaces are separated by "/" and contain no ".".
+	Namespace string
+}
+
+// RegisterWithOption is like Register, with the service configured by opt.
+func (server *Server) RegisterWithOption(rcvr interface{}, opt ServiceOption) error {
+	// methods called on a nil receiver would panic as soon as they use it
+	if rcvr == nil {
+		return errors.New("rpc: can't register nil receiver")
+	}
+	if v := reflect.ValueOf(rcvr); v.Kind() == reflect.Ptr && v.IsNil() {
+		return errors.New("rpc: can't register nil receiver of type " + v.Type().String())
+	}
 	s := newService(rcvr)
+	if s.err != nil {
+		return s.err
+	}
+	if opt.Namespace != "" {
+		if !validNamespace(opt.Namespace) {
+			return errors.New("rpc: invalid namespace " + opt.Namespace)
+		}
+		s.name = opt.Namespace + "/" + s.name
+	}
+	for name := range opt.MethodTimeouts {
+		if s.method[name] == nil {
+			return errors.New("rpc: can't set timeout of unknown method " + s.name + "." + name)
+		}
+	}
+	for name, n := range opt.MaxConcurrent {
+		if s.method[name] == nil {
+			return errors.New("rpc: can't set concurrency of unknown method " + s.name + "." + name)
+		}
+		if n <= 0 {
+			return errors.New("rpc: concurrency of " + s.name + "." + name + " must be positive")
+		}
+	}
+	for name, ttl := range opt.CacheTTL {
+		m := s.method[name]
+		if m == nil {
+			return errors.New("rpc: can't cache unknown method " + s.name + "." + name)
+		}
+		if ttl <= 0 || m.isStream() || m.isChanStream() || m.isReader() {
+			return errors.New("rpc: can't cache " + s.name + "." + name + ", expect a positive ttl of a method which isn't streaming")
+		}
+	}
+	for name, m := range s.method {
+		m.timeout = opt.HandleTimeout
+		if timeout, ok := opt.MethodTimeouts[name]; ok {
+			m.timeout = timeout
+		}
+		if n, ok := opt.MaxConcurrent[name]; ok {
+			m.slots = make(chan struct{}, n)
+			m.slotWait = opt.ConcurrencyWait
+		}
+		if ttl, ok := opt.CacheTTL[name]; ok {
+			m.cache = newResultCache(ttl, opt.CacheSize)
+		}
+	}
+	if _, dup := server.serviceMap.Load(s.name); dup {
+		return errors.New("rpc: service already defined: " + s.name)
+	}
+	// the receiver is initialized before it's stored, so that it's never
+	// called uninitialized, and only once if it's published already, e.g.
+	// under another namespace
+	published := server.published(s)
+	if !published {
+		if err := initService(s); err != nil {
+			return err
+		}
+	}
+	// s is fully built and never modified after it's stored, so that
+	// connections being served never see a partially registered service
 	if _, dup := server.serviceMap.LoadOrStore(s.name, s); dup {
+		// registered concurrently, the receiver initialized above is torn
+		// down unless it's being served
+		if !published && !server.published(s) {
+			if err := closeService(s); err != nil {
+				log.Printf("rpc: close service %s error: %v", s.name, err)
+			}
+		}
 		return errors.New("rpc: service already defined: " + s.name)
 	}
 	return nil
@@ -228,6 +858,28 @@ func (server *Server) Register(rcvr interface{}) error {
 // Register publishes the receiver's methods in the DefaultServer.
 func Register(rcvr interface{}) error { return DefaultServer.Register(rcvr) }
 
+// RegisterNamespaced is like Register, with the service under namespace,
+// see ServiceOption.Namespace.
+func (server *Server) RegisterNamespaced(namespace string, rcvr interface{}) error {
+	return server.RegisterWithOption(rcvr, ServiceOption{Namespace: namespace})
+}
+
+// RegisterNamespaced publishes the receiver's methods under namespace in the DefaultServer.
+func RegisterNamespaced(namespace string, rcvr interface{}) error {
+	return DefaultServer.RegisterNamespaced(namespace, rcvr)
+}
+
+// validNamespace reports whether namespace is made of non-empty parts
+// separated by "/", without a "." which separates the method.
+func validNamespace(namespace string) bool {
+	for _, part := range strings.Split(namespace, "/") {
+		if part == "" || strings.Contains(part, ".") {
+			return false
+		}
+	}
+	return true
+}
+
 const (
 	connected        = "200 Connected to Gee RPC"
 	defaultRPCPath   = "/_geeprc_"
@@ -252,11 +904,12 @@ func (server *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 }
 
 // HandleHTTP registers an HTTP handler for RPC messages on rpcPath,
-// and a debugging handler on debugPath.
+// a debugging handler on debugPath, and the OpenAPI document on /openapi.json.
 // It is still necessary to invoke http.Serve(), typically in a go statement.
 func (server *Server) HandleHTTP() {
 	http.Handle(defaultRPCPath, server)
 	http.Handle(defaultDebugPath, debugHTTP{server})
+	http.Handle(defaultOpenAPIPath, openAPIHTTP{server})
 	log.Println("rpc server debug path:", defaultDebugPath)
 }
 
@@ -0,0 +1,832 @@
+package geerpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"geerpc/codec"
+	"io"
+	"log"
+	"math"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// startTestServer serves server on a free port and returns its address
+func startTestServer(server *Server) string {
+	l, _ := net.Listen("tcp", ":0")
+	go server.Accept(l)
+	return l.Addr().String()
+}
+
+type Sleeper int
+
+func (s Sleeper) Sleep(ms int, reply *int) error {
+	time.Sleep(time.Duration(ms) * time.Millisecond)
+	*reply = ms
+	return nil
+}
+
+func TestServer_ServeConn(t *testing.T) {
+	server := NewServer()
+	var foo Foo
+	_ = server.Register(&foo)
+	client, _ := Dial("tcp", startTestServer(server))
+	defer func() { _ = client.Close() }()
+
+	// the first request is sent right after the Option,
+	// it must not be swallowed by the Option decoder.
+	var reply int
+	err := client.Call(context.Background(), "Foo.Sum", &Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil && reply == 3, "failed to call Foo.Sum: %v", err)
+}
+
+func TestServer_FramedCodec(t *testing.T) {
+	server := NewServer()
+	var foo Foo
+	_ = server.Register(&foo)
+	client, _ := Dial("tcp", startTestServer(server), &Option{MagicNumber: MagicNumber, CodecType: codec.GobFramedType})
+	defer func() { _ = client.Close() }()
+
+	for i := 1; i <= 3; i++ {
+		var reply int
+		err := client.Call(context.Background(), "Foo.Sum", &Args{Num1: i, Num2: i}, &reply)
+		_assert(err == nil && reply == 2*i, "failed to call Foo.Sum over framed gob: %v", err)
+	}
+}
+
+type Jobs int
+
+func (j Jobs) Lookup(ms int, reply *int) error { return Sleeper(0).Sleep(ms, reply) }
+func (j Jobs) Batch(ms int, reply *int) error  { return Sleeper(0).Sleep(ms, reply) }
+
+func TestServer_MethodTimeouts(t *testing.T) {
+	server := NewServer()
+	var j Jobs
+	err := server.RegisterWithOption(&j, ServiceOption{
+		HandleTimeout:  time.Millisecond * 100,
+		MethodTimeouts: map[string]time.Duration{"Batch": time.Millisecond * 400},
+	})
+	_assert(err == nil, "failed to register with option: %v", err)
+	client, _ := Dial("tcp", startTestServer(server), &Option{HandleTimeout: time.Second})
+	defer func() { _ = client.Close() }()
+
+	var reply int
+	ctx := context.Background()
+	err = client.Call(ctx, "Jobs.Lookup", 200, &reply)
+	_assert(err != nil && strings.Contains(err.Error(), "handle timeout: expect within 100ms"), "expect the service timeout enforced, got %v", err)
+	err = client.Call(ctx, "Jobs.Batch", 200, &reply)
+	_assert(err == nil && reply == 200, "expect the method timeout to override the service one: %v", err)
+	err = client.Call(ctx, "Jobs.Batch", 600, &reply)
+	_assert(err != nil && strings.Contains(err.Error(), "handle timeout: expect within 400ms"), "expect the method timeout enforced, got %v", err)
+
+	// the timeout of the client is used if unset
+	var s Sleeper
+	_ = server.Register(&s)
+	err = client.Call(ctx, "Sleeper.Sleep", 200, &reply)
+	_assert(err == nil && reply == 200, "expect the global timeout for methods without one: %v", err)
+
+	err = NewServer().RegisterWithOption(&j, ServiceOption{MethodTimeouts: map[string]time.Duration{"Unknown": time.Second}})
+	_assert(err != nil, "expect error for the timeout of an unknown method")
+}
+
+func TestServer_JsonCodec(t *testing.T) {
+	server := NewServer()
+	var foo Foo
+	var c Catalog
+	_ = server.Register(&foo)
+	_ = server.Register(&c)
+	client, err := Dial("tcp", startTestServer(server), &Option{MagicNumber: MagicNumber, CodecType: codec.JsonType})
+	_assert(err == nil, "failed to dial with json codec: %v", err)
+	defer func() { _ = client.Close() }()
+
+	var reply int
+	err = client.Call(context.Background(), "Foo.Sum", &Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil && reply == 3, "failed to call Foo.Sum over json: %v", err)
+	var list []Item
+	err = client.Call(context.Background(), "Catalog.List", 3, &list)
+	_assert(err == nil && len(list) == 3 && list[2].Name == "item2", "failed to call Catalog.List over json: %v", err)
+	err = client.Call(context.Background(), "Foo.Unknown", &Args{}, &reply)
+	_assert(err != nil && client.IsAvailable(), "expect a server error over json, got %v", err)
+}
+
+func TestServer_PauseResume(t *testing.T) {
+	server := NewServer()
+	var foo Foo
+	_ = server.Register(&foo)
+	addr := startTestServer(server)
+	client, _ := Dial("tcp", addr)
+	defer func() { _ = client.Close() }()
+
+	server.Pause()
+	_, err := Dial("tcp", addr)
+	_assert(err != nil, "expect new connections refused while paused")
+	var reply int
+	err = client.Call(context.Background(), "Foo.Sum", &Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil && reply == 3, "expect the existing connection served while paused: %v", err)
+
+	server.Resume()
+	client2, err := Dial("tcp", addr)
+	_assert(err == nil, "expect new connections served after resume: %v", err)
+	err = client2.Call(context.Background(), "Foo.Sum", &Args{Num1: 2, Num2: 3}, &reply)
+	_assert(err == nil && reply == 5, "failed to call Foo.Sum after resume: %v", err)
+	_ = client2.Close()
+}
+
+type Proxy int
+
+// Relay returns a reply which is encoded in advance, like relaying it from another service
+func (p Proxy) Relay(args Args, reply *codec.RawReply) error {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(args.Num1 + args.Num2)
+	*reply = buf.Bytes()
+	return err
+}
+
+func TestServer_RawReply(t *testing.T) {
+	server := NewServer()
+	var p Proxy
+	var foo Foo
+	_ = server.Register(&p)
+	_ = server.Register(&foo)
+	client, _ := Dial("tcp", startTestServer(server))
+	defer func() { _ = client.Close() }()
+
+	var reply int
+	err := client.Call(context.Background(), "Proxy.Relay", &Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil && reply == 3, "failed to decode raw reply: %v", err)
+
+	var raw codec.RawReply
+	err = client.Call(context.Background(), "Proxy.Relay", &Args{Num1: 2, Num2: 3}, &raw)
+	var n int
+	_ = gob.NewDecoder(bytes.NewReader(raw)).Decode(&n)
+	_assert(err == nil && n == 5, "failed to relay raw reply: %v", err)
+
+	// the connection keeps working after raw replies
+	err = client.Call(context.Background(), "Foo.Sum", &Args{Num1: 3, Num2: 4}, &reply)
+	_assert(err == nil && reply == 7, "failed to call Foo.Sum after raw replies: %v", err)
+}
+
+func TestServer_SlowThreshold(t *testing.T) {
+	server := NewServer()
+	server.SlowThreshold = time.Millisecond * 50
+	var s Sleeper
+	_ = server.Register(&s)
+	client, _ := Dial("tcp", startTestServer(server))
+	defer func() { _ = client.Close() }()
+
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	var reply int
+	_ = client.Call(context.Background(), "Sleeper.Sleep", 0, &reply)
+	_ = client.Call(context.Background(), "Sleeper.Sleep", 100, &reply)
+	log.SetOutput(os.Stderr)
+
+	out := buf.String()
+	_assert(strings.Count(out, "slow call") == 1, "expect only 1 slow call logged: %s", out)
+	_assert(strings.Contains(out, "slow call Sleeper.Sleep(seq 2)"), "expect the slow call logged: %s", out)
+}
+
+type Blob int
+
+func (b Blob) Get(n int, reply *[]byte) error {
+	*reply = make([]byte, n)
+	return nil
+}
+
+func TestServer_CompressResponse(t *testing.T) {
+	server := NewServer()
+	var b Blob
+	var p Proxy
+	_ = server.Register(&b)
+	_ = server.Register(&p)
+	for _, codecType := range []codec.Type{codec.GobType, codec.GobFramedType, codec.GobBinaryHeaderType} {
+		opt := &Option{MagicNumber: MagicNumber, CodecType: codecType, CompressResponse: true}
+		client, _ := Dial("tcp", startTestServer(server), opt)
+
+		var blob []byte
+		h, err := client.CallWithHeader(context.Background(), "Blob.Get", 1<<20, &blob)
+		_assert(err == nil && len(blob) == 1<<20, "failed to call Blob.Get over %s: %v", codecType, err)
+		_assert(h.Compressed, "expect the response compressed over %s", codecType)
+
+		var reply int
+		h, err = client.CallWithHeader(context.Background(), "Proxy.Relay", &Args{Num1: 1, Num2: 2}, &reply)
+		_assert(err == nil && reply == 3 && h.Compressed, "failed to relay a compressed raw reply over %s: %v", codecType, err)
+		_ = client.Close()
+	}
+}
+
+func TestServer_Checksum(t *testing.T) {
+	server := NewServer()
+	var foo Foo
+	_ = server.Register(&foo)
+	for _, codecType := range []codec.Type{codec.GobType, codec.GobFramedType, codec.GobBinaryHeaderType, codec.JsonType} {
+		opt := &Option{MagicNumber: MagicNumber, CodecType: codecType, ChecksumEnabled: true}
+		client, _ := Dial("tcp", startTestServer(server), opt)
+		var reply int
+		h, err := client.CallWithHeader(context.Background(), "Foo.Sum", &Args{Num1: 1, Num2: 2}, &reply)
+		_assert(err == nil && reply == 3, "failed to call Foo.Sum over %s: %v", codecType, err)
+		_assert(h.Checksummed && h.Checksum != 0, "expect the response checksummed over %s", codecType)
+		_ = client.Close()
+	}
+}
+
+func TestServer_WriteTimeout(t *testing.T) {
+	server := NewServer()
+	server.WriteTimeout = time.Millisecond * 100
+	var b Blob
+	_ = server.Register(&b)
+	conn, _ := net.Dial("tcp", startTestServer(server))
+	defer func() { _ = conn.Close() }()
+
+	// send requests for large replies without reading them
+	_ = json.NewEncoder(conn).Encode(DefaultOption)
+	cc := codec.NewGobCodec(conn)
+	for i := 1; i <= 32; i++ {
+		_ = cc.Write(&codec.Header{ServiceMethod: "Blob.Get", Seq: uint64(i)}, 1<<20)
+	}
+	time.Sleep(time.Millisecond * 500)
+
+	// the server has given up the stuck connection, so that the stream ends
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second * 5))
+	_, err := io.Copy(io.Discard, conn)
+	_assert(err == nil, "expect the connection closed by server, got %v", err)
+}
+
+func TestServer_IdleTimeout(t *testing.T) {
+	server := NewServer()
+	var foo Foo
+	_ = server.Register(&foo)
+	client, _ := Dial("tcp", startTestServer(server), &Option{IdleTimeout: time.Millisecond * 200})
+	defer func() { _ = client.Close() }()
+
+	// keep the connection busy within the timeout
+	for i := 0; i < 5; i++ {
+		var reply int
+		err := client.Call(context.Background(), "Foo.Sum", &Args{Num1: i, Num2: i}, &reply)
+		_assert(err == nil && reply == 2*i, "failed to call Foo.Sum: %v", err)
+		time.Sleep(time.Millisecond * 100)
+	}
+	_assert(client.IsAvailable(), "expect the busy connection alive")
+
+	time.Sleep(time.Millisecond * 400)
+	_assert(!client.IsAvailable(), "expect the silent connection closed by server")
+}
+
+func TestServer_MaxRequestsPerConn(t *testing.T) {
+	server := NewServer()
+	var s Sleeper
+	_ = server.Register(&s)
+	client, _ := Dial("tcp", startTestServer(server), &Option{MaxRequestsPerConn: 3})
+	defer func() { _ = client.Close() }()
+
+	// requests in flight when the limit is hit still get their replies
+	calls := make([]*Call, 3)
+	for i := range calls {
+		calls[i] = client.Go("Sleeper.Sleep", 100, new(int), nil)
+	}
+	for i, call := range calls {
+		<-call.Done
+		_assert(call.Error == nil, "expect call %d within the limit to succeed: %v", i, call.Error)
+	}
+	var reply int
+	err := client.Call(context.Background(), "Sleeper.Sleep", 0, &reply)
+	_assert(err != nil, "expect the call over the limit to fail")
+	_assert(!client.IsAvailable(), "expect the connection closed after the limit")
+}
+
+func TestServer_FallbackCodec(t *testing.T) {
+	var foo Foo
+	opt := &Option{MagicNumber: MagicNumber, CodecType: "application/unknown"}
+
+	t.Run("strict", func(t *testing.T) {
+		server := NewServer()
+		_ = server.Register(&foo)
+		conn, _ := net.Dial("tcp", startTestServer(server))
+		defer func() { _ = conn.Close() }()
+		_ = json.NewEncoder(conn).Encode(opt)
+		_, err := readOption(conn)
+		_assert(err != nil, "expect the connection refused")
+	})
+	t.Run("lenient", func(t *testing.T) {
+		server := NewServer()
+		server.FallbackCodec = codec.GobType
+		_ = server.Register(&foo)
+		conn, _ := net.Dial("tcp", startTestServer(server))
+		defer func() { _ = conn.Close() }()
+		_ = json.NewEncoder(conn).Encode(opt)
+		ack, err := readOption(conn)
+		_assert(err == nil && ack.CodecType == codec.GobType, "expect negotiated down to gob: %v", err)
+
+		client := newClientCodec(codec.NewGobCodec(conn), ack)
+		var reply int
+		err = client.Call(context.Background(), "Foo.Sum", &Args{Num1: 1, Num2: 2}, &reply)
+		_assert(err == nil && reply == 3, "failed to call Foo.Sum over the fallback codec: %v", err)
+	})
+}
+
+type Catalog int
+
+type Item struct{ Name string }
+
+func (c Catalog) Index(n int, reply *map[string]int) error {
+	for i := 0; i < n; i++ {
+		(*reply)[fmt.Sprint("item", i)] = i
+	}
+	return nil
+}
+
+func (c Catalog) List(n int, reply *[]Item) error {
+	for i := 0; i < n; i++ {
+		*reply = append(*reply, Item{Name: fmt.Sprint("item", i)})
+	}
+	return nil
+}
+
+func (c Catalog) First(n int, reply **Item) error {
+	(*reply).Name = "item0"
+	return nil
+}
+
+func (c Catalog) Any(n int, reply *interface{}) error {
+	if n > 0 {
+		*reply = Item{Name: "item0"} // Item isn't registered by gob.Register
+	}
+	return nil
+}
+
+func TestServer_ZeroValueReply(t *testing.T) {
+	server := NewServer()
+	var c Catalog
+	_ = server.Register(&c)
+	client, _ := Dial("tcp", startTestServer(server))
+	defer func() { _ = client.Close() }()
+	ctx := context.Background()
+
+	var index map[string]int
+	err := client.Call(ctx, "Catalog.Index", 0, &index)
+	_assert(err == nil && len(index) == 0, "failed to call Catalog.Index with empty reply: %v", err)
+	err = client.Call(ctx, "Catalog.Index", 2, &index)
+	_assert(err == nil && index["item1"] == 1, "failed to call Catalog.Index: %v", err)
+
+	var list []Item
+	err = client.Call(ctx, "Catalog.List", 0, &list)
+	_assert(err == nil && len(list) == 0, "failed to call Catalog.List with empty reply: %v", err)
+	err = client.Call(ctx, "Catalog.List", 2, &list)
+	_assert(err == nil && len(list) == 2 && list[1].Name == "item1", "failed to call Catalog.List: %v", err)
+
+	var first *Item
+	err = client.Call(ctx, "Catalog.First", 0, &first)
+	_assert(err == nil && first != nil && first.Name == "item0", "failed to call Catalog.First: %v", err)
+
+	var any interface{}
+	err = client.Call(ctx, "Catalog.Any", 0, &any)
+	_assert(err == nil && any == nil, "failed to call Catalog.Any with nil reply: %v", err)
+	err = client.Call(ctx, "Catalog.Any", 1, &any)
+	_assert(err != nil && strings.Contains(err.Error(), "can't encode reply"), "expect unregistered interface reply to fail, got %v", err)
+	_assert(client.IsAvailable(), "expect the connection to survive the encoding error")
+}
+
+type Alpha int
+
+func (a Alpha) Sum(args Args, reply *int) error { *reply = args.Num1 + args.Num2; return nil }
+
+type Beta int
+
+func (b Beta) Sum(args Args, reply *int) error { *reply = args.Num1 + args.Num2; return nil }
+
+type Gamma int
+
+func (g Gamma) Sum(args Args, reply *int) error { *reply = args.Num1 + args.Num2; return nil }
+
+func TestServer_RegisterWhileServing(t *testing.T) {
+	server := NewServer()
+	var foo Foo
+	_ = server.Register(&foo)
+	client, _ := Dial("tcp", startTestServer(server))
+	defer func() { _ = client.Close() }()
+
+	var wg sync.WaitGroup
+	for _, rcvr := range []interface{}{new(Alpha), new(Beta), new(Gamma)} {
+		name := newService(rcvr).name
+		wg.Add(2)
+		registered := make(chan struct{})
+		// keep calling the service while it's being registered
+		go func() {
+			defer wg.Done()
+			for done := false; !done; {
+				select {
+				case <-registered:
+					done = true
+				default:
+				}
+				var reply int
+				err := client.Call(context.Background(), name+".Sum", &Args{Num1: 1, Num2: 2}, &reply)
+				_assert(err == nil || strings.Contains(err.Error(), "can't find service"), "unexpected error: %v", err)
+			}
+			var reply int
+			err := client.Call(context.Background(), name+".Sum", &Args{Num1: 1, Num2: 2}, &reply)
+			_assert(err == nil && reply == 3, "expect %s.Sum callable after registration: %v", name, err)
+		}()
+		go func(rcvr interface{}) {
+			defer wg.Done()
+			_ = server.Register(rcvr)
+			close(registered)
+		}(rcvr)
+	}
+	wg.Wait()
+}
+
+func TestServer_FilterMethods(t *testing.T) {
+	call := func(server *Server, serviceMethod string) error {
+		var a Alpha
+		var b Beta
+		_ = server.Register(&a)
+		_ = server.Register(&b)
+		client, _ := Dial("tcp", startTestServer(server))
+		defer func() { _ = client.Close() }()
+		var reply int
+		return client.Call(context.Background(), serviceMethod, &Args{Num1: 1, Num2: 2}, &reply)
+	}
+	t.Run("allow", func(t *testing.T) {
+		err := call(&Server{AllowMethods: []string{"Alpha.*"}}, "Alpha.Sum")
+		_assert(err == nil, "expect allowed method to be served: %v", err)
+		err = call(&Server{AllowMethods: []string{"Alpha.*"}}, "Beta.Sum")
+		_assert(err != nil && strings.Contains(err.Error(), "method not available"), "expect method not in allow list filtered, got %v", err)
+	})
+	t.Run("deny", func(t *testing.T) {
+		err := call(&Server{DenyMethods: []string{"B*.Sum"}}, "Beta.Sum")
+		_assert(err != nil && strings.Contains(err.Error(), "method not available"), "expect denied method filtered, got %v", err)
+		err = call(&Server{DenyMethods: []string{"B*.Sum"}}, "Alpha.Sum")
+		_assert(err == nil, "expect method not in deny list served: %v", err)
+	})
+	t.Run("allow and deny", func(t *testing.T) {
+		err := call(&Server{AllowMethods: []string{"*"}, DenyMethods: []string{"Alpha.*"}}, "Alpha.Sum")
+		_assert(err != nil && strings.Contains(err.Error(), "method not available"), "expect deny list to win, got %v", err)
+	})
+}
+
+func TestClient_Ping(t *testing.T) {
+	server := NewServer()
+	// the built-in ping service isn't filtered
+	server.AllowMethods = []string{"Foo.*"}
+	l, _ := net.Listen("tcp", ":0")
+	go server.Accept(l)
+	client, err := Dial("tcp", l.Addr().String())
+	_assert(err == nil, "failed to dial: %v", err)
+	defer func() { _ = client.Close() }()
+
+	err = client.Ping(context.Background())
+	_assert(err == nil, "expect the server reachable, got %v", err)
+	err = client.Call(context.Background(), "_ping.Unknown", 0, new(int))
+	_assert(err != nil && strings.Contains(err.Error(), "can't find method"), "expect an unknown built-in method error, got %v", err)
+
+	_ = l.Close()
+	server.conns.Range(func(sc, _ interface{}) bool {
+		_ = sc.(*serverConn).Close()
+		return true
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	err = client.Ping(ctx)
+	_assert(err != nil, "expect an error pinging a closed server")
+}
+
+func TestClient_Methods(t *testing.T) {
+	server := NewServer()
+	var foo Foo
+	_ = server.Register(&foo)
+	_ = server.RegisterFunc("Admin.Reset", func(args int, reply *int) error { return nil })
+	server.DenyMethods = []string{"Admin.*"}
+	for _, codecType := range []codec.Type{codec.GobType, codec.JsonType} {
+		client, _ := Dial("tcp", startTestServer(server), &Option{CodecType: codecType})
+		methods, err := client.Methods(context.Background())
+		_assert(err == nil, "failed to list the methods over %s: %v", codecType, err)
+		want := MethodDesc{Service: "Foo", Method: "Sum", ArgType: "geerpc.Args", ReplyType: "*int"}
+		// Admin.Reset is denied, it's left out
+		_assert(len(methods) == 1 && methods[0] == want, "expect only Foo.Sum listed over %s, got %+v", codecType, methods)
+		_ = client.Close()
+	}
+
+	// the listing is filtered like the registered methods, ping isn't
+	addr := startTestServer(server)
+	for _, filter := range []func(){
+		func() { server.AllowMethods, server.DenyMethods = nil, []string{"_introspect.*"} },
+		func() { server.AllowMethods, server.DenyMethods = []string{"Foo.*"}, nil },
+	} {
+		filter()
+		client, _ := Dial("tcp", addr)
+		_, err := client.Methods(context.Background())
+		_assert(err != nil && strings.Contains(err.Error(), "not available"), "expect the listing hidden by %v %v, got %v", server.AllowMethods, server.DenyMethods, err)
+		_assert(client.Ping(context.Background()) == nil, "expect ping not filtered by %v %v", server.AllowMethods, server.DenyMethods)
+		_ = client.Close()
+	}
+}
+
+// Journal appends entries, the earlier ones take longer
+type Journal struct {
+	mu      sync.Mutex
+	entries []int
+}
+
+func (j *Journal) Append(entry int, reply *int) error {
+	time.Sleep(time.Duration(10-entry) * time.Millisecond)
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.entries = append(j.entries, entry)
+	*reply = len(j.entries)
+	return nil
+}
+
+func TestServer_OrderedExecution(t *testing.T) {
+	server := NewServer()
+	j := new(Journal)
+	_ = server.Register(j)
+	client, _ := Dial("tcp", startTestServer(server), &Option{OrderedExecution: true})
+	defer func() { _ = client.Close() }()
+
+	calls := make([]*Call, 10)
+	for i := range calls {
+		calls[i] = client.Go("Journal.Append", i, new(int), nil)
+	}
+	for i, call := range calls {
+		<-call.Done
+		_assert(call.Error == nil && *call.Reply.(*int) == i+1, "expect call %d executed in order, got %d: %v", i, *call.Reply.(*int), call.Error)
+	}
+	for i, entry := range j.entries {
+		_assert(entry == i, "expect entries in order, got %v", j.entries)
+	}
+}
+
+type Nillable int
+
+func (n Nillable) Sum(args *Args, reply *int) error {
+	*reply = args.Num1 + args.Num2
+	return nil
+}
+
+func TestServer_NilArgument(t *testing.T) {
+	server := NewServer()
+	var n Nillable
+	_ = server.Register(&n)
+	addr := startTestServer(server)
+
+	// the json codec sends a nil args as null
+	client, _ := Dial("tcp", addr, &Option{CodecType: codec.JsonType})
+	defer func() { _ = client.Close() }()
+	var reply int
+	err := client.Call(context.Background(), "Nillable.Sum", nil, &reply)
+	_assert(err != nil && strings.Contains(err.Error(), "nil argument"), "expect a nil argument error, got %v", err)
+	err = client.Call(context.Background(), "Nillable.Sum", &Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil && reply == 3, "expect the connection usable after a nil argument: %v", err)
+
+	// pointer args are still decoded by gob
+	gobClient, _ := Dial("tcp", addr)
+	defer func() { _ = gobClient.Close() }()
+	err = gobClient.Call(context.Background(), "Nillable.Sum", &Args{Num1: 2, Num2: 3}, &reply)
+	_assert(err == nil && reply == 5, "failed to call over gob: %v", err)
+	err = gobClient.Call(context.Background(), "Nillable.Sum", &Args{}, &reply)
+	_assert(err == nil && reply == 0, "expect zero args not taken for nil over gob: %v", err)
+}
+
+func TestServer_RegisterNilReceiver(t *testing.T) {
+	server := NewServer()
+	err := server.Register(nil)
+	_assert(err != nil && strings.Contains(err.Error(), "nil receiver"), "expect a nil receiver error, got %v", err)
+	err = server.Register((*Nillable)(nil))
+	_assert(err != nil && strings.Contains(err.Error(), "nil receiver"), "expect a nil receiver error, got %v", err)
+}
+
+// Gauge records the maximum number of calls running at the same time
+type Gauge struct{ running, max int64 }
+
+func (g *Gauge) Hold(ms int, reply *int) error {
+	n := atomic.AddInt64(&g.running, 1)
+	defer atomic.AddInt64(&g.running, -1)
+	for {
+		max := atomic.LoadInt64(&g.max)
+		if n <= max || atomic.CompareAndSwapInt64(&g.max, max, n) {
+			break
+		}
+	}
+	return Sleeper(0).Sleep(ms, reply)
+}
+
+func TestServer_MaxConcurrent(t *testing.T) {
+	server := NewServer()
+	g := new(Gauge)
+	err := server.RegisterWithOption(g, ServiceOption{
+		MaxConcurrent:   map[string]int{"Hold": 3},
+		ConcurrencyWait: time.Second * 5,
+	})
+	_assert(err == nil, "failed to register with option: %v", err)
+	addr := startTestServer(server)
+
+	// the cap is shared by all connections
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		client, _ := Dial("tcp", addr)
+		defer func() { _ = client.Close() }()
+		for j := 0; j < 5; j++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				var reply int
+				err := client.Call(context.Background(), "Gauge.Hold", 20, &reply)
+				_assert(err == nil, "expect the call queued until a slot is free: %v", err)
+			}()
+		}
+	}
+	wg.Wait()
+	_assert(atomic.LoadInt64(&g.max) == 3, "expect at most 3 concurrent calls, got %d", g.max)
+
+	t.Run("reject", func(t *testing.T) {
+		server := NewServer()
+		_ = server.RegisterWithOption(new(Gauge), ServiceOption{MaxConcurrent: map[string]int{"Hold": 1}})
+		client, _ := Dial("tcp", startTestServer(server))
+		defer func() { _ = client.Close() }()
+		first := client.Go("Gauge.Hold", 200, new(int), nil)
+		time.Sleep(time.Millisecond * 50)
+		err := client.Call(context.Background(), "Gauge.Hold", 0, new(int))
+		_assert(IsResourceExhausted(err), "expect ResourceExhausted without ConcurrencyWait, got %v", err)
+		<-first.Done
+		_assert(first.Error == nil, "expect the first call done: %v", first.Error)
+	})
+
+	err = NewServer().RegisterWithOption(new(Gauge), ServiceOption{MaxConcurrent: map[string]int{"Unknown": 1}})
+	_assert(err != nil, "expect error for the concurrency of an unknown method")
+	err = NewServer().RegisterWithOption(new(Gauge), ServiceOption{MaxConcurrent: map[string]int{"Hold": 0}})
+	_assert(err != nil, "expect error for a non-positive concurrency")
+}
+
+type Schedule struct {
+	Start    time.Time
+	Interval time.Duration
+	Delays   []time.Duration
+}
+
+type Scheduler int
+
+// Next returns the schedule starting one interval later
+func (s Scheduler) Next(args Schedule, reply *Schedule) error {
+	*reply = args
+	reply.Start = args.Start.Add(args.Interval)
+	return nil
+}
+
+func TestServer_TimeValues(t *testing.T) {
+	server := NewServer()
+	var s Scheduler
+	_ = server.Register(&s)
+	addr := startTestServer(server)
+	start := time.Date(2021, 6, 1, 12, 0, 0, 500, time.UTC)
+	args := Schedule{Start: start, Interval: time.Minute * 90, Delays: []time.Duration{time.Millisecond, time.Second * 5}}
+	for _, codecType := range []codec.Type{codec.GobType, codec.JsonType} {
+		client, err := Dial("tcp", addr, &Option{CodecType: codecType})
+		_assert(err == nil, "failed to dial with %s: %v", codecType, err)
+		var reply Schedule
+		err = client.Call(context.Background(), "Scheduler.Next", args, &reply)
+		_assert(err == nil, "failed to call Scheduler.Next over %s: %v", codecType, err)
+		_assert(reply.Start.Equal(start.Add(time.Minute*90)) && reply.Interval == args.Interval,
+			"expect the time and the duration round-tripped over %s, got %+v", codecType, reply)
+		_assert(len(reply.Delays) == 2 && reply.Delays[1] == time.Second*5, "expect the durations round-tripped over %s, got %v", codecType, reply.Delays)
+		_ = client.Close()
+	}
+}
+
+type Shape interface{ Area() float64 }
+
+type Square struct{ Side float64 }
+
+func (s Square) Area() float64 { return s.Side * s.Side }
+
+type Drawing struct {
+	Title  string
+	Shapes []Shape
+}
+
+type Canvas int
+
+func (c Canvas) Draw(side float64, reply *Drawing) error {
+	*reply = Drawing{Title: "squares", Shapes: []Shape{Square{side}}}
+	return nil
+}
+
+var squareRegistered bool
+
+func TestRegisterGobType(t *testing.T) {
+	server := NewServer()
+	var c Canvas
+	_ = server.Register(&c)
+	client, _ := Dial("tcp", startTestServer(server))
+	defer func() { _ = client.Close() }()
+
+	var reply Drawing
+	var err error
+	// gob registrations can't be undone, e.g. by go test -count
+	if !squareRegistered {
+		err = client.Call(context.Background(), "Canvas.Draw", 2.0, &reply)
+		_assert(err != nil && strings.Contains(err.Error(), "type geerpc.Square held by an interface isn't registered"),
+			"expect an error naming the unregistered type, got %v", err)
+		_assert(client.IsAvailable(), "expect the connection to survive the encoding error")
+		RegisterGobType(Square{})
+		squareRegistered = true
+	}
+	err = client.Call(context.Background(), "Canvas.Draw", 2.0, &reply)
+	_assert(err == nil && len(reply.Shapes) == 1 && reply.Shapes[0].Area() == 4, "failed to call Canvas.Draw once registered: %v", err)
+}
+
+func TestServer_ServeConnEOF(t *testing.T) {
+	server := NewServer()
+	var foo Foo
+	_ = server.Register(&foo)
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	client, _ := Dial("tcp", startTestServer(server))
+	for i := 0; i < 100; i++ {
+		var reply int
+		err := client.Call(context.Background(), "Foo.Sum", &Args{Num1: i, Num2: i}, &reply)
+		_assert(err == nil && reply == 2*i, "failed to call Foo.Sum on a reused connection: %v", err)
+	}
+	_ = client.Close()
+	waitServing(server, 0)
+	_assert(!strings.Contains(buf.String(), "error"), "expect no error logged once the client hangs up:\n%s", buf.String())
+
+	// a header cut off is not a normal close
+	conn, _ := net.Dial("tcp", startTestServer(server))
+	_ = json.NewEncoder(conn).Encode(DefaultOption)
+	waitServing(server, 1)
+	_, _ = conn.Write([]byte{0x10, 0xff})
+	_ = conn.Close()
+	waitServing(server, 0)
+	_assert(strings.Contains(buf.String(), "read header error"), "expect a truncated header logged:\n%s", buf.String())
+}
+
+// waitServing waits until server is serving n connections
+func waitServing(server *Server, n int) {
+	for i := 0; i < 100; i++ {
+		count := 0
+		server.conns.Range(func(_, _ interface{}) bool {
+			count++
+			return true
+		})
+		if count == n {
+			return
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+}
+
+// Opaque can't be encoded by gob, it has no exported fields
+type Opaque struct{ hidden int }
+
+type Unencodable int
+
+func (u Unencodable) Opaque(n int, reply *Opaque) error {
+	reply.hidden = n
+	return nil
+}
+
+// NaN can't be encoded by encoding/json
+func (u Unencodable) NaN(n int, reply *float64) error {
+	*reply = math.NaN()
+	return nil
+}
+
+func TestServer_UnencodableReply(t *testing.T) {
+	server := NewServer()
+	var u Unencodable
+	var foo Foo
+	_ = server.Register(&u)
+	_ = server.Register(&foo)
+	addr := startTestServer(server)
+	for codecType, bad := range map[codec.Type]string{
+		codec.GobType:             "Unencodable.Opaque",
+		codec.GobFramedType:       "Unencodable.Opaque",
+		codec.GobBinaryHeaderType: "Unencodable.Opaque",
+		codec.JsonType:            "Unencodable.NaN",
+	} {
+		client, err := Dial("tcp", addr, &Option{CodecType: codecType})
+		_assert(err == nil, "failed to dial over %s: %v", codecType, err)
+		for i := 0; i < 2; i++ {
+			err = client.Call(context.Background(), bad, 1, new(Opaque))
+			var rpcErr *RPCError
+			_assert(errors.As(err, &rpcErr) && rpcErr.Code == Internal && strings.Contains(err.Error(), "can't encode reply"),
+				"expect an Internal error of %s over %s, got %v", bad, codecType, err)
+			var sum int
+			err = client.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: i}, &sum)
+			_assert(err == nil && sum == 1+i, "expect the connection kept over %s, got %v", codecType, err)
+		}
+		_ = client.Close()
+	}
+}
@@ -1,10 +1,18 @@
 package geerpc
 
 import (
+	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
 	"go/ast"
+	"io"
+	"io/ioutil"
 	"log"
 	"reflect"
+	"strings"
 	"sync/atomic"
+	"time"
 )
 
 type methodType struct {
@@ -12,24 +20,102 @@ type methodType struct {
 	ArgType   reflect.Type
 	ReplyType reflect.Type
 	numCalls  uint64
+	withCtx   bool          // method takes a context.Context as the first argument
+	timeout   time.Duration // handle timeout set by ServiceOption, 0 means unset
+	slots     chan struct{} // calls running, nil if ServiceOption.MaxConcurrent is unset
+	slotWait  time.Duration // how long a call waits for a slot
+	cache     *resultCache  // replies by args, nil if ServiceOption.CacheTTL is unset
+	fn        bool          // a function registered by RegisterFunc, called without a receiver
 }
 
 func (m *methodType) NumCalls() uint64 {
 	return atomic.LoadUint64(&m.numCalls)
 }
 
+// handleTimeout returns the handle timeout of m, defaultTimeout if it's unset
+func (m *methodType) handleTimeout(defaultTimeout time.Duration) time.Duration {
+	if m.timeout > 0 {
+		return m.timeout
+	}
+	return defaultTimeout
+}
+
+// acquireSlot takes a slot of the calls of m running at the same time,
+// it fails if none is free within slotWait or ctx is done
+func (m *methodType) acquireSlot(ctx context.Context) error {
+	if m.slots == nil {
+		return nil
+	}
+	select {
+	case m.slots <- struct{}{}:
+		return nil
+	default:
+	}
+	if m.slotWait > 0 {
+		timer := time.NewTimer(m.slotWait)
+		defer timer.Stop()
+		select {
+		case m.slots <- struct{}{}:
+			return nil
+		case <-timer.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return Errorf(ResourceExhausted, "rpc server: too many concurrent calls of %s, at most %d", m.method.Name, cap(m.slots))
+}
+
+// releaseSlot frees the slot taken by acquireSlot
+func (m *methodType) releaseSlot() {
+	if m.slots != nil {
+		<-m.slots
+	}
+}
+
+// isStream reports whether m is a streaming method, see ServerStream
+func (m *methodType) isStream() bool {
+	return m.ReplyType == typeOfServerStream
+}
+
+// isChanStream reports whether m streams by a channel, i.e. its reply is
+// of type chan<- R, see ServerStream.drain
+func (m *methodType) isChanStream() bool {
+	return m.ReplyType.Kind() == reflect.Chan && m.ReplyType.ChanDir() == reflect.SendDir
+}
+
+// isReader reports whether m replies an io.Reader, i.e. its reply is of
+// type *io.Reader, whose bytes are streamed, see Client.CallReader
+func (m *methodType) isReader() bool {
+	return m.ReplyType == typeOfReaderReply
+}
+
+var typeOfReaderReply = reflect.TypeOf((*io.Reader)(nil))
+
+// newArgv returns a value for the args to be decoded into, of the kinds
+// supported by checkKinds. A map is allocated, so that a method is never
+// passed a nil map it can't add to.
 func (m *methodType) newArgv() reflect.Value {
 	var argv reflect.Value
 	// arg may be a pointer type, or a value type
 	if m.ArgType.Kind() == reflect.Ptr {
 		argv = reflect.New(m.ArgType.Elem())
+		if m.ArgType.Elem().Kind() == reflect.Map {
+			argv.Elem().Set(reflect.MakeMap(m.ArgType.Elem()))
+		}
 	} else {
 		argv = reflect.New(m.ArgType).Elem()
+		if m.ArgType.Kind() == reflect.Map {
+			argv.Set(reflect.MakeMap(m.ArgType))
+		}
 	}
 	return argv
 }
 
 func (m *methodType) newReplyv() reflect.Value {
+	if m.isChanStream() {
+		// unbuffered, so that a value sent is taken before the method returns
+		return reflect.MakeChan(reflect.ChanOf(reflect.BothDir, m.ReplyType.Elem()), 0)
+	}
 	// reply must be a pointer type
 	replyv := reflect.New(m.ReplyType.Elem())
 	switch m.ReplyType.Elem().Kind() {
@@ -37,22 +123,47 @@ func (m *methodType) newReplyv() reflect.Value {
 		replyv.Elem().Set(reflect.MakeMap(m.ReplyType.Elem()))
 	case reflect.Slice:
 		replyv.Elem().Set(reflect.MakeSlice(m.ReplyType.Elem(), 0, 0))
+	case reflect.Ptr:
+		// e.g. **T, the method may fill in *reply without allocating it
+		replyv.Elem().Set(reflect.New(m.ReplyType.Elem().Elem()))
 	}
 	return replyv
 }
 
+// checkGobReply returns an error if replyv can't be encoded by gob, so that it's
+// sent to client instead of breaking the connection. The values held by the
+// interfaces of a reply must be of types registered by RegisterGobType.
+func (m *methodType) checkGobReply(replyv reflect.Value) error {
+	if m.isReader() || !holdsInterface(m.ReplyType) || replyv.Kind() == reflect.Ptr && replyv.Elem().Kind() == reflect.Interface && replyv.Elem().IsNil() {
+		return nil
+	}
+	if err := gob.NewEncoder(ioutil.Discard).Encode(replyv.Interface()); err != nil {
+		return errors.New("rpc server: " + gobTypeError("reply", replyv.Interface(), err).Error())
+	}
+	return nil
+}
+
 type service struct {
 	name   string
 	typ    reflect.Type
 	rcvr   reflect.Value
 	method map[string]*methodType
+	err    error // of the first method of unsupported kinds, see checkKinds
+	funcs  bool  // the methods are functions registered by RegisterFunc
 }
 
 func newService(rcvr interface{}) *service {
 	s := new(service)
 	s.rcvr = reflect.ValueOf(rcvr)
+	if s.rcvr.Kind() != reflect.Ptr {
+		// the method set of a value doesn't contain the methods with
+		// pointer receiver, so take a copy of the value to address it
+		ptr := reflect.New(s.rcvr.Type())
+		ptr.Elem().Set(s.rcvr)
+		s.rcvr = ptr
+	}
 	s.name = reflect.Indirect(s.rcvr).Type().Name()
-	s.typ = reflect.TypeOf(rcvr)
+	s.typ = s.rcvr.Type()
 	if !ast.IsExported(s.name) {
 		log.Fatalf("rpc server: %s is not a valid service name", s.name)
 	}
@@ -65,29 +176,97 @@ func (s *service) registerMethods() {
 	for i := 0; i < s.typ.NumMethod(); i++ {
 		method := s.typ.Method(i)
 		mType := method.Type
-		if mType.NumIn() != 3 || mType.NumOut() != 1 {
+		numIn := mType.NumIn()
+		withCtx := numIn == 4 && mType.In(1) == typeOfContext
+		if numIn != 3 && !withCtx {
 			continue
 		}
-		if mType.Out(0) != reflect.TypeOf((*error)(nil)).Elem() {
+		argType, replyType := mType.In(numIn-2), mType.In(numIn-1)
+		if !isExportedOrBuiltinType(argType) || !isExportedOrBuiltinType(replyType) {
 			continue
 		}
-		argType, replyType := mType.In(1), mType.In(2)
-		if !isExportedOrBuiltinType(argType) || !isExportedOrBuiltinType(replyType) {
+		if mType.NumOut() != 1 || mType.Out(0) != typeOfError {
+			// a method taking args and a reply is meant to be called, likely
+			// by mistake the results aren't a single error, so it's warned of
+			if checkKinds(argType, replyType) == nil {
+				log.Printf("rpc server: %s.%s not registered: expect a single result of type error, got %s", s.name, method.Name, results(mType))
+			}
+			continue
+		}
+		if err := checkKinds(argType, replyType); err != nil {
+			if s.err == nil {
+				s.err = fmt.Errorf("rpc: can't register %s.%s: %v", s.name, method.Name, err)
+			}
+			log.Printf("rpc server: %s.%s not registered: %v", s.name, method.Name, err)
 			continue
 		}
 		s.method[method.Name] = &methodType{
 			method:    method,
 			ArgType:   argType,
 			ReplyType: replyType,
+			withCtx:   withCtx,
 		}
 		log.Printf("rpc server: register %s.%s\n", s.name, method.Name)
 	}
 }
 
+var typeOfContext = reflect.TypeOf((*context.Context)(nil)).Elem()
+var typeOfError = reflect.TypeOf((*error)(nil)).Elem()
+
+// results formats the result types of the method type t, like "(int, error)"
+func results(t reflect.Type) string {
+	types := make([]string, t.NumOut())
+	for i := range types {
+		types[i] = t.Out(i).String()
+	}
+	return "(" + strings.Join(types, ", ") + ")"
+}
+
+// checkKinds returns an error if the args or the reply of a method are of
+// kinds which can't be decoded into or encoded. The args may be of any kind
+// but chan, func and unsafe.Pointer, or a pointer to one of them. The reply
+// must be a pointer to such a kind, or a chan<- of a stream, see ServerStream.
+func checkKinds(argType, replyType reflect.Type) error {
+	if !isValueKind(argType) {
+		return fmt.Errorf("args of type %s aren't supported", argType)
+	}
+	switch {
+	case replyType.Kind() == reflect.Ptr && isValueKind(replyType.Elem()):
+	case replyType.Kind() == reflect.Chan && replyType.ChanDir() == reflect.SendDir && isValueKind(replyType.Elem()):
+	default:
+		return fmt.Errorf("reply of type %s isn't supported, expect a pointer or a chan<-", replyType)
+	}
+	return nil
+}
+
+// isValueKind reports whether values of t can be encoded and decoded
+func isValueKind(t reflect.Type) bool {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Chan, reflect.Func, reflect.UnsafePointer:
+		return false
+	}
+	return true
+}
+
 func (s *service) call(m *methodType, argv, replyv reflect.Value) error {
+	return s.callContext(context.Background(), m, argv, replyv)
+}
+
+// callContext calls the method, ctx is passed in if the method takes it
+func (s *service) callContext(ctx context.Context, m *methodType, argv, replyv reflect.Value) error {
 	atomic.AddUint64(&m.numCalls, 1)
 	f := m.method.Func
-	returnValues := f.Call([]reflect.Value{s.rcvr, argv, replyv})
+	in := []reflect.Value{s.rcvr, argv, replyv}
+	if m.withCtx {
+		in = []reflect.Value{s.rcvr, reflect.ValueOf(ctx), argv, replyv}
+	}
+	if m.fn {
+		in = in[1:]
+	}
+	returnValues := f.Call(in)
 	if errInter := returnValues[0].Interface(); errInter != nil {
 		return errInter.(error)
 	}
@@ -1,8 +1,15 @@
 package geerpc
 
 import (
+	"bytes"
+	"context"
 	"fmt"
+	"geerpc/codec"
+	"log"
+	"os"
 	"reflect"
+	"sort"
+	"strings"
 	"testing"
 )
 
@@ -46,3 +53,156 @@ func TestMethodType_Call(t *testing.T) {
 	err := s.call(mType, argv, replyv)
 	_assert(err == nil && *replyv.Interface().(*int) == 4 && mType.NumCalls() == 1, "failed to call Foo.Sum")
 }
+
+type Counter struct{ N int }
+
+func (c Counter) Get(args int, reply *int) error {
+	*reply = c.N + args
+	return nil
+}
+
+func (c *Counter) Add(args int, reply *int) error {
+	c.N += args
+	*reply = c.N
+	return nil
+}
+
+func TestNewService_Receivers(t *testing.T) {
+	for _, rcvr := range []interface{}{Counter{N: 1}, &Counter{N: 1}} {
+		s := newService(rcvr)
+		_assert(s.name == "Counter" && len(s.method) == 2, "expect Get and Add registered for %T, got %d methods", rcvr, len(s.method))
+		// Add modifies the receiver, Get observes it
+		for _, c := range []struct {
+			name string
+			want int
+		}{{"Add", 2}, {"Get", 3}} {
+			name, want := c.name, c.want
+			mType := s.method[name]
+			argv, replyv := mType.newArgv(), mType.newReplyv()
+			argv.Set(reflect.ValueOf(1))
+			err := s.call(mType, argv, replyv)
+			_assert(err == nil && *replyv.Interface().(*int) == want, "failed to call %T.%s", rcvr, name)
+		}
+	}
+}
+
+// Kinds has methods of args of various kinds
+type Kinds int
+
+func (k Kinds) Keys(args map[string]int, reply *[]string) error {
+	for key := range args {
+		*reply = append(*reply, key)
+	}
+	sort.Strings(*reply)
+	return nil
+}
+
+func (k Kinds) Sum(args []int, reply *int) error {
+	for _, n := range args {
+		*reply += n
+	}
+	return nil
+}
+
+func (k Kinds) Count(args *map[string]int, reply *int) error {
+	(*args)["counted"] = 1 // the map is allocated even if the args are empty
+	*reply = len(*args)
+	return nil
+}
+
+type Pipes int
+
+func (p Pipes) Open(args chan int, reply *int) error { return nil }
+
+type Callbacks int
+
+func (c Callbacks) Run(args int, reply *func()) error { return nil }
+
+func TestServer_RegisterKinds(t *testing.T) {
+	server := NewServer()
+	var k Kinds
+	err := server.Register(&k)
+	_assert(err == nil, "expect map and slice args registered: %v", err)
+	for _, codecType := range []codec.Type{codec.GobType, codec.JsonType} {
+		client, err := Dial("tcp", startTestServer(server), &Option{CodecType: codecType})
+		_assert(err == nil, "failed to dial: %v", err)
+		var keys []string
+		err = client.Call(context.Background(), "Kinds.Keys", map[string]int{"b": 2, "a": 1}, &keys)
+		_assert(err == nil && strings.Join(keys, ",") == "a,b", "failed to call Kinds.Keys over %s: %v %v", codecType, err, keys)
+		var sum int
+		err = client.Call(context.Background(), "Kinds.Sum", []int{1, 2, 3}, &sum)
+		_assert(err == nil && sum == 6, "failed to call Kinds.Sum over %s: %v %d", codecType, err, sum)
+		var n int
+		err = client.Call(context.Background(), "Kinds.Count", map[string]int{}, &n)
+		_assert(err == nil && n == 1, "failed to call Kinds.Count over %s: %v %d", codecType, err, n)
+		_ = client.Close()
+	}
+
+	var p Pipes
+	err = server.Register(&p)
+	_assert(err != nil && strings.Contains(err.Error(), "Pipes.Open") && strings.Contains(err.Error(), "chan int"),
+		"expect chan args rejected at registration, got %v", err)
+	var c Callbacks
+	err = server.Register(&c)
+	_assert(err != nil && strings.Contains(err.Error(), "Callbacks.Run"), "expect func reply rejected at registration, got %v", err)
+}
+
+type Greeter struct{ Prefix string }
+
+func (g *Greeter) Greet(name string, reply *string) error {
+	*reply = g.Prefix + name
+	return nil
+}
+
+func TestServer_RegisterNamespaced(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	_assert(server.Register(&Greeter{Prefix: "hello "}) == nil, "failed to register Greeter")
+	_assert(server.RegisterNamespaced("billing", &Greeter{Prefix: "invoice for "}) == nil, "failed to register billing/Greeter")
+	_assert(server.RegisterNamespaced("acme/shipping", &Greeter{Prefix: "parcel for "}) == nil, "failed to register acme/shipping/Greeter")
+	err := server.RegisterNamespaced("billing", &Greeter{})
+	_assert(err != nil && strings.Contains(err.Error(), "already defined"), "expect billing/Greeter defined once, got %v", err)
+	for _, namespace := range []string{"billing/", "/billing", "a//b", "billing.v2"} {
+		_assert(server.RegisterNamespaced(namespace, &Greeter{}) != nil, "expect namespace %q rejected", namespace)
+	}
+
+	client, _ := Dial("tcp", startTestServer(server))
+	defer func() { _ = client.Close() }()
+	for serviceMethod, want := range map[string]string{
+		"Greeter.Greet":               "hello gee",
+		"billing/Greeter.Greet":       "invoice for gee",
+		"acme/shipping/Greeter.Greet": "parcel for gee",
+	} {
+		var reply string
+		err := client.Call(context.Background(), serviceMethod, "gee", &reply)
+		_assert(err == nil && reply == want, "expect %q of %s, got %q: %v", want, serviceMethod, reply, err)
+	}
+	err = client.Call(context.Background(), "shipping/Greeter.Greet", "gee", new(string))
+	_assert(err != nil && strings.Contains(err.Error(), "can't find service shipping/Greeter"), "expect a partial namespace not found, got %v", err)
+}
+
+// BadResults has methods taking args and a reply which don't return a single error
+type BadResults int
+
+func (BadResults) Sum(args Args, reply *int) error         { *reply = args.Num1 + args.Num2; return nil }
+func (BadResults) Count(args Args, reply *int) int         { return 0 }
+func (BadResults) Both(args Args, reply *int) (int, error) { return 0, nil }
+func (BadResults) Exec(args Args, reply *int)              {}
+func (BadResults) Helper(a, b int) int                     { return a + b }
+
+func TestNewService_BadResults(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	var b BadResults
+	s := newService(&b)
+	log.SetOutput(os.Stderr)
+	_assert(len(s.method) == 1 && s.method["Sum"] != nil, "expect only BadResults.Sum registered, got %v", s.method)
+	for _, warning := range []string{
+		"BadResults.Count not registered: expect a single result of type error, got (int)",
+		"BadResults.Both not registered: expect a single result of type error, got (int, error)",
+		"BadResults.Exec not registered: expect a single result of type error, got ()",
+	} {
+		_assert(strings.Contains(buf.String(), warning), "expect warning %q, got %s", warning, buf.String())
+	}
+	_assert(!strings.Contains(buf.String(), "Helper"), "expect no warning of a method not taking a reply, got %s", buf.String())
+}
@@ -0,0 +1,108 @@
+package geerpc
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// ShutdownOption sets the timeout of each phase of Shutdown, 0 means no limit.
+type ShutdownOption struct {
+	// StopReading is how long to wait for connections to stop reading requests.
+	StopReading time.Duration
+	// Drain is how long to wait for the requests being handled.
+	Drain time.Duration
+	// Close is how long to wait for the handlers still running once their
+	// connections are closed and their contexts are cancelled.
+	Close time.Duration
+}
+
+// Shutdown stops the server in phases, each logged when it starts:
+//  1. stop accepting: the listeners being accepted are closed
+//  2. stop reading: connections stop reading new requests
+//  3. drain: the requests being handled are finished and replied,
+//     each connection is closed as soon as it has no requests left
+//  4. close: the rest of connections are closed, and the contexts
+//     of their calls are cancelled, then the services are closed,
+//     see Register
+//
+// A phase moves on when its timeout expires, so a stuck handler doesn't
+// block the whole shutdown. It returns an error if some handlers are still
+// running at the end. The server can't be used anymore after Shutdown.
+func (server *Server) Shutdown(opt ShutdownOption) error {
+	atomic.StoreInt32(&server.shutdown, 1)
+
+	log.Println("rpc server: shutdown: stop accepting")
+	server.listeners.Range(func(lis, _ interface{}) bool {
+		_ = lis.(net.Listener).Close()
+		return true
+	})
+
+	var conns []*serverConn
+	server.conns.Range(func(sc, _ interface{}) bool {
+		conns = append(conns, sc.(*serverConn))
+		return true
+	})
+
+	log.Printf("rpc server: shutdown: stop reading %d connections", len(conns))
+	for _, sc := range conns {
+		sc.drain()
+	}
+	if n := waitConns(conns, opt.StopReading, func(sc *serverConn) chan struct{} { return sc.reading }); n > 0 {
+		log.Printf("rpc server: shutdown: %d connections still reading after %s", n, opt.StopReading)
+	}
+
+	log.Println("rpc server: shutdown: drain in-flight requests")
+	if n := waitConns(conns, opt.Drain, func(sc *serverConn) chan struct{} { return sc.done }); n > 0 {
+		log.Printf("rpc server: shutdown: %d connections still handling requests after %s", n, opt.Drain)
+	}
+
+	log.Println("rpc server: shutdown: close connections")
+	for _, sc := range conns {
+		select {
+		case <-sc.done:
+		default:
+			sc.cancelAll()
+			_ = sc.Close()
+		}
+	}
+	n := waitConns(conns, opt.Close, func(sc *serverConn) chan struct{} { return sc.done })
+	closeErr := server.closeServices()
+	if n > 0 {
+		return fmt.Errorf("rpc server: shutdown: %d connections still handling requests after close", n)
+	}
+	if closeErr != nil {
+		return fmt.Errorf("rpc server: shutdown: %v", closeErr)
+	}
+	log.Println("rpc server: shutdown: done")
+	return nil
+}
+
+// waitConns waits up to timeout until the channel of every connection got by ch is closed,
+// it returns the number of connections it has given up.
+func waitConns(conns []*serverConn, timeout time.Duration, ch func(sc *serverConn) chan struct{}) int {
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	for i, sc := range conns {
+		select {
+		case <-ch(sc):
+		case <-expired:
+			n := 0
+			for _, sc := range conns[i:] {
+				select {
+				case <-ch(sc):
+				default:
+					n++
+				}
+			}
+			return n
+		}
+	}
+	return 0
+}
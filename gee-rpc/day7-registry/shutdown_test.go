@@ -0,0 +1,110 @@
+package geerpc
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServer_Shutdown(t *testing.T) {
+	server := NewServer()
+	var s Sleeper
+	c := &Canceler{cancelled: make(chan struct{}, 1)}
+	_ = server.Register(&s)
+	_ = server.Register(c)
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	go server.Accept(l)
+	client1, _ := Dial("tcp", l.Addr().String())
+	client2, _ := Dial("tcp", l.Addr().String())
+	defer func() { _ = client1.Close(); _ = client2.Close() }()
+
+	quick := []*Call{
+		client1.Go("Sleeper.Sleep", 50, new(int), nil),
+		client1.Go("Sleeper.Sleep", 100, new(int), nil),
+		client2.Go("Sleeper.Sleep", 100, new(int), nil),
+	}
+	slow := client2.Go("Canceler.Wait", 0, new(int), nil)
+	time.Sleep(time.Millisecond * 20)
+
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	start := time.Now()
+	err := server.Shutdown(ShutdownOption{StopReading: time.Millisecond * 100, Drain: time.Millisecond * 300, Close: time.Millisecond * 100})
+	d := time.Since(start)
+	log.SetOutput(os.Stderr)
+
+	_assert(err == nil, "expect the slow handler to stop by cancellation: %v", err)
+	_assert(d < time.Second, "expect the slow handler not to block shutdown, took %s", d)
+	for i, call := range quick {
+		<-call.Done
+		_assert(call.Error == nil, "expect quick call %d finished: %v", i, call.Error)
+	}
+	<-slow.Done
+	_assert(slow.Error != nil, "expect the slow call failed by shutdown")
+	select {
+	case <-c.cancelled:
+	default:
+		t.Fatal("expect the context of the slow call cancelled")
+	}
+	for _, phase := range []string{"stop accepting", "stop reading", "drain", "close connections", "done"} {
+		_assert(strings.Contains(buf.String(), "shutdown: "+phase), "expect phase %q logged", phase)
+	}
+	_, err = Dial("tcp", l.Addr().String())
+	_assert(err != nil, "expect the listener closed")
+}
+
+func TestServer_ShutdownStuck(t *testing.T) {
+	server := NewServer()
+	var s Sleeper
+	_ = server.Register(&s)
+	client, _ := Dial("tcp", startTestServer(server))
+	defer func() { _ = client.Close() }()
+
+	// Sleeper ignores cancellation
+	call := client.Go("Sleeper.Sleep", 500, new(int), nil)
+	time.Sleep(time.Millisecond * 20)
+	start := time.Now()
+	err := server.Shutdown(ShutdownOption{Drain: time.Millisecond * 100, Close: time.Millisecond * 100})
+	_assert(err != nil, "expect error for the stuck handler")
+	_assert(time.Since(start) < time.Millisecond*400, "expect shutdown to give up the stuck handler")
+	<-call.Done
+	_assert(call.Error != nil, "expect the stuck call failed by shutdown")
+}
+
+func TestServer_Serve(t *testing.T) {
+	server := NewServer()
+	var s Sleeper
+	_ = server.Register(&s)
+	public, _ := net.Listen("tcp", "127.0.0.1:0")
+	internal, _ := net.Listen("tcp", "127.0.0.1:0")
+	served := make(chan struct{})
+	go func() {
+		server.Serve(public, internal)
+		close(served)
+	}()
+
+	for _, l := range []net.Listener{public, internal} {
+		client, err := Dial("tcp", l.Addr().String())
+		_assert(err == nil, "failed to dial %s: %v", l.Addr(), err)
+		var reply int
+		err = client.Call(context.Background(), "Sleeper.Sleep", 1, &reply)
+		_assert(err == nil, "failed to call via %s: %v", l.Addr(), err)
+		_ = client.Close()
+	}
+
+	_ = server.Shutdown(ShutdownOption{})
+	select {
+	case <-served:
+	case <-time.After(time.Second):
+		t.Fatal("expect Serve to return once shut down")
+	}
+	for _, l := range []net.Listener{public, internal} {
+		_, err := Dial("tcp", l.Addr().String())
+		_assert(err != nil, "expect %s closed by shutdown", l.Addr())
+	}
+}
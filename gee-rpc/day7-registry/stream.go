@@ -0,0 +1,267 @@
+package geerpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"geerpc/codec"
+	"io"
+	"log"
+	"reflect"
+	"sync"
+)
+
+// ServerStream is the reply of a streaming method, e.g.
+//
+//	func (t *T) List(args Args, stream *geerpc.ServerStream) error
+//
+// A method may also send the values to an out chan<- R, see drain.
+// Every value sent by the method is a frame of the response, flagged by
+// Header.More. The final frame carries the error of the method and the
+// trailer.
+type ServerStream struct {
+	mu      sync.Mutex // protect following
+	send    func(v interface{}) error
+	trailer *responseMetadata
+	closed  bool // the final frame is sent
+}
+
+var typeOfServerStream = reflect.TypeOf((*ServerStream)(nil))
+
+var errStreamClosed = errors.New("rpc server: stream is closed")
+
+// Send sends v to client as a frame of the stream.
+func (s *ServerStream) Send(v interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed || s.send == nil {
+		return errStreamClosed
+	}
+	return s.send(v)
+}
+
+// SetTrailer sets a metadata pair sent along with the final frame,
+// e.g. a total count or a pagination cursor.
+// The client reads it by ClientStream.Trailer.
+func (s *ServerStream) SetTrailer(key, value string) {
+	if s.trailer == nil {
+		return
+	}
+	s.trailer.mu.Lock()
+	defer s.trailer.mu.Unlock()
+	if s.trailer.md == nil {
+		s.trailer.md = make(map[string]string)
+	}
+	s.trailer.md[key] = value
+}
+
+// start makes s send frames of the call h belongs to, the trailer is
+// merged into the response metadata rm
+func (s *ServerStream) start(cc codec.Codec, h *codec.Header, rm *responseMetadata, sending *sender) {
+	s.trailer = rm
+	s.send = func(v interface{}) error {
+		return sending.write(cc, &codec.Header{ServiceMethod: h.ServiceMethod, Seq: h.Seq, More: true}, v)
+	}
+}
+
+// drain sends the values the method sends to ch as frames, for a method
+// taking an out chan<- R instead of a *ServerStream, e.g.
+//
+//	func (t *T) List(args Args, out chan<- Item) error
+//
+// It stops once ch is closed or the method has returned, so a method
+// returning an error doesn't have to close ch, but it must not send to ch
+// after it returns. Values are discarded once a frame fails to be sent, so
+// that the method isn't blocked. The channel returned is closed when it stops.
+func (s *ServerStream) drain(ch reflect.Value, returned <-chan struct{}) <-chan struct{} {
+	drained := make(chan struct{})
+	go func() {
+		defer close(drained)
+		cases := []reflect.SelectCase{
+			{Dir: reflect.SelectRecv, Chan: ch},
+			{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(returned)},
+		}
+		var err error
+		for {
+			chosen, v, ok := reflect.Select(cases)
+			if chosen == 1 || !ok {
+				return
+			}
+			if err == nil {
+				err = s.Send(v.Interface())
+			}
+		}
+	}()
+	return drained
+}
+
+// readerChunkSize is the maximum size of a frame of an io.Reader reply
+const readerChunkSize = 32 << 10
+
+// sendReader sends the bytes of r replied by a method, see Client.CallReader,
+// as frames of at most readerChunkSize bytes without reading r ahead.
+// r is closed if it's an io.Closer, a nil r is empty.
+func (s *ServerStream) sendReader(ri interface{}) error {
+	r, _ := ri.(io.Reader)
+	if r == nil {
+		return nil
+	}
+	if c, ok := r.(io.Closer); ok {
+		defer func() { _ = c.Close() }()
+	}
+	buf := make([]byte, readerChunkSize)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			// the frame is encoded before Send returns, buf can be reused
+			if sendErr := s.Send(buf[:n]); sendErr != nil {
+				return sendErr
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// close makes Send fail, it's called before the final frame is sent
+func (s *ServerStream) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+}
+
+// stream delivers the frames of a call to the channel of the caller
+type stream struct {
+	ch       reflect.Value // chan of the items
+	quit     chan struct{} // closed when the stream ends
+	quitOnce sync.Once
+	mu       sync.Mutex // protect following
+	closed   bool       // ch is closed
+}
+
+func newStream(ch interface{}) *stream {
+	chv := reflect.ValueOf(ch)
+	if chv.Kind() != reflect.Chan || chv.Type().ChanDir()&reflect.SendDir == 0 {
+		log.Panic("rpc client: stream items must be received by a channel")
+	}
+	return &stream{ch: chv, quit: make(chan struct{})}
+}
+
+// recv decodes the body of a frame and sends it to the channel,
+// it gives up sending if the stream ends in the meantime
+func (s *stream) recv(cc codec.Codec) error {
+	item := reflect.New(s.ch.Type().Elem())
+	if err := cc.ReadBody(item.Interface()); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.closed {
+		reflect.Select([]reflect.SelectCase{
+			{Dir: reflect.SelectSend, Chan: s.ch, Send: item.Elem()},
+			{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(s.quit)},
+		})
+	}
+	return nil
+}
+
+// finish ends the stream and closes the channel, a blocked recv returns first
+func (s *stream) finish() {
+	s.quitOnce.Do(func() { close(s.quit) })
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.closed {
+		s.closed = true
+		s.ch.Close()
+	}
+}
+
+// ClientStream is a call of a streaming method, see Client.Stream.
+type ClientStream struct {
+	call *Call
+	once sync.Once
+}
+
+// Stream invokes the streaming method serviceMethod, the items sent by
+// the method are sent to ch, a channel of their type, which is closed
+// when the stream ends. Like the done channel of Go, a slow receiver
+// of ch holds up the other calls of the client.
+// Cancelling ctx ends the stream and cancels the method.
+func (client *Client) Stream(ctx context.Context, serviceMethod string, args, ch interface{}) *ClientStream {
+	call := &Call{
+		ServiceMethod: serviceMethod,
+		Args:          args,
+		Done:          make(chan *Call, 1),
+		stream:        newStream(ch),
+		metadata:      client.contextMetadata(ctx),
+		priority:      priorityOf(ctx),
+	}
+	client.send(call)
+	go func() {
+		select {
+		case <-ctx.Done():
+			if client.removeCall(call.Seq) != nil {
+				client.cancel(call)
+				call.Error = fmt.Errorf("rpc client: call failed: %w", ctx.Err())
+				call.done()
+			}
+		case <-call.stream.quit:
+		}
+	}()
+	return &ClientStream{call: call}
+}
+
+// CallReader invokes the method serviceMethod which replies an io.Reader,
+//
+//	func (t *T) Open(args Args, reply *io.Reader) error
+//
+// and returns a reader of its bytes streamed as frames, the final frame
+// ends them, so the reader returns io.EOF once they are all read, or the
+// error of the call. Like Stream, reading slowly holds up the other calls
+// of the client. Closing the reader before the end cancels the call.
+func (client *Client) CallReader(ctx context.Context, serviceMethod string, args interface{}) io.ReadCloser {
+	ctx, cancel := context.WithCancel(ctx)
+	chunks := make(chan []byte)
+	s := client.Stream(ctx, serviceMethod, args, chunks)
+	pr, pw := io.Pipe()
+	go func() {
+		for chunk := range chunks {
+			if _, err := pw.Write(chunk); err != nil {
+				cancel() // closed by the reader, the chunks left are dropped
+			}
+		}
+		err := s.Wait()
+		cancel()
+		_ = pw.CloseWithError(err)
+	}()
+	return &callReader{PipeReader: pr, cancel: cancel}
+}
+
+// callReader is the reader returned by CallReader
+type callReader struct {
+	*io.PipeReader
+	cancel context.CancelFunc
+}
+
+func (r *callReader) Close() error {
+	r.cancel()
+	return r.PipeReader.Close()
+}
+
+// Wait waits until the stream ends and returns its error.
+func (s *ClientStream) Wait() error {
+	s.once.Do(func() { <-s.call.Done })
+	return s.call.Error
+}
+
+// Trailer returns the trailer set by the method, it's valid after Wait returns.
+func (s *ClientStream) Trailer() map[string]string {
+	if s.call.header == nil {
+		return nil
+	}
+	return s.call.header.Metadata
+}
@@ -0,0 +1,159 @@
+package geerpc
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+type Lister int
+
+func (l Lister) List(n int, stream *ServerStream) error {
+	for i := 0; i < n; i++ {
+		if err := stream.Send(i); err != nil {
+			return err
+		}
+	}
+	stream.SetTrailer("total", strconv.Itoa(n))
+	return nil
+}
+
+func (l Lister) Forever(ctx context.Context, n int, stream *ServerStream) error {
+	for i := 0; ; i++ {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Millisecond):
+		}
+		if err := stream.Send(i); err != nil {
+			return err
+		}
+	}
+}
+
+func TestClient_Stream(t *testing.T) {
+	server := NewServer()
+	_ = server.Register(new(Lister))
+	client, err := Dial("tcp", startTestServer(server))
+	_assert(err == nil, "failed to dial: %v", err)
+	defer func() { _ = client.Close() }()
+
+	t.Run("trailer", func(t *testing.T) {
+		ch := make(chan int)
+		stream := client.Stream(context.Background(), "Lister.List", 3, ch)
+		var items []int
+		for i := range ch {
+			items = append(items, i)
+		}
+		err := stream.Wait()
+		_assert(err == nil, "expect no error, got %v", err)
+		_assert(len(items) == 3 && items[0] == 0 && items[2] == 2, "unexpected items %v", items)
+		_assert(stream.Trailer()["total"] == "3", "expect trailer total 3, got %v", stream.Trailer())
+	})
+	t.Run("cancel", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		ch := make(chan int)
+		stream := client.Stream(ctx, "Lister.Forever", 0, ch)
+		<-ch
+		<-ch
+		cancel()
+		for range ch {
+		}
+		err := stream.Wait()
+		_assert(err != nil, "expect an error after cancel")
+		// the connection is still usable after the stream is cancelled
+		stream = client.Stream(context.Background(), "Lister.List", 1, make(chan int, 1))
+		_assert(stream.Wait() == nil, "expect the next stream to succeed")
+	})
+}
+
+func (l Lister) Emit(n int, out chan<- int) error {
+	defer close(out)
+	for i := 0; i < n; i++ {
+		out <- i * i
+	}
+	return nil
+}
+
+// Broken fails half way without closing out
+func (l Lister) Broken(n int, out chan<- int) error {
+	out <- 1
+	return errors.New("lister: broken")
+}
+
+func TestClient_StreamChan(t *testing.T) {
+	server := NewServer()
+	_ = server.Register(new(Lister))
+	client, err := Dial("tcp", startTestServer(server))
+	_assert(err == nil, "failed to dial: %v", err)
+	defer func() { _ = client.Close() }()
+
+	ch := make(chan int)
+	stream := client.Stream(context.Background(), "Lister.Emit", 4, ch)
+	var items []int
+	for i := range ch {
+		items = append(items, i)
+	}
+	_assert(stream.Wait() == nil, "expect no error, got %v", stream.Wait())
+	_assert(len(items) == 4 && items[3] == 9, "expect the values sent to out, got %v", items)
+
+	ch = make(chan int, 4)
+	stream = client.Stream(context.Background(), "Lister.Broken", 0, ch)
+	err = stream.Wait()
+	_assert(err != nil && strings.Contains(err.Error(), "broken"), "expect the error of the method, got %v", err)
+	_assert(len(ch) == 1 && <-ch == 1, "expect the value sent before the error")
+}
+
+// File replies its bytes as a reader
+type File struct{ data []byte }
+
+func (f *File) Open(n int, reply *io.Reader) error {
+	*reply = bytes.NewReader(f.data[:n])
+	return nil
+}
+
+type failingReader struct{}
+
+func (failingReader) Read([]byte) (int, error) { return 0, errors.New("disk failure") }
+
+func (f *File) Broken(n int, reply *io.Reader) error {
+	*reply = io.MultiReader(bytes.NewReader(f.data[:n]), failingReader{})
+	return nil
+}
+
+func TestClient_CallReader(t *testing.T) {
+	data := make([]byte, 5<<20)
+	rand.New(rand.NewSource(1)).Read(data)
+	server := NewServer()
+	_ = server.Register(&File{data: data})
+	client, err := Dial("tcp", startTestServer(server))
+	_assert(err == nil, "failed to dial: %v", err)
+	defer func() { _ = client.Close() }()
+
+	r := client.CallReader(context.Background(), "File.Open", len(data))
+	got, err := ioutil.ReadAll(r)
+	_ = r.Close()
+	_assert(err == nil, "expect no error, got %v", err)
+	_assert(bytes.Equal(got, data), "expect %d identical bytes, got %d", len(data), len(got))
+
+	r = client.CallReader(context.Background(), "File.Broken", 1000)
+	got, err = ioutil.ReadAll(r)
+	_ = r.Close()
+	_assert(err != nil && strings.Contains(err.Error(), "disk failure"), "expect the read error of the server, got %v", err)
+	_assert(bytes.Equal(got, data[:1000]), "expect the bytes before the error, got %d", len(got))
+
+	// closing the reader early cancels the call, the client is still usable
+	r = client.CallReader(context.Background(), "File.Open", len(data))
+	_, _ = io.ReadFull(r, make([]byte, 100))
+	_ = r.Close()
+	r = client.CallReader(context.Background(), "File.Open", 10)
+	got, err = ioutil.ReadAll(r)
+	_assert(err == nil && bytes.Equal(got, data[:10]), "expect the next call to succeed, got %v", err)
+}
@@ -0,0 +1,14 @@
+// Package main is a sample plugin for RegisterFromPlugin.
+package main
+
+type Args struct{ Num1, Num2 int }
+
+type Calc int
+
+func (c Calc) Mul(args Args, reply *int) error {
+	*reply = args.Num1 * args.Num2
+	return nil
+}
+
+// Service is looked up by RegisterFromPlugin
+var Service = new(Calc)
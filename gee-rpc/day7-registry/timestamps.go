@@ -0,0 +1,44 @@
+package geerpc
+
+import (
+	"geerpc/codec"
+	"time"
+)
+
+// The metadata keys of the response header stamped by server if the client
+// asks for it by Option.ServerTimestamps, the values are RFC 3339 times of
+// the server clock.
+const (
+	ServerReceivedKey = "geerpc-server-received"
+	ServerSentKey     = "geerpc-server-sent"
+)
+
+// stampTimes adds the times to md, the response metadata, if received
+// isn't zero. The time sent is now, right before the response is written.
+func stampTimes(md map[string]string, received time.Time) map[string]string {
+	if received.IsZero() {
+		return md
+	}
+	if md == nil {
+		md = make(map[string]string, 2)
+	}
+	md[ServerReceivedKey] = received.Format(time.RFC3339Nano)
+	md[ServerSentKey] = time.Now().Format(time.RFC3339Nano)
+	return md
+}
+
+// ServerTimes returns the times server received the request and sent the
+// response of h, the header read by CallWithHeader, ok is false if they
+// aren't stamped, see Option.ServerTimestamps. sent less received is the
+// time the call is handled by server, the rest of the round trip is spent
+// on the network. The skew of the server clock is about the midpoint of
+// received and sent less the midpoint of the round trip.
+func ServerTimes(h *codec.Header) (received, sent time.Time, ok bool) {
+	var err1, err2 error
+	received, err1 = time.Parse(time.RFC3339Nano, h.Metadata[ServerReceivedKey])
+	sent, err2 = time.Parse(time.RFC3339Nano, h.Metadata[ServerSentKey])
+	if err1 != nil || err2 != nil {
+		return time.Time{}, time.Time{}, false
+	}
+	return received, sent, true
+}
@@ -0,0 +1,40 @@
+package geerpc
+
+import (
+	"context"
+	"geerpc/codec"
+	"testing"
+	"time"
+)
+
+func TestOption_ServerTimestamps(t *testing.T) {
+	server := NewServer()
+	_ = server.RegisterFunc("Clock.Wait", func(ms int, reply *int) error {
+		time.Sleep(time.Duration(ms) * time.Millisecond)
+		*reply = ms
+		return nil
+	})
+	addr := startTestServer(server)
+	for _, codecType := range []codec.Type{codec.GobType, codec.JsonType} {
+		client, _ := Dial("tcp", addr, &Option{CodecType: codecType, ServerTimestamps: true})
+		var reply int
+		before := time.Now()
+		h, err := client.CallWithHeader(context.Background(), "Clock.Wait", 20, &reply)
+		after := time.Now()
+		_assert(err == nil && reply == 20, "failed to call Clock.Wait over %s: %v", codecType, err)
+		received, sent, ok := ServerTimes(h)
+		_assert(ok, "expect server timestamps over %s, got metadata %v", codecType, h.Metadata)
+		// the server shares the clock of the client
+		_assert(!received.Before(before) && !sent.After(after), "expect server times within [%v, %v] over %s, got %v and %v", before, after, codecType, received, sent)
+		_assert(sent.Sub(received) >= 20*time.Millisecond, "expect the call handled at least 20ms over %s, got %s", codecType, sent.Sub(received))
+		_ = client.Close()
+
+		// no timestamps unless asked for
+		client, _ = Dial("tcp", addr, &Option{CodecType: codecType})
+		h, err = client.CallWithHeader(context.Background(), "Clock.Wait", 0, &reply)
+		_assert(err == nil, "failed to call Clock.Wait over %s: %v", codecType, err)
+		_, _, ok = ServerTimes(h)
+		_assert(!ok, "expect no server timestamps over %s, got metadata %v", codecType, h.Metadata)
+		_ = client.Close()
+	}
+}
@@ -0,0 +1,107 @@
+package geerpc
+
+import (
+	"context"
+	"crypto/tls"
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// CertReloader holds the certificate of a TLS server loaded from its files,
+// and reloads it when they change, so that a renewed certificate is served
+// without a restart. The connections accepted after a reload get the new
+// certificate, the ones established keep theirs:
+//
+//	r, err := geerpc.NewCertReloader("server.crt", "server.key")
+//	go r.Watch(ctx, time.Minute)
+//	l, _ := net.Listen("tcp", ":9999")
+//	server.Accept(tls.NewListener(l, r.TLSConfig()))
+type CertReloader struct {
+	certFile, keyFile string
+	cert              atomic.Value // *tls.Certificate
+
+	mu       sync.Mutex // protect following
+	modTimes [2]time.Time
+}
+
+// NewCertReloader loads the certificate from the PEM encoded certFile and
+// keyFile, like tls.LoadX509KeyPair.
+func NewCertReloader(certFile, keyFile string) (*CertReloader, error) {
+	r := &CertReloader{certFile: certFile, keyFile: keyFile}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload loads the certificate from the files again. The certificate served
+// is kept if it fails, e.g. while the files are half written.
+func (r *CertReloader) Reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	modTimes := r.fileModTimes()
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	r.cert.Store(&cert)
+	r.modTimes = modTimes
+	return nil
+}
+
+// fileModTimes returns the modification times of the files, zero if unknown
+func (r *CertReloader) fileModTimes() [2]time.Time {
+	var modTimes [2]time.Time
+	for i, name := range []string{r.certFile, r.keyFile} {
+		if fi, err := os.Stat(name); err == nil {
+			modTimes[i] = fi.ModTime()
+		}
+	}
+	return modTimes
+}
+
+// defaultWatchInterval is how often Watch checks the files by default
+const defaultWatchInterval = time.Minute
+
+// Watch checks the files every interval and reloads the certificate once
+// either of them is modified, until ctx is done. A failed reload is logged
+// and tried again at the next check. An interval of 0 or less means
+// defaultWatchInterval.
+func (r *CertReloader) Watch(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = defaultWatchInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		r.mu.Lock()
+		changed := r.fileModTimes() != r.modTimes
+		r.mu.Unlock()
+		if !changed {
+			continue
+		}
+		if err := r.Reload(); err != nil {
+			log.Println("rpc server: reload certificate failed:", err)
+		}
+	}
+}
+
+// GetCertificate returns the certificate loaded last, it's meant to be
+// tls.Config.GetCertificate.
+func (r *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return r.cert.Load().(*tls.Certificate), nil
+}
+
+// TLSConfig returns a tls.Config serving the certificate loaded last, to be
+// customized further, e.g. by ClientAuth to verify the client certificates.
+func (r *CertReloader) TLSConfig() *tls.Config {
+	return &tls.Config{GetCertificate: r.GetCertificate}
+}
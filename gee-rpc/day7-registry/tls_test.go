@@ -0,0 +1,81 @@
+package geerpc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeTestCert writes cert and its key PEM encoded to certFile and keyFile
+func writeTestCert(cert tls.Certificate, certFile, keyFile string) {
+	key, _ := x509.MarshalECPrivateKey(cert.PrivateKey.(*ecdsa.PrivateKey))
+	_ = ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0600)
+	_ = ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: key}), 0600)
+}
+
+func TestCertReloader(t *testing.T) {
+	t.Parallel()
+	dir, _ := ioutil.TempDir("", "geerpc-tls")
+	defer func() { _ = os.RemoveAll(dir) }()
+	certFile, keyFile := filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key")
+	ca := newTestCert("test-ca", nil)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Leaf)
+	writeTestCert(newTestCert("server-v1", &ca), certFile, keyFile)
+
+	_, err := NewCertReloader(filepath.Join(dir, "missing.crt"), keyFile)
+	_assert(err != nil, "expect missing files rejected")
+	r, err := NewCertReloader(certFile, keyFile)
+	_assert(err == nil, "failed to load the certificate: %v", err)
+	// an interval of 0 is the default rather than a panic
+	stopped, stop := context.WithCancel(context.Background())
+	stop()
+	r.Watch(stopped, 0)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go r.Watch(ctx, time.Millisecond*10)
+
+	server := NewServer()
+	var foo Foo
+	_ = server.Register(&foo)
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer func() { _ = l.Close() }()
+	go server.Accept(tls.NewListener(l, r.TLSConfig()))
+
+	// served returns the common name of the certificate served to a new connection
+	served := func() string {
+		conn, err := tls.Dial("tcp", l.Addr().String(), &tls.Config{RootCAs: pool})
+		_assert(err == nil, "failed to dial: %v", err)
+		client, err := NewClient(conn, DefaultOption)
+		_assert(err == nil, "failed to create client: %v", err)
+		defer func() { _ = client.Close() }()
+		var sum int
+		err = client.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &sum)
+		_assert(err == nil && sum == 3, "failed to call Foo.Sum over TLS: %v", err)
+		return conn.ConnectionState().PeerCertificates[0].Subject.CommonName
+	}
+	_assert(served() == "server-v1", "expect the certificate loaded first")
+
+	// a half written file is ignored, the old certificate is still served
+	_ = ioutil.WriteFile(keyFile, []byte("garbage"), 0600)
+	_assert(r.Reload() != nil && served() == "server-v1", "expect the old certificate kept by a failed reload")
+
+	writeTestCert(newTestCert("server-v2", &ca), certFile, keyFile)
+	later := time.Now().Add(time.Second)
+	_ = os.Chtimes(certFile, later, later)
+	deadline := time.Now().Add(time.Second * 2)
+	name := served()
+	for name != "server-v2" && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond * 10)
+		name = served()
+	}
+	_assert(name == "server-v2", "expect the renewed certificate served, got %s", name)
+}
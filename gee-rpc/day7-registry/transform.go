@@ -0,0 +1,25 @@
+package geerpc
+
+import (
+	"geerpc/codec"
+	"sync"
+)
+
+// RegisterBodyTransform registers t under name, so that the connections of
+// Option.BodyTransform name transform their bodies by it both ways, e.g. to
+// encrypt them. The client and the server have to register the same
+// transform under the same name, a server refuses the connections asking
+// for a name it doesn't know.
+func RegisterBodyTransform(name string, t codec.BodyTransform) {
+	bodyTransforms.Store(name, t)
+}
+
+// bodyTransforms are the transforms registered by RegisterBodyTransform, name -> codec.BodyTransform
+var bodyTransforms sync.Map
+
+// bodyTransform returns the transform registered under name, nil if there's none
+func bodyTransform(name string) codec.BodyTransform {
+	t, _ := bodyTransforms.Load(name)
+	bt, _ := t.(codec.BodyTransform)
+	return bt
+}
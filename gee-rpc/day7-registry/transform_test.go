@@ -0,0 +1,69 @@
+package geerpc
+
+import (
+	"bytes"
+	"context"
+	"geerpc/codec"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// xorTransform flips the bits of every byte by key, it's its own inverse
+type xorTransform byte
+
+func (key xorTransform) Encode(body []byte) ([]byte, error) {
+	out := make([]byte, len(body))
+	for i, b := range body {
+		out[i] = b ^ byte(key)
+	}
+	return out, nil
+}
+
+func (key xorTransform) Decode(body []byte) ([]byte, error) { return key.Encode(body) }
+
+// tapConn records the bytes read from the connection
+type tapConn struct {
+	net.Conn
+	mu   sync.Mutex
+	read bytes.Buffer
+}
+
+func (c *tapConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.mu.Lock()
+	c.read.Write(p[:n])
+	c.mu.Unlock()
+	return n, err
+}
+
+func TestOption_BodyTransform(t *testing.T) {
+	RegisterBodyTransform("xor", xorTransform(0x5a))
+	const secret = "attack at dawn"
+	server := NewServer()
+	var received string
+	_ = server.RegisterFunc("Vault.Echo", func(args string, reply *string) error {
+		received = args
+		*reply = args
+		return nil
+	})
+	addr := startTestServer(server)
+	for _, codecType := range []codec.Type{codec.GobType, codec.GobFramedType, codec.GobBinaryHeaderType, codec.JsonType} {
+		conn, _ := net.Dial("tcp", addr)
+		tap := &tapConn{Conn: conn}
+		client, err := NewClient(tap, &Option{MagicNumber: MagicNumber, CodecType: codecType, BodyTransform: "xor", CompressResponse: codecType == codec.GobType})
+		_assert(err == nil, "failed to create client over %s: %v", codecType, err)
+		var reply string
+		err = client.Call(context.Background(), "Vault.Echo", secret, &reply)
+		_assert(err == nil && reply == secret, "failed to call Vault.Echo over %s: %v", codecType, err)
+		_assert(received == secret, "expect the args decrypted in the handler over %s, got %q", codecType, received)
+		tap.mu.Lock()
+		_assert(!strings.Contains(tap.read.String(), secret), "expect the reply encrypted on the wire over %s", codecType)
+		tap.mu.Unlock()
+		_ = client.Close()
+	}
+
+	_, err := Dial("tcp", addr, &Option{BodyTransform: "rot13"})
+	_assert(err != nil && strings.Contains(err.Error(), "unknown body transform"), "expect an unknown transform rejected, got %v", err)
+}
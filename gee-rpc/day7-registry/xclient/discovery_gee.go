@@ -2,8 +2,6 @@ package xclient
 
 import (
 	"log"
-	"net/http"
-	"strings"
 	"time"
 )
 
@@ -12,6 +10,10 @@ type GeeRegistryDiscovery struct {
 	registry   string
 	timeout    time.Duration
 	lastUpdate time.Time
+	// StaleTimeout is how long the last known servers are still used
+	// after they are out of date, when the registry is unreachable.
+	// 0 means Refresh fails as soon as the registry is unreachable.
+	StaleTimeout time.Duration
 }
 
 const defaultUpdateTimeout = time.Second * 10
@@ -31,18 +33,16 @@ func (d *GeeRegistryDiscovery) Refresh() error {
 		return nil
 	}
 	log.Println("rpc registry: refresh servers from registry", d.registry)
-	resp, err := http.Get(d.registry)
+	servers, err := fetchServers(d.registry)
 	if err != nil {
 		log.Println("rpc registry refresh err:", err)
-		return err
-	}
-	servers := strings.Split(resp.Header.Get("X-Geerpc-Servers"), ",")
-	d.servers = make([]string, 0, len(servers))
-	for _, server := range servers {
-		if strings.TrimSpace(server) != "" {
-			d.servers = append(d.servers, strings.TrimSpace(server))
+		if len(d.servers) > 0 && d.lastUpdate.Add(d.timeout+d.StaleTimeout).After(time.Now()) {
+			log.Println("rpc registry: warn: registry is unreachable, use the last known servers")
+			return nil
 		}
+		return err
 	}
+	d.servers = servers
 	d.lastUpdate = time.Now()
 	return nil
 }
@@ -0,0 +1,34 @@
+package xclient
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestGeeRegistryDiscovery_StaleTimeout(t *testing.T) {
+	addr := startServer(1)
+	registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("X-Geerpc-Servers", addr)
+	}))
+	d := NewGeeRegistryDiscovery(registry.URL, time.Millisecond*10)
+	d.StaleTimeout = time.Millisecond * 200
+	xc := NewXClient(d, RandomSelect, nil)
+	defer func() { _ = xc.Close() }()
+
+	var reply int
+	err := xc.Call(context.Background(), "Echo.Who", 0, &reply)
+	_assert(err == nil && reply == 1, "failed to call via registry: %v", err)
+
+	// take the registry offline
+	registry.Close()
+	time.Sleep(time.Millisecond * 50)
+	err = xc.Call(context.Background(), "Echo.Who", 0, &reply)
+	_assert(err == nil && reply == 1, "expect calls routed during the grace period: %v", err)
+
+	time.Sleep(time.Millisecond * 200)
+	_, err = d.Get(RandomSelect)
+	_assert(err != nil, "expect an error after the grace period")
+}
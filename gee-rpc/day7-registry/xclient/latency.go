@@ -0,0 +1,82 @@
+package xclient
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	latencyWindow     = 128 // latencies kept per method
+	minLatencySamples = 16  // latencies needed for a percentile
+	defaultPercentile = 0.95
+	defaultHedgeDelay = time.Millisecond * 100
+)
+
+// latencies is a ring of the recent latencies of a method
+type latencies struct {
+	mu      sync.Mutex // protect following
+	samples []time.Duration
+	next    int // where the next sample is put once samples is full
+}
+
+func (l *latencies) add(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.samples) < latencyWindow {
+		l.samples = append(l.samples, d)
+		return
+	}
+	l.samples[l.next] = d
+	l.next = (l.next + 1) % latencyWindow
+}
+
+// percentile returns the p-th percentile, false if there are too few samples
+func (l *latencies) percentile(p float64) (time.Duration, bool) {
+	l.mu.Lock()
+	sorted := append([]time.Duration(nil), l.samples...)
+	l.mu.Unlock()
+	if len(sorted) < minLatencySamples {
+		return 0, false
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	i := int(p * float64(len(sorted)))
+	if i >= len(sorted) {
+		i = len(sorted) - 1
+	}
+	return sorted[i], true
+}
+
+func (xc *XClient) trackLatency(serviceMethod string, d time.Duration) {
+	xc.latencyMu.Lock()
+	if xc.latencies == nil {
+		xc.latencies = make(map[string]*latencies)
+	}
+	l, ok := xc.latencies[serviceMethod]
+	if !ok {
+		l = new(latencies)
+		xc.latencies[serviceMethod] = l
+	}
+	xc.latencyMu.Unlock()
+	l.add(d)
+}
+
+// hedgeDelay returns how long CallHedged waits before calling a second server
+func (xc *XClient) hedgeDelay(serviceMethod string) time.Duration {
+	p := xc.HedgePercentile
+	if p <= 0 {
+		p = defaultPercentile
+	}
+	xc.latencyMu.Lock()
+	l := xc.latencies[serviceMethod]
+	xc.latencyMu.Unlock()
+	if l != nil {
+		if d, ok := l.percentile(p); ok {
+			return d
+		}
+	}
+	if xc.HedgeDelay > 0 {
+		return xc.HedgeDelay
+	}
+	return defaultHedgeDelay
+}
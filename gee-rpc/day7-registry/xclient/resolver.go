@@ -0,0 +1,163 @@
+package xclient
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Resolver resolves a target to the addresses of its servers, in the
+// "protocol@addr" format of XDial. The target is passed without its scheme.
+type Resolver interface {
+	Resolve(target string) ([]string, error)
+}
+
+// Resolvers are the resolvers selected by the scheme of a target,
+// e.g. "static://tcp@10.0.0.1:9999,tcp@10.0.0.2:9999".
+var Resolvers = map[string]Resolver{
+	"static":   StaticResolver{},
+	"dns":      DNSResolver{},
+	"registry": RegistryResolver{},
+}
+
+// StaticResolver resolves a comma separated list of addresses to itself
+type StaticResolver struct{}
+
+func (StaticResolver) Resolve(target string) ([]string, error) {
+	return splitServers(target), nil
+}
+
+// DNSResolver resolves the name of a DNS SRV record, e.g.
+// "dns://_geerpc._tcp.example.com", to the hosts and ports of the record.
+type DNSResolver struct {
+	// Protocol of the addresses, "tcp" if empty
+	Protocol string
+	// LookupSRV looks up the records of name, net.LookupSRV if nil
+	LookupSRV func(service, proto, name string) (string, []*net.SRV, error)
+}
+
+func (r DNSResolver) Resolve(target string) ([]string, error) {
+	lookup, protocol := r.LookupSRV, r.Protocol
+	if lookup == nil {
+		lookup = net.LookupSRV
+	}
+	if protocol == "" {
+		protocol = "tcp"
+	}
+	_, records, err := lookup("", "", target)
+	if err != nil {
+		return nil, err
+	}
+	servers := make([]string, 0, len(records))
+	for _, srv := range records {
+		host := strings.TrimSuffix(srv.Target, ".")
+		servers = append(servers, protocol+"@"+net.JoinHostPort(host, strconv.Itoa(int(srv.Port))))
+	}
+	return servers, nil
+}
+
+// RegistryResolver resolves the address and path of a GeeRegistry, e.g.
+// "registry://localhost:9999/_geerpc_/registry", to its alive servers.
+type RegistryResolver struct{}
+
+func (RegistryResolver) Resolve(target string) ([]string, error) {
+	return fetchServers("http://" + target)
+}
+
+// fetchServers gets the alive servers from the registry at url
+func fetchServers(url string) ([]string, error) {
+	resp, err := http.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	_ = resp.Body.Close()
+	return splitServers(resp.Header.Get("X-Geerpc-Servers")), nil
+}
+
+func splitServers(list string) []string {
+	servers := make([]string, 0)
+	for _, server := range strings.Split(list, ",") {
+		if strings.TrimSpace(server) != "" {
+			servers = append(servers, strings.TrimSpace(server))
+		}
+	}
+	return servers
+}
+
+// ResolverDiscovery is a discovery whose servers are resolved from a target
+// by the Resolver of its scheme, again once they are out of date.
+type ResolverDiscovery struct {
+	*MultiServersDiscovery
+	resolver   Resolver
+	target     string // without the scheme
+	timeout    time.Duration
+	lastUpdate time.Time
+}
+
+var _ Discovery = (*ResolverDiscovery)(nil)
+
+// NewResolverDiscovery creates a ResolverDiscovery of target, the servers
+// are resolved again after timeout, 0 means defaultUpdateTimeout.
+func NewResolverDiscovery(target string, timeout time.Duration) (*ResolverDiscovery, error) {
+	parts := strings.SplitN(target, "://", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("rpc discovery: wrong format '%s', expect scheme://target", target)
+	}
+	resolver, ok := Resolvers[parts[0]]
+	if !ok {
+		return nil, fmt.Errorf("rpc discovery: unsupported scheme %s", parts[0])
+	}
+	if timeout == 0 {
+		timeout = defaultUpdateTimeout
+	}
+	return &ResolverDiscovery{
+		MultiServersDiscovery: NewMultiServerDiscovery(make([]string, 0)),
+		resolver:              resolver,
+		target:                parts[1],
+		timeout:               timeout,
+	}, nil
+}
+
+func (d *ResolverDiscovery) Update(servers []string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.servers = servers
+	d.lastUpdate = time.Now()
+	return nil
+}
+
+func (d *ResolverDiscovery) Refresh() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.lastUpdate.Add(d.timeout).After(time.Now()) {
+		return nil
+	}
+	servers, err := d.resolver.Resolve(d.target)
+	if err != nil {
+		return err
+	}
+	if len(servers) == 0 {
+		return errors.New("rpc discovery: no servers resolved from " + d.target)
+	}
+	d.servers = servers
+	d.lastUpdate = time.Now()
+	return nil
+}
+
+func (d *ResolverDiscovery) Get(mode SelectMode) (string, error) {
+	if err := d.Refresh(); err != nil {
+		return "", err
+	}
+	return d.MultiServersDiscovery.Get(mode)
+}
+
+func (d *ResolverDiscovery) GetAll() ([]string, error) {
+	if err := d.Refresh(); err != nil {
+		return nil, err
+	}
+	return d.MultiServersDiscovery.GetAll()
+}
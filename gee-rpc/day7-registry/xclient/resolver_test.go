@@ -0,0 +1,65 @@
+package xclient
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestResolverDiscovery_Static(t *testing.T) {
+	addr1, addr2 := startServer(1), startServer(2)
+	xc, err := NewXClientTarget("static://"+addr1+", "+addr2, RoundRobinSelect, nil)
+	_assert(err == nil, "failed to create xclient: %v", err)
+	defer func() { _ = xc.Close() }()
+
+	seen := make(map[int]bool)
+	for i := 0; i < 2; i++ {
+		var reply int
+		err := xc.Call(context.Background(), "Echo.Who", 0, &reply)
+		_assert(err == nil, "failed to call: %v", err)
+		seen[reply] = true
+	}
+	_assert(seen[1] && seen[2], "expect both servers called, got %v", seen)
+
+	_, err = NewResolverDiscovery("unknown://x", 0)
+	_assert(err != nil, "expect an error of an unknown scheme")
+	_, err = NewResolverDiscovery(addr1, 0)
+	_assert(err != nil, "expect an error of a target without scheme")
+}
+
+func TestResolverDiscovery_DNS(t *testing.T) {
+	addr := startServer(1)
+	host, port, _ := net.SplitHostPort(strings.TrimPrefix(addr, "tcp@"))
+	var lookups int
+	fail := false
+	Resolvers["fakedns"] = DNSResolver{LookupSRV: func(service, proto, name string) (string, []*net.SRV, error) {
+		lookups++
+		if fail {
+			return "", nil, errors.New("no such host")
+		}
+		_assert(name == "_geerpc._tcp.example.com", "unexpected name %s", name)
+		p, _ := net.LookupPort("tcp", port)
+		return name, []*net.SRV{{Target: host + ".", Port: uint16(p)}}, nil
+	}}
+	defer delete(Resolvers, "fakedns")
+
+	d, err := NewResolverDiscovery("fakedns://_geerpc._tcp.example.com", time.Millisecond*50)
+	_assert(err == nil, "failed to create discovery: %v", err)
+	servers, err := d.GetAll()
+	_assert(err == nil && len(servers) == 1 && servers[0] == addr, "expect %s resolved, got %v %v", addr, servers, err)
+
+	xc := NewXClient(d, RandomSelect, nil)
+	defer func() { _ = xc.Close() }()
+	var reply int
+	err = xc.Call(context.Background(), "Echo.Who", 0, &reply)
+	_assert(err == nil && reply == 1, "failed to call the resolved server: %v", err)
+	_assert(lookups == 1, "expect the servers kept within timeout, got %d lookups", lookups)
+
+	fail = true
+	time.Sleep(time.Millisecond * 50)
+	_, err = d.Get(RandomSelect)
+	_assert(err != nil && lookups == 2, "expect the lookup error after timeout, got %v", err)
+}
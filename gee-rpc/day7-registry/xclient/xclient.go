@@ -2,24 +2,80 @@ package xclient
 
 import (
 	"context"
+	"errors"
 	. "geerpc"
 	"io"
+	"log"
 	"reflect"
 	"sync"
+	"time"
 )
 
 type XClient struct {
-	d       Discovery
-	mode    SelectMode
-	opt     *Option
-	mu      sync.Mutex // protect following
-	clients map[string]*Client
+	d        Discovery
+	mode     SelectMode
+	opt      *Option
+	mu       sync.Mutex // protect following
+	clients  map[string]*Client
+	sessions map[string]*session
+
+	// Retryable reports whether a failed call is retried on another server,
+	// nil means retrying on transport errors only.
+	Retryable RetryableFunc
+	// RaceAttempts is the number of servers CallFastest calls at most,
+	// 0 means all servers. RaceStagger is the delay before each next attempt
+	// is started, unless the previous ones failed, 0 means all at once.
+	RaceAttempts int
+	RaceStagger  time.Duration
+	// HedgePercentile is the percentile of the recent latencies of a method
+	// CallHedged waits for before it calls a second server, 0 means 0.95.
+	// HedgeDelay is the wait until enough latencies are tracked, 0 means 100ms.
+	HedgePercentile float64
+	HedgeDelay      time.Duration
+	// WarmupPing makes Warmup ping the servers it dials
+	WarmupPing bool
+
+	latencyMu sync.Mutex            // protect following
+	latencies map[string]*latencies // recent latencies by method
+}
+
+// RetryableFunc reports whether err is worth retrying on another server
+type RetryableFunc func(err error) bool
+
+// IsTransportError reports whether err is caused by the connection
+// rather than returned by the server.
+func IsTransportError(err error) bool {
+	return err != nil && !IsServerError(err)
+}
+
+// session pins a logical session to a server until it expires
+type session struct {
+	rpcAddr string
+	expire  time.Time
 }
 
+const defaultSessionTimeout = time.Minute * 10
+
 var _ io.Closer = (*XClient)(nil)
 
 func NewXClient(d Discovery, mode SelectMode, opt *Option) *XClient {
-	return &XClient{d: d, mode: mode, opt: opt, clients: make(map[string]*Client)}
+	return &XClient{
+		d:        d,
+		mode:     mode,
+		opt:      opt,
+		clients:  make(map[string]*Client),
+		sessions: make(map[string]*session),
+	}
+}
+
+// NewXClientTarget creates a XClient of the servers resolved from target,
+// see NewResolverDiscovery.
+func NewXClientTarget(target string, mode SelectMode, opt *Option) (*XClient, error) {
+	d, err := NewResolverDiscovery(target, 0)
+	if err != nil {
+		return nil, err
+	}
+	return NewXClient(d, mode, opt), nil
 }
 
 func (xc *XClient) Close() error {
@@ -33,18 +89,20 @@ func (xc *XClient) Close() error {
 	return nil
 }
 
-func (xc *XClient) dial(rpcAddr string) (*Client, error) {
+// dial returns the client of rpcAddr, connecting within the deadline of ctx
+// if it's sooner than Option.ConnectTimeout
+func (xc *XClient) dial(ctx context.Context, rpcAddr string) (*Client, error) {
 	xc.mu.Lock()
 	defer xc.mu.Unlock()
 	client, ok := xc.clients[rpcAddr]
 	if ok && !client.IsAvailable() {
-		_ = client.Close()
+		client.Retire() // e.g. by MaxConnLifetime, its pending calls are drained
 		delete(xc.clients, rpcAddr)
 		client = nil
 	}
 	if client == nil {
 		var err error
-		client, err = XDial(rpcAddr, xc.opt)
+		client, err = XDialContext(ctx, rpcAddr, xc.opt)
 		if err != nil {
 			return nil, err
 		}
@@ -53,8 +111,38 @@ func (xc *XClient) dial(rpcAddr string) (*Client, error) {
 	return client, nil
 }
 
+// Ping checks that the server at rpcAddr is reachable, see Client.Ping.
+func (xc *XClient) Ping(ctx context.Context, rpcAddr string) error {
+	client, err := xc.dial(ctx, rpcAddr)
+	if err != nil {
+		return err
+	}
+	return client.Ping(ctx)
+}
+
+// Warmup dials all servers of the discovery and caches their connections,
+// so that the first calls to them don't wait for dialing, e.g. at startup.
+// The servers are pinged too if WarmupPing is set. A server failed is only
+// logged, it's dialed again by the first call to it.
+func (xc *XClient) Warmup(ctx context.Context) error {
+	servers, err := xc.d.GetAll()
+	if err != nil {
+		return err
+	}
+	for _, rpcAddr := range servers {
+		client, err := xc.dial(ctx, rpcAddr)
+		if err == nil && xc.WarmupPing {
+			err = client.Ping(ctx)
+		}
+		if err != nil {
+			log.Printf("rpc xclient: warmup %s failed: %v", rpcAddr, err)
+		}
+	}
+	return nil
+}
+
 func (xc *XClient) call(rpcAddr string, ctx context.Context, serviceMethod string, args, reply interface{}) error {
-	client, err := xc.dial(rpcAddr)
+	client, err := xc.dial(ctx, rpcAddr)
 	if err != nil {
 		return err
 	}
@@ -64,14 +152,93 @@ func (xc *XClient) call(rpcAddr string, ctx context.Context, serviceMethod strin
 // Call invokes the named function, waits for it to complete,
 // and returns its error status.
 // xc will choose a proper server.
+// A failed call is retried on the other servers one by one if Retryable allows.
 func (xc *XClient) Call(ctx context.Context, serviceMethod string, args, reply interface{}) error {
 	rpcAddr, err := xc.d.Get(xc.mode)
 	if err != nil {
 		return err
 	}
+	retryable := xc.Retryable
+	if retryable == nil {
+		retryable = IsTransportError
+	}
+	tried := make(map[string]bool)
+	for {
+		err = xc.call(rpcAddr, ctx, serviceMethod, args, reply)
+		if err == nil || ctx.Err() != nil || !retryable(err) {
+			return err
+		}
+		tried[rpcAddr] = true
+		if rpcAddr = xc.untried(tried); rpcAddr == "" {
+			return err
+		}
+	}
+}
+
+// untried returns a server which isn't tried, the load balancer is preferred.
+// It's empty if all servers are tried.
+func (xc *XClient) untried(tried map[string]bool) string {
+	servers, err := xc.d.GetAll()
+	if err != nil {
+		return ""
+	}
+	for range servers {
+		if rpcAddr, err := xc.d.Get(xc.mode); err == nil && !tried[rpcAddr] {
+			return rpcAddr
+		}
+	}
+	for _, rpcAddr := range servers {
+		if !tried[rpcAddr] {
+			return rpcAddr
+		}
+	}
+	return ""
+}
+
+// CallWithSession invokes the named function on the server pinned to sessionKey,
+// so that calls of the same session hit the same server.
+// A server is selected by the load balancer for a new or expired session,
+// or when the pinned server is unavailable.
+func (xc *XClient) CallWithSession(ctx context.Context, sessionKey, serviceMethod string, args, reply interface{}) error {
+	if rpcAddr, ok := xc.sessionServer(sessionKey); ok {
+		if client, err := xc.dial(ctx, rpcAddr); err == nil {
+			xc.pinSession(sessionKey, rpcAddr)
+			return client.Call(ctx, serviceMethod, args, reply)
+		}
+	}
+	rpcAddr, err := xc.d.Get(xc.mode)
+	if err != nil {
+		return err
+	}
+	xc.pinSession(sessionKey, rpcAddr)
 	return xc.call(rpcAddr, ctx, serviceMethod, args, reply)
 }
 
+func (xc *XClient) sessionServer(sessionKey string) (string, bool) {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	s, ok := xc.sessions[sessionKey]
+	if !ok || s.expire.Before(time.Now()) {
+		return "", false
+	}
+	return s.rpcAddr, true
+}
+
+func (xc *XClient) pinSession(sessionKey, rpcAddr string) {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	now := time.Now()
+	if _, ok := xc.sessions[sessionKey]; !ok {
+		// drop expired sessions before adding a new one
+		for key, s := range xc.sessions {
+			if s.expire.Before(now) {
+				delete(xc.sessions, key)
+			}
+		}
+	}
+	xc.sessions[sessionKey] = &session{rpcAddr: rpcAddr, expire: now.Add(defaultSessionTimeout)}
+}
+
 // Broadcast invokes the named function for every server registered in discovery
 func (xc *XClient) Broadcast(ctx context.Context, serviceMethod string, args, reply interface{}) error {
 	servers, err := xc.d.GetAll()
@@ -87,17 +254,14 @@ func (xc *XClient) Broadcast(ctx context.Context, serviceMethod string, args, re
 		wg.Add(1)
 		go func(rpcAddr string) {
 			defer wg.Done()
-			var clonedReply interface{}
-			if reply != nil {
-				clonedReply = reflect.New(reflect.ValueOf(reply).Elem().Type()).Interface()
-			}
+			clonedReply := cloneReply(reply)
 			err := xc.call(rpcAddr, ctx, serviceMethod, args, clonedReply)
 			mu.Lock()
 			if err != nil && e == nil {
 				e = err
 				cancel() // if any call failed, cancel unfinished calls
 			}
-			if err == nil && !replyDone {
+			if err == nil && reply != nil && !replyDone {
 				reflect.ValueOf(reply).Elem().Set(reflect.ValueOf(clonedReply).Elem())
 				replyDone = true
 			}
@@ -107,3 +271,119 @@ func (xc *XClient) Broadcast(ctx context.Context, serviceMethod string, args, re
 	wg.Wait()
 	return e
 }
+
+// CallFastest invokes the named function on several servers, RaceAttempts
+// of them started RaceStagger apart, and returns the first successful
+// response, the other calls are cancelled. It returns the first error if
+// all of them fail.
+func (xc *XClient) CallFastest(ctx context.Context, serviceMethod string, args, reply interface{}) error {
+	servers, err := xc.raceServers(xc.RaceAttempts)
+	if err != nil {
+		return err
+	}
+	return xc.race(ctx, servers, xc.RaceStagger, serviceMethod, args, reply)
+}
+
+// CallHedged invokes the named function on a server, and on a second one
+// if no response arrives within the HedgePercentile of the recent latencies
+// of the method, then returns the first successful response like CallFastest.
+// The tail latency is cut down while most calls hit a single server.
+func (xc *XClient) CallHedged(ctx context.Context, serviceMethod string, args, reply interface{}) error {
+	servers, err := xc.raceServers(2)
+	if err != nil {
+		return err
+	}
+	return xc.race(ctx, servers, xc.hedgeDelay(serviceMethod), serviceMethod, args, reply)
+}
+
+// race calls servers one by one stagger apart, the latencies of the
+// successful calls are tracked for CallHedged
+func (xc *XClient) race(ctx context.Context, servers []string, stagger time.Duration, serviceMethod string, args, reply interface{}) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel() // the slower calls are cancelled once it returns
+	type result struct {
+		reply interface{}
+		err   error
+	}
+	results := make(chan result, len(servers))
+	started := 0
+	var due <-chan time.Time // fires when the next call is due
+	start := func() {
+		rpcAddr := servers[started]
+		started++
+		go func() {
+			clonedReply := cloneReply(reply)
+			begin := time.Now()
+			err := xc.call(rpcAddr, ctx, serviceMethod, args, clonedReply)
+			if err == nil {
+				xc.trackLatency(serviceMethod, time.Since(begin))
+			}
+			results <- result{clonedReply, err}
+		}()
+		due = nil
+		if started < len(servers) {
+			due = time.After(stagger)
+		}
+	}
+	start()
+	for stagger <= 0 && started < len(servers) {
+		start()
+	}
+	var e error
+	for done := 0; done < started; {
+		select {
+		case r := <-results:
+			done++
+			if r.err == nil {
+				if reply != nil {
+					reflect.ValueOf(reply).Elem().Set(reflect.ValueOf(r.reply).Elem())
+				}
+				return nil
+			}
+			if e == nil {
+				e = r.err
+			}
+			// don't wait for the stagger to replace the failed calls
+			if started < len(servers) && done == started {
+				start()
+			}
+		case <-due:
+			start()
+		}
+	}
+	return e
+}
+
+// raceServers returns at most n servers to race in order, those chosen by
+// the load balancer first. 0 means all servers.
+func (xc *XClient) raceServers(n int) ([]string, error) {
+	all, err := xc.d.GetAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(all) == 0 {
+		return nil, errors.New("rpc discovery: no available servers")
+	}
+	if n <= 0 || n > len(all) {
+		n = len(all)
+	}
+	tried := make(map[string]bool)
+	servers := make([]string, 0, n)
+	for len(servers) < n {
+		rpcAddr := xc.untried(tried)
+		if rpcAddr == "" {
+			break
+		}
+		tried[rpcAddr] = true
+		servers = append(servers, rpcAddr)
+	}
+	return servers, nil
+}
+
+// cloneReply returns a new value of the type reply points to, nil if reply is nil
+func cloneReply(reply interface{}) interface{} {
+	if reply == nil {
+		return nil
+	}
+	return reflect.New(reflect.ValueOf(reply).Elem().Type()).Interface()
+}
@@ -0,0 +1,258 @@
+package xclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	. "geerpc"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type Echo struct{ id int }
+
+// Who replies the id of the server
+func (e *Echo) Who(args int, reply *int) error {
+	*reply = e.id
+	return nil
+}
+
+const leader = 2
+
+// Leader only succeeds on the leader server
+func (e *Echo) Leader(args int, reply *int) error {
+	if e.id != leader {
+		return errors.New("leader moved")
+	}
+	*reply = e.id
+	return nil
+}
+
+func _assert(condition bool, msg string, v ...interface{}) {
+	if !condition {
+		panic(fmt.Sprintf("assertion failed: "+msg, v...))
+	}
+}
+
+// startServer serves an Echo with id on a free port and returns its rpcAddr
+func startServer(id int) string {
+	l, _ := net.Listen("tcp", ":0")
+	server := NewServer()
+	_ = server.Register(&Echo{id: id})
+	go server.Accept(l)
+	return "tcp@" + l.Addr().String()
+}
+
+func TestXClient_CallWithSession(t *testing.T) {
+	d := NewMultiServerDiscovery([]string{startServer(1), startServer(2), startServer(3)})
+	xc := NewXClient(d, RoundRobinSelect, nil)
+	defer func() { _ = xc.Close() }()
+
+	var first int
+	_ = xc.CallWithSession(context.Background(), "alice", "Echo.Who", 0, &first)
+	for i := 0; i < 5; i++ {
+		var reply int
+		err := xc.CallWithSession(context.Background(), "alice", "Echo.Who", 0, &reply)
+		_assert(err == nil && reply == first, "expect server %d, got %d: %v", first, reply, err)
+	}
+	// round robin moves on for calls without a session
+	var reply int
+	_ = xc.Call(context.Background(), "Echo.Who", 0, &reply)
+	_assert(reply != first, "expect another server without session")
+}
+
+// deadServer returns the rpcAddr of a closed port
+func deadServer() string {
+	l, _ := net.Listen("tcp", ":0")
+	_ = l.Close()
+	return "tcp@" + l.Addr().String()
+}
+
+func TestXClient_Retryable(t *testing.T) {
+	t.Run("transport error", func(t *testing.T) {
+		d := NewMultiServerDiscovery([]string{deadServer(), startServer(1)})
+		xc := NewXClient(d, RoundRobinSelect, nil)
+		defer func() { _ = xc.Close() }()
+		for i := 0; i < 4; i++ {
+			var reply int
+			err := xc.Call(context.Background(), "Echo.Who", 0, &reply)
+			_assert(err == nil && reply == 1, "expect retried on the live server: %v", err)
+		}
+	})
+	t.Run("retryable error", func(t *testing.T) {
+		d := NewMultiServerDiscovery([]string{startServer(1), startServer(leader), startServer(3)})
+		xc := NewXClient(d, RoundRobinSelect, nil)
+		xc.Retryable = func(err error) bool { return err.Error() == "leader moved" }
+		defer func() { _ = xc.Close() }()
+		for i := 0; i < 3; i++ {
+			var reply int
+			err := xc.Call(context.Background(), "Echo.Leader", 0, &reply)
+			_assert(err == nil && reply == leader, "expect redirected to the leader: %v", err)
+		}
+	})
+	t.Run("server error", func(t *testing.T) {
+		d := NewMultiServerDiscovery([]string{startServer(1)})
+		xc := NewXClient(d, RoundRobinSelect, nil)
+		defer func() { _ = xc.Close() }()
+		var reply int
+		err := xc.Call(context.Background(), "Echo.Leader", 0, &reply)
+		_, ok := err.(ServerError)
+		_assert(ok, "expect a server error not retried: %v", err)
+	})
+}
+
+func TestXClient_Ping(t *testing.T) {
+	live := startServer(1)
+	xc := NewXClient(NewMultiServerDiscovery([]string{live}), RandomSelect, nil)
+	defer func() { _ = xc.Close() }()
+	err := xc.Ping(context.Background(), live)
+	_assert(err == nil, "expect the live server reachable, got %v", err)
+	err = xc.Ping(context.Background(), deadServer())
+	_assert(err != nil, "expect an error pinging a closed server")
+}
+
+// Slow replies its id after delay, unless the call is cancelled
+type Slow struct {
+	id        int
+	delay     time.Duration
+	calls     int32
+	cancelled chan int
+}
+
+func (s *Slow) Wait(ctx context.Context, args int, reply *int) error {
+	atomic.AddInt32(&s.calls, 1)
+	select {
+	case <-time.After(s.delay):
+		*reply = s.id
+		return nil
+	case <-ctx.Done():
+		s.cancelled <- s.id
+		return ctx.Err()
+	}
+}
+
+// startSlowServers serves a Slow for every delay, with the index as id
+func startSlowServers(delays ...time.Duration) ([]string, []*Slow) {
+	var servers []string
+	var slows []*Slow
+	for i, delay := range delays {
+		l, _ := net.Listen("tcp", ":0")
+		server := NewServer()
+		s := &Slow{id: i, delay: delay, cancelled: make(chan int, 1)}
+		_ = server.Register(s)
+		go server.Accept(l)
+		servers = append(servers, "tcp@"+l.Addr().String())
+		slows = append(slows, s)
+	}
+	return servers, slows
+}
+
+func TestXClient_CallFastest(t *testing.T) {
+	t.Run("fastest wins", func(t *testing.T) {
+		servers, slows := startSlowServers(time.Second, 20*time.Millisecond, time.Second)
+		xc := NewXClient(NewMultiServerDiscovery(servers), RandomSelect, nil)
+		defer func() { _ = xc.Close() }()
+		var reply int
+		err := xc.CallFastest(context.Background(), "Slow.Wait", 0, &reply)
+		_assert(err == nil && reply == 1, "expect the fastest server 1, got %d: %v", reply, err)
+		for _, id := range []int{0, 2} {
+			select {
+			case <-slows[id].cancelled:
+			case <-time.After(time.Second / 2):
+				t.Fatalf("expect the call of server %d cancelled", id)
+			}
+		}
+	})
+	t.Run("stagger", func(t *testing.T) {
+		servers, slows := startSlowServers(10*time.Millisecond, 10*time.Millisecond, 10*time.Millisecond)
+		xc := NewXClient(NewMultiServerDiscovery(servers), RandomSelect, nil)
+		xc.RaceStagger = time.Second
+		defer func() { _ = xc.Close() }()
+		var reply int
+		err := xc.CallFastest(context.Background(), "Slow.Wait", 0, &reply)
+		_assert(err == nil, "failed to call the fastest: %v", err)
+		calls := int32(0)
+		for _, s := range slows {
+			calls += atomic.LoadInt32(&s.calls)
+		}
+		_assert(calls == 1, "expect no other server called within the stagger, got %d calls", calls)
+	})
+	t.Run("failed attempts", func(t *testing.T) {
+		servers, _ := startSlowServers(10 * time.Millisecond)
+		xc := NewXClient(NewMultiServerDiscovery([]string{deadServer(), deadServer(), servers[0]}), RandomSelect, nil)
+		xc.RaceStagger = time.Second
+		defer func() { _ = xc.Close() }()
+		start := time.Now()
+		var reply int
+		err := xc.CallFastest(context.Background(), "Slow.Wait", 0, &reply)
+		_assert(err == nil && reply == 0, "expect the live server answering: %v", err)
+		_assert(time.Since(start) < time.Second, "expect the failed calls replaced without the stagger")
+
+		xc = NewXClient(NewMultiServerDiscovery([]string{deadServer()}), RandomSelect, nil)
+		err = xc.CallFastest(context.Background(), "Slow.Wait", 0, &reply)
+		_assert(err != nil, "expect an error if all calls fail")
+	})
+}
+
+// pinnedDiscovery always selects primary
+type pinnedDiscovery struct {
+	*MultiServersDiscovery
+	primary string
+}
+
+func (d pinnedDiscovery) Get(mode SelectMode) (string, error) {
+	return d.primary, nil
+}
+
+func TestXClient_CallHedged(t *testing.T) {
+	servers, slows := startSlowServers(time.Second*2, 10*time.Millisecond)
+	xc := NewXClient(pinnedDiscovery{NewMultiServerDiscovery(servers), servers[0]}, RandomSelect, nil)
+	xc.HedgeDelay = time.Millisecond * 200
+	defer func() { _ = xc.Close() }()
+
+	for i := 0; i < minLatencySamples+4; i++ {
+		start := time.Now()
+		var reply int
+		err := xc.CallHedged(context.Background(), "Slow.Wait", 0, &reply)
+		_assert(err == nil && reply == 1, "expect the hedge to server 1 first, got %d: %v", reply, err)
+		_assert(time.Since(start) < time.Second, "expect the stalled primary not waited for")
+		select {
+		case <-slows[0].cancelled:
+		case <-time.After(time.Second):
+			t.Fatal("expect the stalled call cancelled")
+		}
+	}
+	// the delay follows the latencies of the server answering
+	d := xc.hedgeDelay("Slow.Wait")
+	_assert(d >= 10*time.Millisecond && d < xc.HedgeDelay, "expect the delay tracked from the latencies, got %s", d)
+	_assert(atomic.LoadInt32(&slows[0].calls) == minLatencySamples+4, "expect every call sent to the primary first")
+}
+
+func TestXClient_Warmup(t *testing.T) {
+	servers := []string{startServer(1), startServer(2), deadServer(), startServer(3)}
+	xc := NewXClient(NewMultiServerDiscovery(servers), RandomSelect, nil)
+	xc.WarmupPing = true
+	defer func() { _ = xc.Close() }()
+
+	err := xc.Warmup(context.Background())
+	_assert(err == nil, "expect a failed server not fatal, got %v", err)
+	xc.mu.Lock()
+	for i, rpcAddr := range servers {
+		client, ok := xc.clients[rpcAddr]
+		if i == 2 {
+			_assert(!ok, "expect no connection cached for the dead server")
+			continue
+		}
+		_assert(ok && client.IsAvailable(), "expect a connection cached for %s", rpcAddr)
+	}
+	xc.mu.Unlock()
+}
+
+func TestXClient_BroadcastNilReply(t *testing.T) {
+	xc := NewXClient(NewMultiServerDiscovery([]string{startServer(1), startServer(2)}), RandomSelect, nil)
+	defer func() { _ = xc.Close() }()
+	err := xc.Broadcast(context.Background(), "Echo.Who", 0, nil)
+	_assert(err == nil, "expect a broadcast discarding the replies to succeed, got %v", err)
+}